@@ -0,0 +1,145 @@
+package swissknife
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// levelAccess is the level access log lines are passed to the Logger with.
+const levelAccess = "access"
+
+// accessEntry collects what the access log line of a request reports.
+type accessEntry struct {
+	start   time.Time
+	req     *http.Request
+	outcome string
+	allowed bool
+	key     string
+	auth    time.Duration
+}
+
+// accessRecord is the access log line in the JSON format. Durations are in
+// milliseconds.
+type accessRecord struct {
+	Time       string  `json:"time"`
+	Plugin     string  `json:"plugin"`
+	Msg        string  `json:"msg"`
+	Method     string  `json:"method"`
+	Path       string  `json:"path"`
+	ClientIP   string  `json:"clientIP,omitempty"`
+	Outcome    string  `json:"outcome"`
+	Key        string  `json:"key,omitempty"`
+	Status     int     `json:"status"`
+	Bytes      int64   `json:"bytes"`
+	AuthMs     float64 `json:"authMs"`
+	DurationMs float64 `json:"durationMs,omitempty"`
+	TraceID    string  `json:"traceId,omitempty"`
+}
+
+// serveLogged serves the request and writes its access log line, with the
+// upstream status, size and duration when it was forwarded.
+func (ka *SwissKnife) serveLogged(rw http.ResponseWriter, req *http.Request) {
+	wrapped := &responseWriterWrapper{ResponseWriter: rw}
+	access := &accessEntry{start: ka.now()}
+	ka.serve(wrapped, req, access)
+	if access.req == nil {
+		return
+	}
+
+	// Rejected requests never reach the upstream, only the time to reject
+	// them is logged.
+	var elapsed time.Duration
+	if access.allowed {
+		elapsed = ka.now().Sub(access.start)
+	}
+	ka.logger.access(access, wrapped.statusCode(), wrapped.written, elapsed)
+}
+
+// access writes an access log line, whatever enableLog and logLevel are.
+// Lines about rejected requests are sampled and limited like their log lines.
+func (l *logger) access(entry *accessEntry, status int, written int64, elapsed time.Duration) {
+	if isFailureOutcome(entry.outcome) && (!l.sampled(levelInfo, true) || l.limited(levelInfo, true)) {
+		return
+	}
+
+	var line string
+	if l.accessJSON {
+		line = l.accessJSONLine(entry, status, written, elapsed)
+	} else {
+		line = l.accessCommonLine(entry, status, written, elapsed)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sink.Log(levelAccess, line)
+}
+
+// accessCommonLine formats an access log line like the Common Log Format,
+// with the key name as user, followed by the outcome and durations:
+//
+//	192.0.2.1 - partner-a [10/Oct/2024:13:55:36 +0000] "GET /v1/items HTTP/1.1" 200 512 outcome=authorized auth=0.215ms duration=35.104ms
+func (l *logger) accessCommonLine(entry *accessEntry, status int, written int64, elapsed time.Duration) string {
+	req := entry.req
+	host := "-"
+	if addr, ok := l.requestIP(req); ok {
+		host = addr.String()
+	}
+	user := "-"
+	if entry.key != "" {
+		user = entry.key
+	}
+
+	line := fmt.Sprintf("%s - %s [%s] %q %d %d outcome=%s auth=%s",
+		host, user, entry.start.Format("02/Jan/2006:15:04:05 -0700"),
+		req.Method+" "+l.redactedURL(req.URL)+" "+req.Proto,
+		status, written, entry.outcome, formatMs(entry.auth))
+	if elapsed > 0 {
+		line += " duration=" + formatMs(elapsed)
+	}
+	if l.traceHeaders {
+		if id := requestTraceID(req); id != "" {
+			line += " traceId=" + id
+		}
+	}
+	return line
+}
+
+func (l *logger) accessJSONLine(entry *accessEntry, status int, written int64, elapsed time.Duration) string {
+	req := entry.req
+	record := accessRecord{
+		Time:       entry.start.UTC().Format(time.RFC3339Nano),
+		Plugin:     l.plugin,
+		Msg:        "Access",
+		Method:     req.Method,
+		Path:       req.URL.Path,
+		Outcome:    entry.outcome,
+		Key:        entry.key,
+		Status:     status,
+		Bytes:      written,
+		AuthMs:     milliseconds(entry.auth),
+		DurationMs: milliseconds(elapsed),
+	}
+	if addr, ok := l.requestIP(req); ok {
+		record.ClientIP = addr.String()
+	}
+	if l.traceHeaders {
+		record.TraceID = requestTraceID(req)
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Sprintf("{\"level\":\"error\",\"msg\":%q}", err.Error())
+	}
+	return string(data)
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+func formatMs(d time.Duration) string {
+	return strconv.FormatFloat(milliseconds(d), 'f', 3, 64) + "ms"
+}
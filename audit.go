@@ -0,0 +1,47 @@
+package swissknife
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// auditRecord is written for every authorized request when the audit log is
+// enabled. It identifies the key by name only.
+type auditRecord struct {
+	Time     string `json:"time"`
+	Plugin   string `json:"plugin"`
+	Msg      string `json:"msg"`
+	Key      string `json:"key,omitempty"`
+	Method   string `json:"method"`
+	Path     string `json:"path"`
+	Status   int    `json:"status"`
+	ClientIP string `json:"clientIP,omitempty"`
+}
+
+// audit writes an audit record as a JSON line to the error output of the
+// sink, whatever the log format and level.
+func (l *logger) audit(req *http.Request, key string, status int) {
+	record := auditRecord{
+		Time:   time.Now().UTC().Format(time.RFC3339Nano),
+		Plugin: l.plugin,
+		Msg:    "Audit",
+		Key:    key,
+		Method: req.Method,
+		Path:   req.URL.Path,
+		Status: status,
+	}
+	if addr, ok := l.requestIP(req); ok {
+		record.ClientIP = addr.String()
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		l.error(req, outcomeAuthorized, "Error writing audit record: "+err.Error())
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sink.Log(levelAudit, string(data))
+}
@@ -0,0 +1,45 @@
+package swissknife
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// contextKey is the type of the context keys defined by the plugin.
+type contextKey struct {
+	name string
+}
+
+// AuthInfoContextKey is the context key under which the AuthInfo of an
+// authorized request is stored.
+var AuthInfoContextKey = &contextKey{name: "auth-info"}
+
+// AuthInfo describes how a request forwarded to the next handler was
+// authorized. It never contains the key itself.
+type AuthInfo struct {
+	// KeyName is the name of the matched key entry, empty for unnamed keys.
+	KeyName string
+	// Source is where the key was found: "header", "bearer", "query" or
+	// "cookie".
+	Source string
+	// Time is when the request was authorized.
+	Time time.Time
+}
+
+// FromContext returns the AuthInfo attached to the request carrying ctx. It
+// is only present on requests authorized with a valid key; bypassed,
+// anonymous and rejected requests have none.
+func FromContext(ctx context.Context) (AuthInfo, bool) {
+	info, ok := ctx.Value(AuthInfoContextKey).(AuthInfo)
+	return info, ok
+}
+
+func (ka *SwissKnife) withAuthInfo(req *http.Request, d decision) *http.Request {
+	info := AuthInfo{
+		KeyName: d.consumerName(),
+		Source:  d.matched.source,
+		Time:    ka.now(),
+	}
+	return req.WithContext(context.WithValue(req.Context(), AuthInfoContextKey, info))
+}
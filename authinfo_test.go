@@ -0,0 +1,156 @@
+package swissknife
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type callerKey struct{}
+
+func TestAuthInfo(t *testing.T) {
+	tests := []struct {
+		name     string
+		entries  []KeyEntry
+		setup    func(req *http.Request)
+		wantName string
+		wantSrc  string
+	}{
+		{name: "header", entries: []KeyEntry{{Key: "secret-key-1"}}, setup: func(req *http.Request) { req.Header.Set("X-API-KEY", "secret-key-1") }, wantSrc: sourceHeader},
+		{name: "named key", entries: []KeyEntry{{Name: "billing", Key: "secret-key-1"}}, setup: func(req *http.Request) { req.Header.Set("X-API-KEY", "secret-key-1") }, wantName: "billing", wantSrc: sourceHeader},
+		{name: "bearer", entries: []KeyEntry{{Key: "secret-key-1"}}, setup: func(req *http.Request) { req.Header.Set("Authorization", "Bearer secret-key-1") }, wantSrc: sourceBearer},
+		{name: "query", entries: []KeyEntry{{Key: "secret-key-1"}}, setup: func(req *http.Request) { req.URL.RawQuery = "api_key=secret-key-1" }, wantSrc: sourceQuery},
+		{name: "cookie", entries: []KeyEntry{{Key: "secret-key-1"}}, setup: func(req *http.Request) { req.AddCookie(&http.Cookie{Name: "api_key", Value: "secret-key-1"}) }, wantSrc: sourceCookie},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := CreateConfig()
+			config.KeyEntries = test.entries
+			config.BearerHeader = true
+			config.QueryParam = true
+			config.Cookie = true
+			config.CookieName = "api_key"
+
+			var info AuthInfo
+			var ok bool
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				info, ok = FromContext(req.Context())
+			})
+			ka := newTestHandler(t, config, next)
+			ka.contextInfo = true
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			test.setup(req)
+			start := time.Now()
+			ka.ServeHTTP(httptest.NewRecorder(), req)
+
+			if !ok {
+				t.Fatal("FromContext() ok = false, want true")
+			}
+			if info.KeyName != test.wantName || info.Source != test.wantSrc {
+				t.Errorf("FromContext() = %+v, want key name %q and source %q", info, test.wantName, test.wantSrc)
+			}
+			if info.Time.Before(start) || info.Time.After(time.Now()) {
+				t.Errorf("FromContext() time = %s, want the time of the request", info.Time)
+			}
+			if strings.Contains(fmt.Sprintf("%+v", info), "secret-key-1") {
+				t.Errorf("FromContext() = %+v, must not contain the key", info)
+			}
+		})
+	}
+}
+
+// TestAuthInfoAbsentOnFailure checks that requests let through without a
+// valid key reach the next handler with the context of the caller, without
+// AuthInfo.
+func TestAuthInfoAbsentOnFailure(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*Config)
+		key    string
+		target string
+	}{
+		{name: "report-only, wrong key", modify: func(c *Config) { c.ReportOnly = true }, key: "wrong-key", target: "/"},
+		{name: "optional, no key", modify: func(c *Config) { c.Optional = true }, target: "/"},
+		{name: "excluded path", modify: func(c *Config) { c.ExcludedPaths = []string{"/health"} }, key: "wrong-key", target: "/health"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := CreateConfig()
+			config.Keys = []string{"secret-key-1"}
+			test.modify(config)
+
+			called := false
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				called = true
+				if info, ok := FromContext(req.Context()); ok {
+					t.Errorf("FromContext() = %+v, want none", info)
+				}
+				if got := req.Context().Value(callerKey{}); got != "value" {
+					t.Errorf("caller context value = %v, want %q", got, "value")
+				}
+			})
+			ka := newTestHandler(t, config, next)
+			ka.contextInfo = true
+			ka.SetLogger(&logRecorder{})
+
+			ctx := context.WithValue(context.Background(), callerKey{}, "value")
+			req := httptest.NewRequest(http.MethodGet, test.target, nil).WithContext(ctx)
+			if test.key != "" {
+				req.Header.Set("X-API-KEY", test.key)
+			}
+			ka.ServeHTTP(httptest.NewRecorder(), req)
+			if !called {
+				t.Error("next handler not called")
+			}
+		})
+	}
+}
+
+func TestAuthInfoRejectedRequest(t *testing.T) {
+	config := CreateConfig()
+	config.Keys = []string{"secret-key-1"}
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		t.Error("next handler called for a rejected request")
+	})
+	ka := newTestHandler(t, config, next)
+	ka.contextInfo = true
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-API-KEY", "wrong-key")
+	ctx := req.Context()
+	ka.ServeHTTP(httptest.NewRecorder(), req)
+	if req.Context() != ctx {
+		t.Error("context of the rejected request was replaced")
+	}
+	if _, ok := FromContext(req.Context()); ok {
+		t.Error("FromContext() ok = true for a rejected request")
+	}
+}
+
+func TestContextInfoOff(t *testing.T) {
+	config := CreateConfig()
+	config.Keys = []string{"secret-key-1"}
+
+	var ctx context.Context
+	req := newKeyRequest("secret-key-1")
+	next := http.HandlerFunc(func(rw http.ResponseWriter, forwarded *http.Request) {
+		ctx = forwarded.Context()
+	})
+	ka := newTestHandler(t, config, next)
+	ka.ServeHTTP(httptest.NewRecorder(), req)
+
+	if ctx != req.Context() {
+		t.Error("context of the forwarded request was replaced")
+	}
+	if info, ok := FromContext(ctx); ok {
+		t.Errorf("FromContext() = %+v, want none", info)
+	}
+	if addr, ok := ClientIP(ctx); ok {
+		t.Errorf("ClientIP() = %s, want none", addr)
+	}
+}
@@ -0,0 +1,205 @@
+package swissknife
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// banTracker counts failed attempts per client IP and bans clients reaching
+// maxFailures within window. At most maxClients are tracked at once.
+type banTracker struct {
+	mu          sync.Mutex
+	maxFailures int
+	window      time.Duration
+	banDuration time.Duration
+	maxClients  int
+	clients     map[netip.Addr]*clientFailures
+}
+
+type clientFailures struct {
+	failures    []time.Time
+	bannedUntil time.Time
+}
+
+func newBanTrackerFromConfig(config *Config) (*banTracker, error) {
+	if config.MaxFailures <= 0 {
+		return nil, nil
+	}
+
+	banDuration, err := time.ParseDuration(config.BanDuration)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ban duration: %w", err)
+	}
+	window, err := time.ParseDuration(config.FailureWindow)
+	if err != nil {
+		return nil, fmt.Errorf("invalid failure window: %w", err)
+	}
+	if banDuration <= 0 || window <= 0 {
+		return nil, errors.New("ban duration and failure window must be positive")
+	}
+	if config.MaxTrackedClients <= 0 {
+		return nil, errors.New("max tracked clients must be positive")
+	}
+
+	return &banTracker{
+		maxFailures: config.MaxFailures,
+		window:      window,
+		banDuration: banDuration,
+		maxClients:  config.MaxTrackedClients,
+		clients:     make(map[netip.Addr]*clientFailures),
+	}, nil
+}
+
+// banned returns when the ban of addr ends, if it is banned at now. ended is
+// true when a ban of addr ran out since it was last checked.
+func (bt *banTracker) banned(addr netip.Addr, now time.Time) (until time.Time, banned, ended bool) {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+
+	client, ok := bt.clients[addr]
+	if !ok || client.bannedUntil.IsZero() {
+		return time.Time{}, false, false
+	}
+	if now.Before(client.bannedUntil) {
+		return client.bannedUntil, true, false
+	}
+
+	delete(bt.clients, addr)
+	return time.Time{}, false, true
+}
+
+// fail records a failed attempt from addr and reports whether it started a
+// ban.
+func (bt *banTracker) fail(addr netip.Addr, now time.Time) bool {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+
+	client, ok := bt.clients[addr]
+	if !ok {
+		if len(bt.clients) >= bt.maxClients && !bt.evictOne(now) {
+			return false
+		}
+		client = &clientFailures{}
+		bt.clients[addr] = client
+	}
+
+	recent := client.failures[:0]
+	for _, failure := range client.failures {
+		if now.Sub(failure) < bt.window {
+			recent = append(recent, failure)
+		}
+	}
+	client.failures = append(recent, now)
+
+	if len(client.failures) < bt.maxFailures {
+		return false
+	}
+	client.failures = nil
+	client.bannedUntil = now.Add(bt.banDuration)
+	return true
+}
+
+func (bt *banTracker) reset(addr netip.Addr) {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+
+	if client, ok := bt.clients[addr]; ok && client.bannedUntil.IsZero() {
+		delete(bt.clients, addr)
+	}
+}
+
+// evictOne makes room for a new client by dropping a client that is not
+// banned, preferring one whose failures are all outside the window.
+func (bt *banTracker) evictOne(now time.Time) bool {
+	var candidate netip.Addr
+	found := false
+	for addr, client := range bt.clients {
+		if !client.bannedUntil.IsZero() {
+			continue
+		}
+		if last := client.failures[len(client.failures)-1]; now.Sub(last) >= bt.window {
+			delete(bt.clients, addr)
+			return true
+		}
+		if !found {
+			candidate, found = addr, true
+		}
+	}
+	if found {
+		delete(bt.clients, candidate)
+	}
+	return found
+}
+
+// sweep drops clients with no recent failures and returns the clients whose
+// ban ended.
+func (bt *banTracker) sweep(now time.Time) []netip.Addr {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+
+	var ended []netip.Addr
+	for addr, client := range bt.clients {
+		if !client.bannedUntil.IsZero() {
+			if !now.Before(client.bannedUntil) {
+				delete(bt.clients, addr)
+				ended = append(ended, addr)
+			}
+			continue
+		}
+		if last := client.failures[len(client.failures)-1]; now.Sub(last) >= bt.window {
+			delete(bt.clients, addr)
+		}
+	}
+	return ended
+}
+
+func (ka *SwissKnife) sweepBans(ctx context.Context) {
+	ticker := time.NewTicker(ka.bans.window)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, addr := range ka.bans.sweep(ka.now()) {
+				ka.logBanEnded(addr)
+			}
+		}
+	}
+}
+
+// recordFailure counts a failed attempt from the client of req, if known.
+func (ka *SwissKnife) recordFailure(req *http.Request) {
+	if ka.bans == nil {
+		return
+	}
+	addr, ok := ka.requestIP(req)
+	if !ok {
+		return
+	}
+	if ka.bans.fail(addr, ka.now()) {
+		ka.logger.info(nil, outcomeBanned, "Ban started",
+			logField{name: "clientIP", value: addr.String()},
+			logField{name: "duration", value: ka.bans.banDuration.String()})
+	}
+}
+
+// recordSuccess resets the failed attempts of the client of req, if known.
+func (ka *SwissKnife) recordSuccess(req *http.Request) {
+	if ka.bans == nil {
+		return
+	}
+	if addr, ok := ka.requestIP(req); ok {
+		ka.bans.reset(addr)
+	}
+}
+
+func (ka *SwissKnife) logBanEnded(addr netip.Addr) {
+	ka.logger.info(nil, outcomeBanned, "Ban ended", logField{name: "clientIP", value: addr.String()})
+}
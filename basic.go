@@ -0,0 +1,71 @@
+package swissknife
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strings"
+)
+
+const sourceBasic = "basic"
+
+// basicExtractor finds keys in the password of the values of an
+// authorization header in the Basic scheme. The user name is kept to
+// identify clients whose key has no name.
+type basicExtractor struct {
+	name string
+}
+
+func (e *basicExtractor) Extract(req *http.Request) (string, bool) {
+	for _, value := range req.Header.Values(e.name) {
+		if _, password, ok := basicCredentials(value); ok {
+			return password, true
+		}
+	}
+	return "", false
+}
+
+func (e *basicExtractor) Strip(req *http.Request) {
+	for _, value := range req.Header.Values(e.name) {
+		if _, _, ok := basicCredentials(value); ok {
+			removeHeaderValue(req, e.name, value)
+		}
+	}
+}
+
+func (e *basicExtractor) appendAll(credentials []credential, req *http.Request) ([]credential, bool) {
+	found := false
+	for _, value := range req.Header.Values(e.name) {
+		if user, password, ok := basicCredentials(value); ok {
+			credentials = append(credentials, credential{extractor: e, source: sourceBasic, value: password, raw: value, user: user})
+			found = true
+		}
+	}
+	return credentials, found
+}
+
+func (e *basicExtractor) stripValue(req *http.Request, raw string) {
+	removeHeaderValue(req, e.name, raw)
+}
+
+// basicCredentials parses an authorization header in the Basic scheme. ok is
+// true when the scheme matched: malformed credentials give an empty
+// password, so they count as an invalid key rather than no key.
+func basicCredentials(header string) (user, password string, ok bool) {
+	scheme, encoded := strings.TrimSpace(header), ""
+	if i := strings.IndexAny(scheme, " \t"); i >= 0 {
+		scheme, encoded = scheme[:i], strings.TrimSpace(scheme[i:])
+	}
+	if !strings.EqualFold(scheme, "Basic") {
+		return "", "", false
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", "", true
+	}
+	user, password, found := strings.Cut(string(decoded), ":")
+	if !found {
+		return "", "", true
+	}
+	return user, password, true
+}
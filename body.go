@@ -0,0 +1,169 @@
+package swissknife
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const sourceBody = "body"
+
+//nolint:all
+type BodyAuth struct {
+	Enabled       bool     `json:"enabled,omitempty"`
+	ContentType   string   `json:"contentType,omitempty"`
+	JSONPath      string   `json:"jsonPath,omitempty"`
+	MaxBodyBytes  int64    `json:"maxBodyBytes,omitempty"`
+	Methods       []string `json:"methods,omitempty"`
+	StripFromBody bool     `json:"stripFromBody,omitempty"`
+}
+
+// bodyExtractor finds keys in a field of a JSON body, given by a dotted
+// path. Bodies of other requests, larger than maxBodyBytes or that are not
+// JSON objects present no key. The body is always put back for the
+// upstream.
+type bodyExtractor struct {
+	contentType  string
+	path         []string
+	maxBodyBytes int64
+	methods      map[string]struct{}
+	strip        bool
+}
+
+func newBodyExtractor(config BodyAuth) (*bodyExtractor, error) {
+	if config.JSONPath == "" {
+		return nil, errors.New("body auth JSON path must be set")
+	}
+	path := strings.Split(config.JSONPath, ".")
+	for _, field := range path {
+		if field == "" {
+			return nil, fmt.Errorf("invalid body auth JSON path %q", config.JSONPath)
+		}
+	}
+	contentType, _, err := mime.ParseMediaType(config.ContentType)
+	if err != nil {
+		return nil, fmt.Errorf("invalid body auth content type: %w", err)
+	}
+	if config.MaxBodyBytes <= 0 {
+		return nil, errors.New("body auth max body bytes must be positive")
+	}
+	if len(config.Methods) == 0 {
+		return nil, errors.New("body auth methods must not be empty")
+	}
+	methods, err := methodSet(config.Methods)
+	if err != nil {
+		return nil, fmt.Errorf("invalid body auth methods: %w", err)
+	}
+
+	return &bodyExtractor{
+		contentType:  contentType,
+		path:         path,
+		maxBodyBytes: config.MaxBodyBytes,
+		methods:      methods,
+		strip:        config.StripFromBody,
+	}, nil
+}
+
+func (e *bodyExtractor) Extract(req *http.Request) (string, bool) {
+	fields, ok := e.readObject(req)
+	if !ok {
+		return "", false
+	}
+
+	var value interface{} = fields
+	for _, field := range e.path {
+		object, isObject := value.(map[string]interface{})
+		if !isObject {
+			return "", false
+		}
+		if value, ok = object[field]; !ok {
+			return "", false
+		}
+	}
+	// A field that is not a string is an invalid key.
+	key, _ := value.(string)
+	return key, true
+}
+
+func (e *bodyExtractor) Strip(req *http.Request) {
+	if !e.strip {
+		return
+	}
+	fields, ok := e.readObject(req)
+	if !ok {
+		return
+	}
+
+	object := fields
+	for _, field := range e.path[:len(e.path)-1] {
+		if object, ok = object[field].(map[string]interface{}); !ok {
+			return
+		}
+	}
+	delete(object, e.path[len(e.path)-1])
+
+	body, err := json.Marshal(fields)
+	if err != nil {
+		return
+	}
+	setBody(req, body)
+	req.ContentLength = int64(len(body))
+	req.Header.Set("Content-Length", strconv.Itoa(len(body)))
+}
+
+// readObject decodes the body of req as a JSON object, if req is one of the
+// requests bodies are read from.
+func (e *bodyExtractor) readObject(req *http.Request) (map[string]interface{}, bool) {
+	if _, ok := e.methods[req.Method]; !ok || req.Body == nil || req.Body == http.NoBody {
+		return nil, false
+	}
+	if contentType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type")); err != nil || !strings.EqualFold(contentType, e.contentType) {
+		return nil, false
+	}
+
+	body, ok := readBodyUpTo(req, e.maxBodyBytes)
+	if !ok {
+		return nil, false
+	}
+
+	var fields map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&fields); err != nil {
+		return nil, false
+	}
+	return fields, true
+}
+
+// readBodyUpTo reads the body of req if it is at most maxBytes long, and puts
+// it back for the upstream. ok is false if the body is longer or could not be
+// read, in which case the upstream still gets it whole: what was read
+// followed by the rest.
+func readBodyUpTo(req *http.Request, maxBytes int64) ([]byte, bool) {
+	body, err := io.ReadAll(io.LimitReader(req.Body, maxBytes+1))
+	if err != nil || int64(len(body)) > maxBytes {
+		rest := req.Body
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), rest), rest}
+		return nil, false
+	}
+	_ = req.Body.Close()
+	setBody(req, body)
+	return body, true
+}
+
+// setBody replaces the body of req with body.
+func setBody(req *http.Request, body []byte) {
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+}
@@ -0,0 +1,120 @@
+package swissknife
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errCircuitOpen is returned instead of calling the validation endpoint while
+// the circuit is open.
+var errCircuitOpen = errors.New("validation endpoint circuit open")
+
+// circuitBreaker stops calling the validation endpoint after threshold
+// consecutive errors. Once cooldown has passed, a single probe is let through:
+// its success closes the circuit again, its failure opens it for another
+// cooldown.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+	logger    *logger
+
+	failures int
+	openedAt time.Time
+	probing  bool
+
+	opens         int64
+	shortCircuits int64
+}
+
+func newCircuitBreakerFromConfig(config *Config, logger *logger) (*circuitBreaker, error) {
+	if config.ValidationURL == "" || config.CircuitBreakerThreshold <= 0 {
+		return nil, nil
+	}
+
+	cooldown, err := time.ParseDuration(config.CircuitBreakerCooldown)
+	if err != nil {
+		return nil, fmt.Errorf("invalid circuit breaker cooldown: %w", err)
+	}
+	if cooldown <= 0 {
+		return nil, errors.New("circuit breaker cooldown must be positive")
+	}
+
+	return &circuitBreaker{
+		threshold: config.CircuitBreakerThreshold,
+		cooldown:  cooldown,
+		now:       time.Now,
+		logger:    logger,
+	}, nil
+}
+
+// allow returns errCircuitOpen if the endpoint must not be called.
+func (cb *circuitBreaker) allow() error {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.openedAt.IsZero() {
+		return nil
+	}
+	if cb.probing || cb.now().Sub(cb.openedAt) < cb.cooldown {
+		cb.shortCircuits++
+		return errCircuitOpen
+	}
+
+	cb.probing = true
+	cb.logger.debug(nil, "", "Validation endpoint circuit half-open, probing")
+	return nil
+}
+
+// record accounts for the result of a call allowed by allow.
+func (cb *circuitBreaker) record(err error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if err == nil {
+		if !cb.openedAt.IsZero() {
+			cb.logger.debug(nil, "", "Validation endpoint circuit closed")
+		}
+		cb.failures = 0
+		cb.openedAt = time.Time{}
+		cb.probing = false
+		return
+	}
+
+	cb.failures++
+	if cb.probing || (cb.openedAt.IsZero() && cb.failures >= cb.threshold) {
+		cb.openedAt = cb.now()
+		cb.probing = false
+		cb.opens++
+		cb.logger.debug(nil, "", fmt.Sprintf("Validation endpoint circuit opened after %d consecutive errors", cb.failures))
+	}
+}
+
+// release gives up a call allowed by allow without accounting for it, so
+// that another probe can be let through.
+func (cb *circuitBreaker) release() {
+	cb.mu.Lock()
+	cb.probing = false
+	cb.mu.Unlock()
+}
+
+func (cb *circuitBreaker) writeMetrics(b *strings.Builder, prefix string) {
+	cb.mu.Lock()
+	open := 0
+	if !cb.openedAt.IsZero() {
+		open = 1
+	}
+	opens, shortCircuits := cb.opens, cb.shortCircuits
+	cb.mu.Unlock()
+
+	fmt.Fprintf(b, "# HELP %s_circuit_open Whether the validation endpoint circuit is open.\n# TYPE %s_circuit_open gauge\n", prefix, prefix)
+	fmt.Fprintf(b, "%s_circuit_open %d\n", prefix, open)
+	fmt.Fprintf(b, "# HELP %s_circuit_opens_total Times the validation endpoint circuit opened.\n# TYPE %s_circuit_opens_total counter\n", prefix, prefix)
+	fmt.Fprintf(b, "%s_circuit_opens_total %d\n", prefix, opens)
+	fmt.Fprintf(b, "# HELP %s_circuit_short_circuits_total Validations failed without calling the endpoint.\n# TYPE %s_circuit_short_circuits_total counter\n", prefix, prefix)
+	fmt.Fprintf(b, "%s_circuit_short_circuits_total %d\n", prefix, shortCircuits)
+}
@@ -0,0 +1,99 @@
+package swissknife
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"sync"
+	"time"
+)
+
+// validationCache is an LRU cache of remote validation results. Entries are
+// keyed on the digest of the presented key so raw keys are not kept in memory.
+type validationCache struct {
+	mu          sync.Mutex
+	ttl         time.Duration
+	negativeTTL time.Duration
+	maxEntries  int
+	entries     map[[sha256.Size]byte]*list.Element
+	order       *list.List
+	hits        int64
+	misses      int64
+}
+
+type cacheEntry struct {
+	digest  [sha256.Size]byte
+	valid   bool
+	expires time.Time
+}
+
+func newValidationCache(ttl, negativeTTL time.Duration, maxEntries int) *validationCache {
+	return &validationCache{
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		maxEntries:  maxEntries,
+		entries:     make(map[[sha256.Size]byte]*list.Element),
+		order:       list.New(),
+	}
+}
+
+func (c *validationCache) get(key string) (valid, ok bool) {
+	digest := sha256.Sum256([]byte(key))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, exists := c.entries[digest]
+	if !exists {
+		c.misses++
+		return false, false
+	}
+
+	entry := element.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		c.order.Remove(element)
+		delete(c.entries, digest)
+		c.misses++
+		return false, false
+	}
+
+	c.order.MoveToFront(element)
+	c.hits++
+	return entry.valid, true
+}
+
+func (c *validationCache) add(key string, valid bool) {
+	ttl := c.ttl
+	if !valid {
+		ttl = c.negativeTTL
+	}
+	if ttl <= 0 {
+		return
+	}
+
+	digest := sha256.Sum256([]byte(key))
+	expires := time.Now().Add(ttl)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, exists := c.entries[digest]; exists {
+		entry := element.Value.(*cacheEntry)
+		entry.valid = valid
+		entry.expires = expires
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.entries[digest] = c.order.PushFront(&cacheEntry{digest: digest, valid: valid, expires: expires})
+	for c.order.Len() > c.maxEntries {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).digest)
+	}
+}
+
+func (c *validationCache) stats() (hits, misses int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits, c.misses
+}
@@ -0,0 +1,190 @@
+package swissknife
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+)
+
+const sourceClientCert = "client_cert"
+
+//nolint:all
+type ClientCertAuth struct {
+	Fingerprints   []string `json:"fingerprints,omitempty"`
+	SANs           []string `json:"sans,omitempty"`
+	Header         string   `json:"header,omitempty"`
+	HeaderVerified bool     `json:"headerVerified,omitempty"`
+}
+
+// clientCertVerifier accepts the client certificates with one of the
+// fingerprints or subject alternative names, read from the TLS connection or,
+// if header is set, from the header the certificate was forwarded in.
+// Subject alternative names are only matched on verified certificates:
+// anyone can issue themselves a certificate with any name.
+type clientCertVerifier struct {
+	fingerprints   map[[sha256.Size]byte]struct{}
+	sans           map[string]struct{}
+	header         string
+	headerVerified bool
+}
+
+// clientCertExtractor stands for the client certificate in the credential of
+// the requests it authorized. There is nothing to strip.
+type clientCertExtractor struct{}
+
+func (e *clientCertExtractor) Extract(*http.Request) (string, bool) { return "", false }
+
+func (e *clientCertExtractor) Strip(*http.Request) {}
+
+func hasClientCertAuth(config *Config) bool {
+	return len(config.ClientCertAuth.Fingerprints) > 0 || len(config.ClientCertAuth.SANs) > 0
+}
+
+func newClientCertVerifier(config ClientCertAuth) (*clientCertVerifier, error) {
+	if len(config.Fingerprints) == 0 && len(config.SANs) == 0 {
+		return nil, nil
+	}
+
+	cv := &clientCertVerifier{
+		fingerprints: make(map[[sha256.Size]byte]struct{}, len(config.Fingerprints)),
+		sans:         make(map[string]struct{}, len(config.SANs)),
+	}
+	for i, fingerprint := range config.Fingerprints {
+		decoded, err := hex.DecodeString(strings.ReplaceAll(strings.TrimSpace(fingerprint), ":", ""))
+		if err != nil || len(decoded) != sha256.Size {
+			return nil, fmt.Errorf("invalid client certificate fingerprint at index %d: must be a hex encoded SHA-256 digest", i)
+		}
+		var digest [sha256.Size]byte
+		copy(digest[:], decoded)
+		cv.fingerprints[digest] = struct{}{}
+	}
+	for i, san := range config.SANs {
+		if strings.TrimSpace(san) == "" {
+			return nil, fmt.Errorf("invalid client certificate SAN at index %d: must not be empty", i)
+		}
+		cv.sans[strings.ToLower(strings.TrimSpace(san))] = struct{}{}
+	}
+	if config.Header != "" {
+		if !validHeaderName(config.Header) {
+			return nil, fmt.Errorf("invalid client certificate header %q", config.Header)
+		}
+		cv.header = http.CanonicalHeaderKey(config.Header)
+		cv.headerVerified = config.HeaderVerified
+	}
+	return cv, nil
+}
+
+// certificate returns the client certificate of req, if any, and whether it
+// was verified against a CA: by the TLS handshake, or by the proxy that
+// forwarded it when the header is configured as verified. err is set when a
+// forwarded certificate could not be parsed. The header is ignored when the
+// peer is not one of trustedProxies: certificates are public, so a client
+// setting it could pass for any of them.
+func (cv *clientCertVerifier) certificate(req *http.Request, trustedProxies []netip.Prefix) (cert *x509.Certificate, verified bool, err error) {
+	if req.TLS != nil && len(req.TLS.PeerCertificates) > 0 {
+		return req.TLS.PeerCertificates[0], len(req.TLS.VerifiedChains) > 0, nil
+	}
+	if cv.header == "" {
+		return nil, false, nil
+	}
+	peer, err := parseAddr(req.RemoteAddr)
+	if err != nil || !prefixesContain(trustedProxies, peer) {
+		return nil, false, nil
+	}
+	value := req.Header.Get(cv.header)
+	if value == "" {
+		return nil, false, nil
+	}
+	cert, err = parseForwardedCert(value)
+	return cert, cv.headerVerified, err
+}
+
+// parseForwardedCert parses the first certificate of a header set by
+// Traefik's passTLSClientCert middleware: URL-encoded certificates separated
+// by commas, in PEM or as the base64 of their DER encoding.
+func parseForwardedCert(value string) (*x509.Certificate, error) {
+	unescaped, err := url.QueryUnescape(value)
+	if err != nil {
+		return nil, errors.New("malformed client certificate header")
+	}
+	first, _, _ := strings.Cut(unescaped, ",")
+
+	var der []byte
+	if block, _ := pem.Decode([]byte(first)); block != nil {
+		der = block.Bytes
+	} else {
+		der, err = base64.StdEncoding.DecodeString(strings.Join(strings.Fields(first), ""))
+		if err != nil {
+			return nil, errors.New("malformed client certificate header")
+		}
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("malformed client certificate: %w", err)
+	}
+	return cert, nil
+}
+
+// accepts reports whether cert has an accepted fingerprint or, if it was
+// verified, an accepted SAN.
+func (cv *clientCertVerifier) accepts(cert *x509.Certificate, verified bool) bool {
+	if _, ok := cv.fingerprints[sha256.Sum256(cert.Raw)]; ok {
+		return true
+	}
+	if !verified || len(cv.sans) == 0 {
+		return false
+	}
+
+	sans := append(append([]string{}, cert.DNSNames...), cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		sans = append(sans, uri.String())
+	}
+	for _, san := range sans {
+		if _, ok := cv.sans[strings.ToLower(san)]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// decideClientCert authenticates a request by its client certificate. ok is
+// false when there is no accepted certificate, and the request is then
+// authenticated by its keys.
+func (ka *SwissKnife) decideClientCert(req *http.Request) (decision, bool) {
+	cert, verified, err := ka.clientCerts.certificate(req, ka.trustedProxies)
+	if err != nil {
+		if ka.logger.enabledFor(levelDebug) {
+			ka.logger.debug(req, "", fmt.Sprintf("Ignoring client certificate (%s)", err.Error()))
+		}
+		return decision{}, false
+	}
+	if cert == nil || !ka.clientCerts.accepts(cert, verified) {
+		return decision{}, false
+	}
+
+	matched := &credential{extractor: &clientCertExtractor{}, source: sourceClientCert}
+	d := decision{matched: matched, entry: &keyEntry{name: cert.Subject.CommonName}}
+	if reason := ka.keyPolicyViolation(req, nil); reason != "" {
+		if ka.logger.enabledFor(levelInfo) {
+			ka.logger.info(req, outcomeForbidden, fmt.Sprintf("Forbidden request (%s)", reason))
+		}
+		d.outcome = outcomeForbidden
+		return d, true
+	}
+
+	ka.logger.info(req, outcomeAuthorized, "Authorized request (client certificate)")
+	ka.recordSuccess(req)
+	d.outcome = outcomeAuthorized
+	return d, true
+}
@@ -0,0 +1,197 @@
+package swissknife
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestCert(t *testing.T, commonName string) *x509.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{commonName + ".example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func certFingerprint(cert *x509.Certificate) string {
+	digest := sha256.Sum256(cert.Raw)
+	return strings.ToUpper(hex.EncodeToString(digest[:]))
+}
+
+func forwardedCert(cert *x509.Certificate) string {
+	return url.QueryEscape(string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})))
+}
+
+func TestClientCertOnlyConfig(t *testing.T) {
+	cert := newTestCert(t, "billing")
+
+	config := CreateConfig()
+	config.AuthenticationHeader = false
+	config.ClientCertAuth.Fingerprints = []string{certFingerprint(cert)}
+
+	var consumer string
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		info, _ := FromContext(req.Context())
+		consumer = info.KeyName
+	})
+	handler, err := NewWithOptions(context.Background(), next, config, "client-cert", Options{ContextInfo: true})
+	if err != nil {
+		t.Fatalf("NewWithOptions() error = %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status code = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if consumer != "billing" {
+		t.Errorf("consumer = %q, want %q", consumer, "billing")
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("status code without certificate = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+}
+
+func TestClientCertHeader(t *testing.T) {
+	cert := newTestCert(t, "billing")
+	other := newTestCert(t, "other")
+
+	config := CreateConfig()
+	config.Keys = []string{"secret-key-1"}
+	config.ClientCertAuth.Fingerprints = []string{strings.ToLower(certFingerprint(cert))}
+	config.ClientCertAuth.Header = "X-Forwarded-Tls-Client-Cert"
+	config.TrustedProxies = []string{"10.0.0.0/8"}
+
+	handler, err := New(context.Background(), http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}), config, "client-cert")
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		header     string
+		want       int
+	}{
+		{name: "trusted proxy", remoteAddr: "10.1.2.3:1234", header: forwardedCert(cert), want: http.StatusOK},
+		{name: "untrusted peer", remoteAddr: "192.0.2.1:1234", header: forwardedCert(cert), want: http.StatusForbidden},
+		{name: "unknown certificate", remoteAddr: "10.1.2.3:1234", header: forwardedCert(other), want: http.StatusForbidden},
+		{name: "malformed header", remoteAddr: "10.1.2.3:1234", header: "not-a-certificate", want: http.StatusForbidden},
+		{name: "no header", remoteAddr: "10.1.2.3:1234", want: http.StatusForbidden},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = test.remoteAddr
+			if test.header != "" {
+				req.Header.Set("X-Forwarded-Tls-Client-Cert", test.header)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != test.want {
+				t.Errorf("status code = %d, want %d", rec.Code, test.want)
+			}
+		})
+	}
+}
+
+func TestClientCertHeaderRequiresTrustedProxies(t *testing.T) {
+	config := CreateConfig()
+	config.Keys = []string{"secret-key-1"}
+	config.ClientCertAuth.SANs = []string{"billing.example.com"}
+	config.ClientCertAuth.Header = "X-Forwarded-Tls-Client-Cert"
+
+	_, err := New(context.Background(), http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}), config, "client-cert")
+	if err == nil || !strings.Contains(err.Error(), "client certificate header requires trusted proxies") {
+		t.Errorf("New() error = %v, want trusted proxies error", err)
+	}
+}
+
+func TestClientCertSANsRequireVerification(t *testing.T) {
+	cert := newTestCert(t, "billing")
+
+	tests := []struct {
+		name           string
+		tls            *tls.ConnectionState
+		header         string
+		headerVerified bool
+		want           int
+	}{
+		{name: "unverified self-signed", tls: &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}, want: http.StatusForbidden},
+		{name: "verified", tls: &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}, VerifiedChains: [][]*x509.Certificate{{cert}}}, want: http.StatusOK},
+		{name: "forwarded", header: forwardedCert(cert), want: http.StatusForbidden},
+		{name: "forwarded by a verifying proxy", header: forwardedCert(cert), headerVerified: true, want: http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := CreateConfig()
+			config.AuthenticationHeader = false
+			config.ClientCertAuth.SANs = []string{"Billing.example.com"}
+			config.ClientCertAuth.Header = "X-Forwarded-Tls-Client-Cert"
+			config.ClientCertAuth.HeaderVerified = test.headerVerified
+			config.TrustedProxies = []string{"10.0.0.0/8"}
+			config.ClientIPHeader = "X-Forwarded-For"
+			ka := newTestHandler(t, config, nil)
+			ka.SetLogger(&logRecorder{})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = "10.1.2.3:1234"
+			req.TLS = test.tls
+			if test.header != "" {
+				req.Header.Set("X-Forwarded-Tls-Client-Cert", test.header)
+			}
+			if code := serveRecorded(ka, req).Code; code != test.want {
+				t.Errorf("status code = %d, want %d", code, test.want)
+			}
+		})
+	}
+}
+
+func TestClientCertHeaderVerifiedRequiresHeader(t *testing.T) {
+	config := CreateConfig()
+	config.Keys = []string{"secret-key-1"}
+	config.ClientCertAuth.SANs = []string{"billing.example.com"}
+	config.ClientCertAuth.HeaderVerified = true
+
+	_, err := New(context.Background(), noopHandler, config, "client-cert")
+	if err == nil || !strings.Contains(err.Error(), "verified client certificate header requires a header") {
+		t.Errorf("New() error = %v, want header error", err)
+	}
+}
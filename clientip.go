@@ -0,0 +1,135 @@
+package swissknife
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+func parsePrefixes(cidrs []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for i, cidr := range cidrs {
+		prefix, err := netip.ParsePrefix(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR at index %d: %w", i, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+func prefixesContain(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+type clientIPKey struct{}
+
+// ClientIP returns the client IP computed by the plugin for the request
+// carrying ctx, if it could be determined.
+func ClientIP(ctx context.Context) (netip.Addr, bool) {
+	addr, ok := ctx.Value(clientIPKey{}).(netip.Addr)
+	return addr, ok
+}
+
+func withClientIP(req *http.Request, addr netip.Addr) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), clientIPKey{}, addr))
+}
+
+// requestIP returns the client IP of req, from its context if it was
+// attached there, or computed again otherwise.
+func (ka *SwissKnife) requestIP(req *http.Request) (netip.Addr, bool) {
+	if addr, ok := ClientIP(req.Context()); ok {
+		return addr, true
+	}
+	addr, err := ka.clientIP(req)
+	return addr, err == nil
+}
+
+// clientIP returns the address of the client. With trusted proxies, it is the
+// rightmost address of the client IP header that is not a trusted proxy, as
+// long as the peer itself is trusted. With a forwarded depth of n > 0 it is
+// the nth address from the right of X-Forwarded-For (or X-Real-IP when the
+// depth is 1 and there is no X-Forwarded-For). Otherwise it is the peer
+// address.
+func (ka *SwissKnife) clientIP(req *http.Request) (netip.Addr, error) {
+	if len(ka.trustedProxies) > 0 {
+		return ka.untrustedIP(req)
+	}
+	if ka.forwardedDepth > 0 {
+		return forwardedIP(req, ka.forwardedDepth)
+	}
+	return parseAddr(req.RemoteAddr)
+}
+
+func (ka *SwissKnife) untrustedIP(req *http.Request) (netip.Addr, error) {
+	peer, err := parseAddr(req.RemoteAddr)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	if !prefixesContain(ka.trustedProxies, peer) {
+		return peer, nil
+	}
+
+	forwarded := headerAddrs(req, ka.clientIPHeader)
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		addr, err := parseAddr(forwarded[i])
+		if err != nil {
+			return netip.Addr{}, err
+		}
+		if !prefixesContain(ka.trustedProxies, addr) {
+			return addr, nil
+		}
+	}
+
+	// Every hop is trusted: the leftmost one is the closest to the client.
+	if len(forwarded) > 0 {
+		return parseAddr(forwarded[0])
+	}
+	return peer, nil
+}
+
+// headerAddrs returns the comma-separated addresses of every value of the
+// header name, left to right.
+func headerAddrs(req *http.Request, name string) []string {
+	var addrs []string
+	for _, value := range req.Header.Values(name) {
+		for _, addr := range strings.Split(value, ",") {
+			addrs = append(addrs, strings.TrimSpace(addr))
+		}
+	}
+	return addrs
+}
+
+func forwardedIP(req *http.Request, depth int) (netip.Addr, error) {
+	forwarded := headerAddrs(req, "X-Forwarded-For")
+
+	if len(forwarded) == 0 && depth == 1 {
+		if realIP := req.Header.Get("X-Real-IP"); realIP != "" {
+			return parseAddr(realIP)
+		}
+	}
+	if len(forwarded) < depth {
+		return netip.Addr{}, errors.New("not enough forwarded addresses")
+	}
+	return parseAddr(forwarded[len(forwarded)-depth])
+}
+
+// parseAddr parses an address with or without a port.
+func parseAddr(s string) (netip.Addr, error) {
+	if addrPort, err := netip.ParseAddrPort(s); err == nil {
+		return addrPort.Addr().Unmap(), nil
+	}
+	addr, err := netip.ParseAddr(strings.Trim(s, "[]"))
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("unparseable client address %q", s)
+	}
+	return addr.Unmap(), nil
+}
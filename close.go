@@ -0,0 +1,75 @@
+package swissknife
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+)
+
+// flushTimeout bounds how long the webhook and usage reports still buffered
+// are sent for when the plugin stops.
+const flushTimeout = 2 * time.Second
+
+var _ io.Closer = (*SwissKnife)(nil)
+
+// goBackground runs fn in a goroutine until ctx is done, tracked so that
+// Close can wait for it.
+func (ka *SwissKnife) goBackground(ctx context.Context, fn func(ctx context.Context)) {
+	ka.background.Add(1)
+	go func() {
+		defer ka.background.Done()
+		fn(ctx)
+	}()
+}
+
+// newHTTPClient returns a client with connections of its own, rather than
+// those of http.DefaultTransport, so that Close can close them.
+func newHTTPClient(timeout time.Duration) *http.Client {
+	return &http.Client{Timeout: timeout, Transport: http.DefaultTransport.(*http.Transport).Clone()}
+}
+
+// httpClients returns the clients the plugin opened connections with.
+func (ka *SwissKnife) httpClients() []*http.Client {
+	var clients []*http.Client
+	if ka.jwt != nil && ka.jwt.jwks != nil {
+		clients = append(clients, ka.jwt.jwks.client)
+	}
+	if ka.webhook != nil {
+		clients = append(clients, ka.webhook.client)
+	}
+	if ka.usage != nil {
+		clients = append(clients, ka.usage.client)
+	}
+	for _, store := range ka.stores {
+		if remote, ok := store.(*remoteKeyStore); ok {
+			clients = append(clients, remote.validator.client)
+		}
+	}
+	return clients
+}
+
+// Close stops the background work of the plugin: key reloads, ban sweeps, the
+// failure webhook and usage reports, which first send what they have buffered
+// within a short deadline, and closes the idle connections of the plugin. It
+// is called when the context given to New is done. Requests can still be
+// served after Close, without background work. Close can be called more than
+// once.
+//
+//nolint:all
+func (ka *SwissKnife) Close() error {
+	ka.closeOnce.Do(func() {
+		ka.cancel()
+		ka.background.Wait()
+		for _, client := range ka.httpClients() {
+			client.CloseIdleConnections()
+		}
+
+		ka.logger.mu.Lock()
+		if file, ok := ka.logger.sink.(*fileLogger); ok {
+			file.close()
+		}
+		ka.logger.mu.Unlock()
+	})
+	return nil
+}
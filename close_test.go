@@ -0,0 +1,91 @@
+package swissknife
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// waitForGoroutines waits for the number of goroutines to drop to at most
+// want, returning the last count.
+func waitForGoroutines(want int) int {
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		n := runtime.NumGoroutine()
+		if n <= want || time.Now().After(deadline) {
+			return n
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCloseStopsGoroutines(t *testing.T) {
+	for _, stop := range []string{"close", "context"} {
+		t.Run(stop, func(t *testing.T) {
+			jwks := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				_, _ = rw.Write([]byte(`{"keys":[]}`))
+			}))
+			defer jwks.Close()
+			received := make(chan struct{}, 1)
+			webhook := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				select {
+				case received <- struct{}{}:
+				default:
+				}
+			}))
+			defer webhook.Close()
+
+			keysFile := filepath.Join(t.TempDir(), "keys")
+			if err := os.WriteFile(keysFile, []byte("secret-key-1\n"), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			config := CreateConfig()
+			config.KeysFile = keysFile
+			config.ReloadInterval = "10ms"
+			config.BearerHeader = true
+			config.JWT.JwksURL = jwks.URL
+			config.FailureWebhook.URL = webhook.URL
+			config.FailureWebhook.MinInterval = "1ms"
+			config.FailureWebhook.BatchSize = 1
+
+			before := runtime.NumGoroutine()
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			handler, err := New(ctx, noopHandler, config, "test")
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+
+			// Fetch the key set and send a failure to the webhook, so that
+			// their clients have connections open.
+			token := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","kid":"test"}`)) + ".e30.c2ln"
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			select {
+			case <-received:
+			case <-time.After(2 * time.Second):
+				t.Fatal("webhook not called")
+			}
+			time.Sleep(30 * time.Millisecond)
+
+			if stop == "close" {
+				if err := handler.(*SwissKnife).Close(); err != nil {
+					t.Fatalf("Close() error = %v", err)
+				}
+			} else {
+				cancel()
+			}
+			if after := waitForGoroutines(before); after > before {
+				buf := make([]byte, 1<<16)
+				t.Errorf("goroutines = %d after stopping, want at most %d\n%s", after, before, buf[:runtime.Stack(buf, true)])
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package swissknife
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// conflict checks, in strict mode, that every credential presented with the
+// matched one is valid too when they come from more than one source. It
+// returns the decision rejecting the request if they disagree.
+func (ka *SwissKnife) conflict(req *http.Request, credentials []credential, matched *credential) (decision, bool) {
+	if ka.rfc6750Sources {
+		// RFC 6750 section 2: clients must not use more than one method.
+		var methods []string
+		for _, c := range credentials {
+			if isRFC6750Credential(c) {
+				methods = appendSource(methods, c.source)
+			}
+		}
+		if len(methods) > 1 {
+			if ka.logger.enabledFor(levelInfo) {
+				ka.logger.info(req, outcomeConflict, fmt.Sprintf("Unauthorized request (access token sent in more than one way, in %s)", strings.Join(methods, ", ")))
+			}
+			ka.recordFailure(req)
+			return decision{outcome: outcomeConflict, credentials: credentials}, true
+		}
+	}
+
+	sources := make(map[string]struct{}, len(credentials))
+	for _, c := range credentials {
+		sources[c.source] = struct{}{}
+	}
+	if len(sources) < 2 {
+		return decision{}, false
+	}
+
+	var valid, invalid []string
+	for i := range credentials {
+		c := &credentials[i]
+		ok := c.value == matched.value
+		if !ok {
+			var err error
+			ok, err = ka.credentialValid(req.Context(), c)
+			if err != nil {
+				return ka.unavailable(req, credentials, err), true
+			}
+		}
+		if ok {
+			valid = appendSource(valid, c.source)
+		} else {
+			invalid = appendSource(invalid, c.source)
+		}
+	}
+	if len(invalid) == 0 {
+		return decision{}, false
+	}
+
+	reason := fmt.Sprintf("conflicting credentials, valid in %s, invalid in %s", strings.Join(valid, ", "), strings.Join(invalid, ", "))
+	if ka.logger.enabledFor(levelInfo) {
+		ka.logger.info(req, outcomeConflict, fmt.Sprintf("Unauthorized request (%s)", reason))
+	}
+	ka.recordFailure(req)
+	return decision{outcome: outcomeConflict, credentials: credentials}, true
+}
+
+// credentialValid reports whether c alone would be accepted, as a JWT, a
+// signed token or a key.
+func (ka *SwissKnife) credentialValid(ctx context.Context, c *credential) (bool, error) {
+	if ka.jwt != nil && c.source == sourceBearer && looksLikeJWT(c.value) {
+		_, err := ka.jwt.verify(ctx, c.value, ka.now())
+		return err == nil, nil
+	}
+	if ka.signedTokens != nil && c.source == sourceBearer && looksLikeSignedToken(c.value) {
+		keyID, err := ka.signedTokens.verify(c.value, ka.now())
+		if err != nil {
+			return false, nil
+		}
+		_, ok := ka.static.current().named(keyID)
+		return ok, nil
+	}
+
+	matched, _, err := ka.authorize(ctx, ka.withAllowedPrefix([]credential{*c}))
+	return matched != nil, err
+}
+
+func appendSource(sources []string, source string) []string {
+	for _, s := range sources {
+		if s == source {
+			return sources
+		}
+	}
+	return append(sources, source)
+}
+
+// responseConflict rejects a request carrying credentials that disagree.
+func (ka *SwissKnife) responseConflict(rw http.ResponseWriter, req *http.Request) {
+	ka.delayFailure(req.Context())
+	ka.writeResponse(rw, req, Response{
+		Message:    messageConflict,
+		StatusCode: ka.unauthorizedStatusCode,
+	})
+}
@@ -0,0 +1,73 @@
+package swissknife
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// FailureCORS lets browser code of the allowed origins read the error
+// responses of the plugin, which would otherwise look like network errors.
+//
+//nolint:all
+type FailureCORS struct {
+	AllowedOrigins   []string `json:"allowedOrigins,omitempty"`
+	AllowCredentials bool     `json:"allowCredentials,omitempty"`
+}
+
+type failureCORS struct {
+	anyOrigin   bool
+	origins     []originPattern
+	credentials bool
+}
+
+// newFailureCORS returns nil when no origin is allowed. "*" allows any
+// origin.
+func newFailureCORS(config FailureCORS) (*failureCORS, error) {
+	if len(config.AllowedOrigins) == 0 {
+		return nil, nil
+	}
+
+	cors := &failureCORS{credentials: config.AllowCredentials}
+	var patterns []string
+	for _, origin := range config.AllowedOrigins {
+		if origin == "*" {
+			cors.anyOrigin = true
+			continue
+		}
+		patterns = append(patterns, origin)
+	}
+	origins, err := compileOriginPatterns(patterns)
+	if err != nil {
+		return nil, fmt.Errorf("invalid failure CORS allowed origins: %w", err)
+	}
+	cors.origins = origins
+	return cors, nil
+}
+
+// setFailureCORS echoes the Origin of req in an error response when it is
+// allowed. Only the Origin header counts, as browsers send it with every
+// cross-origin request.
+func (ka *SwissKnife) setFailureCORS(rw http.ResponseWriter, req *http.Request) {
+	if ka.failureCORS == nil {
+		return
+	}
+
+	header := rw.Header()
+	header.Add("Vary", "Origin")
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return
+	}
+	if !ka.failureCORS.anyOrigin {
+		u, err := url.Parse(origin)
+		if err != nil || !matchAnyOrigin(ka.failureCORS.origins, u) {
+			return
+		}
+	}
+
+	header.Set("Access-Control-Allow-Origin", origin)
+	if ka.failureCORS.credentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
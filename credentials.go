@@ -0,0 +1,411 @@
+package swissknife
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const (
+	sourceHeader = "header"
+	sourceBearer = "bearer"
+	sourceQuery  = "query"
+	sourceCookie = "cookie"
+	sourceCustom = "custom"
+)
+
+var defaultExtractorOrder = []string{sourceHeader, sourceBearer, sourceQuery, sourceCookie, sourceWebSocket, sourceBasic, sourceBody, sourceForm}
+
+var sourceNames = map[string]struct{}{
+	sourceHeader:    {},
+	sourceBearer:    {},
+	sourceQuery:     {},
+	sourceCookie:    {},
+	sourceWebSocket: {},
+	sourceBasic:     {},
+	sourceBody:      {},
+	sourceForm:      {},
+}
+
+// authStatusHeader tells the upstream of an optional route whether the
+// request was made with a valid key.
+const (
+	authStatusHeader        = "X-Auth-Status"
+	authStatusAnonymous     = "anonymous"
+	authStatusAuthenticated = "authenticated"
+	authStatusUnchecked     = "unchecked"
+)
+
+// Extractor finds the key presented by the client in one place of the
+// request. found is true when the client sent something there, even if it is
+// empty.
+type Extractor interface {
+	Extract(req *http.Request) (credential string, found bool)
+	Strip(req *http.Request)
+}
+
+// multiValueExtractor is implemented by the extractors of headers, whose
+// every value is a separate credential. Only the value that was accepted is
+// stripped.
+type multiValueExtractor interface {
+	appendAll(credentials []credential, req *http.Request) ([]credential, bool)
+	stripValue(req *http.Request, raw string)
+}
+
+// credential is a key presented by the client, along with the extractor that
+// found it so it can be removed from the request once it has been accepted.
+// raw is the header value the key was read from, and user the user name
+// sent along with it, for Basic credentials.
+type credential struct {
+	extractor Extractor
+	source    string
+	value     string
+	raw       string
+	user      string
+}
+
+type headerExtractor struct {
+	name string
+}
+
+func (e *headerExtractor) Extract(req *http.Request) (string, bool) {
+	values := req.Header.Values(e.name)
+	if len(values) == 0 {
+		return "", false
+	}
+	return values[0], true
+}
+
+func (e *headerExtractor) Strip(req *http.Request) {
+	req.Header.Del(e.name)
+}
+
+func (e *headerExtractor) appendAll(credentials []credential, req *http.Request) ([]credential, bool) {
+	values := req.Header.Values(e.name)
+	for _, value := range values {
+		credentials = append(credentials, credential{extractor: e, source: sourceHeader, value: value, raw: value})
+	}
+	return credentials, len(values) > 0
+}
+
+func (e *headerExtractor) stripValue(req *http.Request, raw string) {
+	removeHeaderValue(req, e.name, raw)
+}
+
+// bearerExtractor finds tokens in the values of an authorization header with
+// one of the configured schemes. Values in other schemes may carry
+// credentials for the upstream and are left alone.
+type bearerExtractor struct {
+	name    string
+	schemes []string
+}
+
+func (e *bearerExtractor) Extract(req *http.Request) (string, bool) {
+	for _, value := range req.Header.Values(e.name) {
+		if token, ok := bearer(value, e.schemes); ok {
+			return token, true
+		}
+	}
+	return "", false
+}
+
+func (e *bearerExtractor) Strip(req *http.Request) {
+	for _, value := range req.Header.Values(e.name) {
+		if _, ok := bearer(value, e.schemes); ok {
+			removeHeaderValue(req, e.name, value)
+		}
+	}
+}
+
+func (e *bearerExtractor) appendAll(credentials []credential, req *http.Request) ([]credential, bool) {
+	found := false
+	for _, value := range req.Header.Values(e.name) {
+		if token, ok := bearer(value, e.schemes); ok {
+			credentials = append(credentials, credential{extractor: e, source: sourceBearer, value: token, raw: value})
+			found = true
+		}
+	}
+	return credentials, found
+}
+
+func (e *bearerExtractor) stripValue(req *http.Request, raw string) {
+	removeHeaderValue(req, e.name, raw)
+}
+
+type queryExtractor struct {
+	name string
+}
+
+func (e *queryExtractor) Extract(req *http.Request) (string, bool) {
+	query := req.URL.Query()
+	return query.Get(e.name), query.Has(e.name)
+}
+
+func (e *queryExtractor) Strip(req *http.Request) {
+	removeQueryParam(req, e.name)
+}
+
+type cookieExtractor struct {
+	name string
+}
+
+func (e *cookieExtractor) Extract(req *http.Request) (string, bool) {
+	cookie, err := req.Cookie(e.name)
+	if err != nil {
+		return "", false
+	}
+	return cookie.Value, true
+}
+
+func (e *cookieExtractor) Strip(req *http.Request) {
+	removeCookie(req, e.name)
+}
+
+// newExtractors returns the extractors of the enabled sources, in the order
+// given by config.ExtractorOrder, or its alias config.SourceOrder. Enabled
+// sources missing from the order come last, in the default order. Every
+// source in the order must be enabled.
+func newExtractors(config *Config, headerNames []string) ([]Extractor, error) {
+	bySource := make(map[string][]Extractor)
+	if config.AuthenticationHeader {
+		for _, name := range headerNames {
+			bySource[sourceHeader] = append(bySource[sourceHeader], &headerExtractor{name: http.CanonicalHeaderKey(name)})
+		}
+	}
+	if config.BearerHeader {
+		bySource[sourceBearer] = []Extractor{&bearerExtractor{name: http.CanonicalHeaderKey(config.BearerHeaderName), schemes: config.BearerSchemes}}
+	}
+	if config.QueryParam {
+		bySource[sourceQuery] = []Extractor{&queryExtractor{name: config.QueryParamName}}
+	}
+	if config.Cookie {
+		bySource[sourceCookie] = []Extractor{&cookieExtractor{name: config.CookieName}}
+	}
+	if config.Rfc6750Sources {
+		if !config.QueryParam || config.QueryParamName != accessTokenParam {
+			bySource[sourceQuery] = append(bySource[sourceQuery], &queryExtractor{name: accessTokenParam})
+		}
+		bySource[sourceForm] = []Extractor{&formExtractor{}}
+	}
+	if config.BodyAuth.Enabled {
+		extractor, err := newBodyExtractor(config.BodyAuth)
+		if err != nil {
+			return nil, err
+		}
+		bySource[sourceBody] = []Extractor{extractor}
+	}
+	if config.BasicAuth {
+		bySource[sourceBasic] = []Extractor{&basicExtractor{name: http.CanonicalHeaderKey(config.BearerHeaderName)}}
+	}
+	if config.WebSocketProtocolAuth {
+		bySource[sourceWebSocket] = []Extractor{&webSocketExtractor{}}
+	}
+
+	order := config.ExtractorOrder
+	if len(config.SourceOrder) > 0 {
+		if len(config.ExtractorOrder) > 0 {
+			return nil, errors.New("source order and extractor order cannot be used together")
+		}
+		order = config.SourceOrder
+	}
+	for _, source := range order {
+		if _, ok := sourceNames[source]; !ok {
+			return nil, fmt.Errorf("unknown extractor %q", source)
+		}
+		if len(bySource[source]) == 0 {
+			return nil, fmt.Errorf("extractor %q is in the order but not enabled", source)
+		}
+	}
+
+	seen := make(map[string]bool)
+	var extractors []Extractor
+	for _, source := range append(append([]string{}, order...), defaultExtractorOrder...) {
+		if seen[source] {
+			continue
+		}
+		seen[source] = true
+		extractors = append(extractors, bySource[source]...)
+	}
+	return extractors, nil
+}
+
+// credentialBuffers recycles the slices the credentials of requests are
+// collected in, so that authorizing a request does not allocate. Nothing
+// refers to them once serve returns.
+var credentialBuffers = sync.Pool{New: func() interface{} { return new([]credential) }}
+
+// releaseCredentials clears buf, so that it keeps no key alive, and returns
+// it to credentialBuffers.
+func releaseCredentials(buf *[]credential) {
+	values := (*buf)[:cap(*buf)]
+	for i := range values {
+		values[i] = credential{}
+	}
+	*buf = values[:0]
+	credentialBuffers.Put(buf)
+}
+
+// credentials appends the keys found by the extractors to credentials, in
+// order. Every value of a header repeated by the client is a separate key.
+// Sources that are empty present no key, so they can never match, but they
+// still count as presented: presented is false only when the client sent
+// nothing at all in any source. Unless every extractor is tried, the first
+// extractor finding a key wins. A key longer than allowed stops the search,
+// and oversized is set to its source.
+func (ka *SwissKnife) credentials(req *http.Request, credentials []credential) (_ []credential, presented bool, oversized string) {
+	for _, extractor := range ka.extractors {
+		start := len(credentials)
+		var ok bool
+		if multi, isMulti := extractor.(multiValueExtractor); isMulti {
+			credentials, ok = multi.appendAll(credentials, req)
+		} else {
+			var value string
+			value, ok = extractor.Extract(req)
+			if ok {
+				credentials = append(credentials, credential{extractor: extractor, source: extractorSource(extractor), value: value})
+			}
+		}
+		if !ok {
+			continue
+		}
+
+		presented = true
+		kept := credentials[:start]
+		for _, c := range credentials[start:] {
+			if ka.maxCredentialLength > 0 && len(c.value) > ka.credentialLimit(c) {
+				return nil, true, c.source
+			}
+			if ka.trimKeys {
+				c.value = strings.TrimSpace(c.value)
+			}
+			if c.value == "" {
+				if ka.logger.enabledFor(levelDebug) {
+					ka.logger.debug(req, "", fmt.Sprintf("Empty key in %s", c.source))
+				}
+				continue
+			}
+			kept = append(kept, c)
+		}
+		credentials = kept
+		if !ka.tryAllExtractors && !ka.strictConflicts && len(credentials) > 0 {
+			break
+		}
+	}
+
+	return credentials, presented, ""
+}
+
+// maxTokenLength is the length allowed for bearer tokens when JWTs or signed
+// tokens are accepted, as they are longer than keys.
+const maxTokenLength = 8 << 10
+
+// credentialLimit returns the maximum length of c.
+func (ka *SwissKnife) credentialLimit(c credential) int {
+	if c.source == sourceBearer && (ka.jwt != nil || ka.signedTokens != nil) && ka.maxCredentialLength < maxTokenLength {
+		return maxTokenLength
+	}
+	return ka.maxCredentialLength
+}
+
+func extractorSource(extractor Extractor) string {
+	switch extractor.(type) {
+	case *headerExtractor:
+		return sourceHeader
+	case *bearerExtractor:
+		return sourceBearer
+	case *queryExtractor:
+		return sourceQuery
+	case *cookieExtractor:
+		return sourceCookie
+	case *webSocketExtractor:
+		return sourceWebSocket
+	case *basicExtractor:
+		return sourceBasic
+	case *bodyExtractor:
+		return sourceBody
+	case *formExtractor:
+		return sourceForm
+	}
+	return sourceCustom
+}
+
+func (c *credential) strip(req *http.Request) {
+	if multi, ok := c.extractor.(multiValueExtractor); ok {
+		multi.stripValue(req, c.raw)
+		return
+	}
+	c.extractor.Strip(req)
+}
+
+// bearer extracts the token from an authorization header whose scheme is one
+// of schemes, compared case-insensitively as per RFC 7235. The scheme and
+// token may be separated by any amount of spaces or tabs, and whitespace
+// around the token is trimmed. ok is true when the scheme matched, even if
+// the token is empty.
+func bearer(header string, schemes []string) (token string, ok bool) {
+	header = strings.TrimSpace(header)
+	scheme, token := header, ""
+	if i := strings.IndexAny(header, " \t"); i >= 0 {
+		scheme, token = header[:i], strings.TrimSpace(header[i:])
+	}
+
+	for _, s := range schemes {
+		if strings.EqualFold(s, scheme) {
+			return token, true
+		}
+	}
+	return "", false
+}
+
+// removeHeaderValue removes value from the values of the header name, keeping
+// the others.
+func removeHeaderValue(req *http.Request, name, value string) {
+	values := req.Header.Values(name)
+	if len(values) == 1 && values[0] == value {
+		req.Header.Del(name)
+		return
+	}
+
+	var kept []string
+	for _, v := range values {
+		if v != value {
+			kept = append(kept, v)
+		}
+	}
+
+	req.Header.Del(name)
+	for _, v := range kept {
+		req.Header.Add(name, v)
+	}
+}
+
+func removeQueryParam(req *http.Request, name string) {
+	query := req.URL.Query()
+	query.Del(name)
+	req.URL.RawQuery = query.Encode()
+	req.RequestURI = req.URL.RequestURI()
+}
+
+func removeCookie(req *http.Request, name string) {
+	var kept []string
+	for _, line := range req.Header.Values("Cookie") {
+		for _, part := range strings.Split(line, ";") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			cookieName, _, _ := strings.Cut(part, "=")
+			if strings.TrimSpace(cookieName) == name {
+				continue
+			}
+			kept = append(kept, part)
+		}
+	}
+
+	req.Header.Del("Cookie")
+	if len(kept) > 0 {
+		req.Header.Set("Cookie", strings.Join(kept, "; "))
+	}
+}
@@ -0,0 +1,310 @@
+package swissknife
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBearerSchemes(t *testing.T) {
+	tests := []struct {
+		name    string
+		schemes []string
+		header  string
+		want    int
+	}{
+		{name: "default scheme", header: "Bearer secret-key-1", want: http.StatusOK},
+		{name: "lowercase scheme", header: "bearer secret-key-1", want: http.StatusOK},
+		{name: "uppercase scheme", header: "BEARER secret-key-1", want: http.StatusOK},
+		{name: "several spaces", header: "Bearer    secret-key-1", want: http.StatusOK},
+		{name: "scheme only", header: "Bearer", want: http.StatusForbidden},
+		{name: "other scheme", header: "Basic c2VjcmV0", want: http.StatusForbidden},
+		{name: "configured scheme", schemes: []string{"Bearer", "ApiKey"}, header: "apikey secret-key-1", want: http.StatusOK},
+		{name: "scheme prefix of the configured one", schemes: []string{"TokenV2"}, header: "Token secret-key-1", want: http.StatusForbidden},
+		{name: "configured scheme prefix of the sent one", schemes: []string{"Token"}, header: "TokenV2 secret-key-1", want: http.StatusForbidden},
+		{name: "both prefixed schemes", schemes: []string{"Token", "TokenV2"}, header: "TokenV2 secret-key-1", want: http.StatusOK},
+		{name: "scheme glued to the token", schemes: []string{"Token"}, header: "Tokensecret-key-1", want: http.StatusForbidden},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := CreateConfig()
+			config.Keys = []string{"secret-key-1"}
+			config.BearerHeader = true
+			if test.schemes != nil {
+				config.BearerSchemes = test.schemes
+			}
+			ka := newTestHandler(t, config, nil)
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Authorization", test.header)
+			rec := httptest.NewRecorder()
+			ka.ServeHTTP(rec, req)
+			if rec.Code != test.want {
+				t.Errorf("status code = %d, want %d", rec.Code, test.want)
+			}
+		})
+	}
+}
+
+func TestInvalidBearerSchemes(t *testing.T) {
+	tests := []struct {
+		name    string
+		schemes []string
+		want    string
+	}{
+		{name: "no scheme", schemes: []string{}, want: "at least one bearer scheme must be set"},
+		{name: "empty scheme", schemes: []string{""}, want: `invalid bearer scheme ""`},
+		{name: "scheme with a space", schemes: []string{"Api Key"}, want: `invalid bearer scheme "Api Key"`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := CreateConfig()
+			config.Keys = []string{"secret-key-1"}
+			config.BearerHeader = true
+			config.BearerSchemes = test.schemes
+			_, err := New(context.Background(), noopHandler, config, "test")
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("New() error = %v, want %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestBearer(t *testing.T) {
+	schemes := []string{"Bearer"}
+	tests := []struct {
+		name      string
+		header    string
+		wantToken string
+		wantOK    bool
+	}{
+		{name: "canonical", header: "Bearer abc", wantToken: "abc", wantOK: true},
+		{name: "lowercase scheme", header: "bearer abc", wantToken: "abc", wantOK: true},
+		{name: "double space", header: "Bearer  abc", wantToken: "abc", wantOK: true},
+		{name: "tab", header: "Bearer\tabc", wantToken: "abc", wantOK: true},
+		{name: "tabs and spaces", header: "Bearer \t abc", wantToken: "abc", wantOK: true},
+		{name: "trailing spaces", header: "Bearer abc  ", wantToken: "abc", wantOK: true},
+		{name: "trailing tab", header: "Bearer abc\t", wantToken: "abc", wantOK: true},
+		{name: "leading spaces", header: "  Bearer abc", wantToken: "abc", wantOK: true},
+		{name: "empty token", header: "Bearer ", wantToken: "", wantOK: true},
+		{name: "scheme only", header: "Bearer", wantToken: "", wantOK: true},
+		{name: "other scheme", header: "Basic abc", wantOK: false},
+		{name: "empty header", header: "", wantOK: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			token, ok := bearer(test.header, schemes)
+			if token != test.wantToken || ok != test.wantOK {
+				t.Errorf("bearer(%q) = %q, %v, want %q, %v", test.header, token, ok, test.wantToken, test.wantOK)
+			}
+		})
+	}
+}
+
+// TestEmptyBearerToken checks that an empty token counts as a presented but
+// invalid key, not as a missing one.
+func TestEmptyBearerToken(t *testing.T) {
+	config := CreateConfig()
+	config.Keys = []string{"secret-key-1"}
+	config.BearerHeader = true
+	config.DistinguishMissingCredential = true
+	ka := newTestHandler(t, config, nil)
+
+	for _, header := range []string{"Bearer", "Bearer ", "Bearer \t "} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", header)
+		rec := httptest.NewRecorder()
+		ka.ServeHTTP(rec, req)
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("status code for %q = %d, want %d", header, rec.Code, http.StatusForbidden)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	ka.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != config.MissingCredentialStatusCode {
+		t.Errorf("status code without a key = %d, want %d", rec.Code, config.MissingCredentialStatusCode)
+	}
+}
+
+func TestRemoveMatchedAuthorizationValue(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []string
+		want   []string
+	}{
+		{name: "bearer only", values: []string{"Bearer secret-key-1"}, want: nil},
+		{name: "bearer then basic", values: []string{"Bearer secret-key-1", "Basic dXNlcjpwYXNz"}, want: []string{"Basic dXNlcjpwYXNz"}},
+		{name: "basic then bearer", values: []string{"Basic dXNlcjpwYXNz", "Bearer secret-key-1"}, want: []string{"Basic dXNlcjpwYXNz"}},
+		{name: "other values kept in order", values: []string{"Digest a", "Bearer secret-key-1", "Basic dXNlcjpwYXNz"}, want: []string{"Digest a", "Basic dXNlcjpwYXNz"}},
+		{name: "invalid bearer kept", values: []string{"Bearer wrong-key", "Bearer secret-key-1"}, want: []string{"Bearer wrong-key"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := CreateConfig()
+			config.Keys = []string{"secret-key-1"}
+			config.BearerHeader = true
+
+			var forwarded []string
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				forwarded = req.Header.Values("Authorization")
+			})
+			ka := newTestHandler(t, config, next)
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			for _, value := range test.values {
+				req.Header.Add("Authorization", value)
+			}
+			rec := httptest.NewRecorder()
+			ka.ServeHTTP(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status code = %d, want %d", rec.Code, http.StatusOK)
+			}
+			if !reflect.DeepEqual(forwarded, test.want) {
+				t.Errorf("forwarded Authorization = %q, want %q", forwarded, test.want)
+			}
+		})
+	}
+}
+
+func TestDuplicateHeaders(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		values []string
+		want   int
+		kept   []string
+	}{
+		{name: "wrong then correct", header: "X-API-KEY", values: []string{"wrong-key", "secret-key-1"}, want: http.StatusOK, kept: []string{"wrong-key"}},
+		{name: "correct then wrong", header: "X-API-KEY", values: []string{"secret-key-1", "wrong-key"}, want: http.StatusOK, kept: []string{"wrong-key"}},
+		{name: "both wrong", header: "X-API-KEY", values: []string{"wrong-key", "other-key"}, want: http.StatusForbidden},
+		{name: "empty then correct", header: "X-API-KEY", values: []string{"", "secret-key-1"}, want: http.StatusOK, kept: []string{""}},
+		{name: "bearer wrong then correct", header: "Authorization", values: []string{"Bearer wrong-key", "Bearer secret-key-1"}, want: http.StatusOK, kept: []string{"Bearer wrong-key"}},
+		{name: "bearer correct then wrong", header: "Authorization", values: []string{"Bearer secret-key-1", "Bearer wrong-key"}, want: http.StatusOK, kept: []string{"Bearer wrong-key"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := CreateConfig()
+			config.Keys = []string{"secret-key-1"}
+			config.BearerHeader = true
+
+			var forwarded []string
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				forwarded = req.Header.Values(test.header)
+			})
+			ka := newTestHandler(t, config, next)
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			for _, value := range test.values {
+				req.Header.Add(test.header, value)
+			}
+			rec := httptest.NewRecorder()
+			ka.ServeHTTP(rec, req)
+			if rec.Code != test.want {
+				t.Fatalf("status code = %d, want %d", rec.Code, test.want)
+			}
+			if test.want == http.StatusOK && !reflect.DeepEqual(forwarded, test.kept) {
+				t.Errorf("forwarded %s = %q, want %q", test.header, forwarded, test.kept)
+			}
+		})
+	}
+}
+
+func TestTryAllExtractors(t *testing.T) {
+	tests := []struct {
+		name   string
+		tryAll bool
+		header string
+		bearer string
+		want   int
+	}{
+		{name: "first source valid", header: "secret-key-1", bearer: "wrong-key", want: http.StatusOK},
+		{name: "first source invalid", header: "wrong-key", bearer: "secret-key-1", want: http.StatusForbidden},
+		{name: "first source invalid, all tried", tryAll: true, header: "wrong-key", bearer: "secret-key-1", want: http.StatusOK},
+		{name: "only later source", bearer: "secret-key-1", want: http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := CreateConfig()
+			config.Keys = []string{"secret-key-1"}
+			config.BearerHeader = true
+			config.TryAllExtractors = test.tryAll
+			ka := newTestHandler(t, config, nil)
+			ka.SetLogger(&logRecorder{})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if test.header != "" {
+				req.Header.Set("X-API-KEY", test.header)
+			}
+			req.Header.Set("Authorization", "Bearer "+test.bearer)
+			if code := serveRecorded(ka, req).Code; code != test.want {
+				t.Errorf("status code = %d, want %d", code, test.want)
+			}
+		})
+	}
+}
+
+func TestSourceOrder(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*Config)
+		header string
+		bearer string
+		want   int
+	}{
+		{name: "default order", header: "wrong-key", bearer: "secret-key-1", want: http.StatusForbidden},
+		{name: "bearer first", modify: func(c *Config) { c.ExtractorOrder = []string{"bearer", "header"} }, header: "wrong-key", bearer: "secret-key-1", want: http.StatusOK},
+		{name: "bearer first, invalid", modify: func(c *Config) { c.ExtractorOrder = []string{"bearer"} }, header: "secret-key-1", bearer: "wrong-key", want: http.StatusForbidden},
+		{name: "source order alias", modify: func(c *Config) { c.SourceOrder = []string{"bearer", "header"} }, header: "wrong-key", bearer: "secret-key-1", want: http.StatusOK},
+		{name: "exhaustive search alias", modify: func(c *Config) { c.ExhaustiveSearch = true }, header: "wrong-key", bearer: "secret-key-1", want: http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := CreateConfig()
+			config.Keys = []string{"secret-key-1"}
+			config.BearerHeader = true
+			if test.modify != nil {
+				test.modify(config)
+			}
+			ka := newTestHandler(t, config, nil)
+			ka.SetLogger(&logRecorder{})
+
+			req := newKeyRequest(test.header)
+			req.Header.Set("Authorization", "Bearer "+test.bearer)
+			if code := serveRecorded(ka, req).Code; code != test.want {
+				t.Errorf("status code = %d, want %d", code, test.want)
+			}
+		})
+	}
+}
+
+func TestInvalidSourceOrder(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*Config)
+		want   string
+	}{
+		{name: "disabled source", modify: func(c *Config) { c.ExtractorOrder = []string{"bearer", "header", "query"} }, want: `extractor "query" is in the order but not enabled`},
+		{name: "disabled source in source order", modify: func(c *Config) { c.SourceOrder = []string{"cookie"} }, want: `extractor "cookie" is in the order but not enabled`},
+		{name: "unknown source", modify: func(c *Config) { c.SourceOrder = []string{"headers"} }, want: `unknown extractor "headers"`},
+		{name: "both orders", modify: func(c *Config) {
+			c.ExtractorOrder = []string{"header"}
+			c.SourceOrder = []string{"bearer"}
+		}, want: "source order and extractor order cannot be used together"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := CreateConfig()
+			config.Keys = []string{"secret-key-1"}
+			config.BearerHeader = true
+			test.modify(config)
+			_, err := New(context.Background(), noopHandler, config, "test")
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("New() error = %v, want %q", err, test.want)
+			}
+		})
+	}
+}
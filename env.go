@@ -0,0 +1,139 @@
+package swissknife
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// expandEnv replaces the ${VAR} and $VAR references in value with the values
+// of the environment variables, and $$ with $. A variable that is unset or
+// empty is an error, so that a missing secret cannot turn into an empty key.
+func expandEnv(value string) (string, error) {
+	var err error
+	expanded := os.Expand(value, func(name string) string {
+		if name == "$" {
+			return "$"
+		}
+		v := os.Getenv(name)
+		if v == "" && err == nil {
+			err = fmt.Errorf("environment variable %q is unset or empty", name)
+		}
+		return v
+	})
+	return expanded, err
+}
+
+// expandConfigEnv returns a copy of config with the environment variables
+// expanded in keys, secrets and injected header values, and the keys of
+// keysCSV merged into keys. bcrypt hashes are left as they are, since they
+// contain $ signs of their own.
+func expandConfigEnv(config *Config) (*Config, error) {
+	expanded := *config
+
+	expanded.Keys = make([]string, len(config.Keys))
+	for i, key := range config.Keys {
+		var err error
+		if expanded.Keys[i], err = expandKey(key); err != nil {
+			return nil, fmt.Errorf("invalid key at index %d: %w", i, err)
+		}
+	}
+
+	csvKeys := splitKeysCSV(config.KeysCSV)
+	for i, key := range csvKeys {
+		var err error
+		if csvKeys[i], err = expandKey(key); err != nil {
+			return nil, fmt.Errorf("invalid key at index %d of keys CSV: %w", i, err)
+		}
+	}
+	expanded.Keys = mergeKeys(expanded.Keys, csvKeys)
+	expanded.KeysCSV = ""
+
+	expanded.RevokedKeys = make([]string, len(config.RevokedKeys))
+	for i, key := range config.RevokedKeys {
+		var err error
+		if expanded.RevokedKeys[i], err = expandKey(key); err != nil {
+			return nil, fmt.Errorf("invalid revoked key at index %d: %w", i, err)
+		}
+	}
+
+	expanded.KeyEntries = make([]KeyEntry, len(config.KeyEntries))
+	for i, entry := range config.KeyEntries {
+		var err error
+		if entry.Key, err = expandKey(entry.Key); err != nil {
+			return nil, fmt.Errorf("invalid key entry at index %d: %w", i, err)
+		}
+		if entry.UpstreamToken, err = expandEnv(entry.UpstreamToken); err != nil {
+			return nil, fmt.Errorf("invalid key entry at index %d: upstream token: %w", i, err)
+		}
+		if entry.Headers != nil {
+			headers := make(map[string]string, len(entry.Headers))
+			for name, value := range entry.Headers {
+				if headers[name], err = expandEnv(value); err != nil {
+					return nil, fmt.Errorf("invalid key entry at index %d: header %q: %w", i, name, err)
+				}
+			}
+			entry.Headers = headers
+		}
+		expanded.KeyEntries[i] = entry
+	}
+
+	expanded.KeyGroups = make([]KeyGroup, len(config.KeyGroups))
+	for i, group := range config.KeyGroups {
+		keys := make([]string, len(group.Keys))
+		for j, key := range group.Keys {
+			var err error
+			if keys[j], err = expandKey(key); err != nil {
+				return nil, fmt.Errorf("invalid key group %q: key at index %d: %w", group.Name, j, err)
+			}
+		}
+		group.Keys = keys
+		if group.Headers != nil {
+			headers := make(map[string]string, len(group.Headers))
+			for name, value := range group.Headers {
+				var err error
+				if headers[name], err = expandEnv(value); err != nil {
+					return nil, fmt.Errorf("invalid key group %q: header %q: %w", group.Name, name, err)
+				}
+			}
+			group.Headers = headers
+		}
+		expanded.KeyGroups[i] = group
+	}
+
+	var err error
+	if expanded.UpstreamToken, err = expandEnv(config.UpstreamToken); err != nil {
+		return nil, fmt.Errorf("invalid upstream token: %w", err)
+	}
+	if expanded.JWT.HS256Secret, err = expandEnv(config.JWT.HS256Secret); err != nil {
+		return nil, fmt.Errorf("invalid JWT secret: %w", err)
+	}
+	if expanded.SignedTokens.Secret, err = expandEnv(config.SignedTokens.Secret); err != nil {
+		return nil, fmt.Errorf("invalid signed token secret: %w", err)
+	}
+
+	if config.FailureWebhook.Headers != nil {
+		expanded.FailureWebhook.Headers = make(map[string]string, len(config.FailureWebhook.Headers))
+		for name, value := range config.FailureWebhook.Headers {
+			if expanded.FailureWebhook.Headers[name], err = expandEnv(value); err != nil {
+				return nil, fmt.Errorf("invalid failure webhook header %q: %w", name, err)
+			}
+		}
+	}
+
+	expanded.SignatureAuth.Secrets = make([]SignatureSecret, len(config.SignatureAuth.Secrets))
+	for i, secret := range config.SignatureAuth.Secrets {
+		if secret.Secret, err = expandEnv(secret.Secret); err != nil {
+			return nil, fmt.Errorf("invalid signature secret at index %d: %w", i, err)
+		}
+		expanded.SignatureAuth.Secrets[i] = secret
+	}
+	return &expanded, nil
+}
+
+func expandKey(key string) (string, error) {
+	if strings.HasPrefix(key, bcryptKeyPrefix) {
+		return key, nil
+	}
+	return expandEnv(key)
+}
@@ -0,0 +1,162 @@
+package swissknife
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+const (
+	messageUnavailable   = "Key validation unavailable"
+	messageForbidden     = "API Key not allowed"
+	messageBanned        = "Too many failed attempts"
+	messageInvalidToken  = "Invalid token"
+	messageReplayed      = "Request already received"
+	messageConflict      = "Conflicting credentials"
+	messageTLSRequired   = "HTTPS required"
+	messageQuotaExceeded = "Quota exceeded"
+	messageInternalError = "Internal server error"
+)
+
+// errorBody is the encoded body of an error response, computed once as it
+// only depends on the configuration. In the problem format, the path of the
+// request is the only dynamic field: it goes between prefix and suffix.
+type errorBody struct {
+	prefix []byte
+	suffix []byte
+
+	// contentType and contentLength are header values shared by every
+	// response, which is safe as appending to them copies them.
+	contentType   []string
+	contentLength []string
+}
+
+// precomputeErrorBodies encodes the bodies of the error responses the plugin
+// sends. Other responses, and those rendered from a template, are encoded
+// for every request.
+func (ka *SwissKnife) precomputeErrorBodies() error {
+	responses := []Response{
+		{Message: ka.unauthorizedMessage, StatusCode: ka.unauthorizedStatusCode},
+		{Message: ka.unauthorizedMessage, StatusCode: http.StatusUnauthorized},
+		{Message: messageUnavailable, StatusCode: ka.validationUnavailableStatusCode},
+		{Message: messageForbidden, StatusCode: http.StatusForbidden},
+		{Message: messageBanned, StatusCode: http.StatusTooManyRequests},
+		{Message: messageInvalidToken, StatusCode: http.StatusUnauthorized},
+		{Message: messageReplayed, StatusCode: ka.unauthorizedStatusCode},
+		{Message: messageConflict, StatusCode: ka.unauthorizedStatusCode},
+		{Message: messageTLSRequired, StatusCode: http.StatusForbidden},
+		{Message: messageQuotaExceeded, StatusCode: http.StatusTooManyRequests},
+	}
+	if ka.distinguishMissing {
+		responses = append(responses,
+			Response{Message: ka.missingMessage, StatusCode: ka.missingStatusCode},
+			Response{Message: ka.missingMessage, StatusCode: http.StatusUnauthorized})
+	}
+	if ka.errorMessages != nil {
+		for _, message := range ka.errorMessages.messages {
+			responses = append(responses,
+				Response{Message: message, StatusCode: ka.unauthorizedStatusCode},
+				Response{Message: message, StatusCode: http.StatusUnauthorized})
+		}
+	}
+	if ka.maintenance != nil {
+		responses = append(responses, Response{Message: ka.maintenance.message, StatusCode: http.StatusServiceUnavailable})
+	}
+
+	ka.errorBodies = make(map[Response]errorBody, len(responses))
+	for _, response := range responses {
+		if !ka.problemFormat {
+			encoded, err := json.Marshal(response)
+			if err != nil {
+				return err
+			}
+			body := append(encoded, '\n')
+			ka.errorBodies[response] = errorBody{
+				prefix:        body,
+				contentType:   []string{"application/json; charset=utf-8"},
+				contentLength: []string{strconv.Itoa(len(body))},
+			}
+			continue
+		}
+
+		encoded, err := json.Marshal(ProblemResponse{
+			Type:   ka.problemType,
+			Title:  http.StatusText(response.StatusCode),
+			Status: response.StatusCode,
+			Detail: response.Message,
+		})
+		if err != nil {
+			return err
+		}
+		prefix := append(encoded[:len(encoded)-1:len(encoded)-1], `,"instance":`...)
+		ka.errorBodies[response] = errorBody{
+			prefix:      prefix,
+			suffix:      []byte("}\n"),
+			contentType: []string{"application/problem+json"},
+		}
+	}
+	return nil
+}
+
+// render returns the body for a request to path, or nil if it has to be
+// encoded the slow way.
+func (b errorBody) render(path string) []byte {
+	if b.suffix == nil {
+		return b.prefix
+	}
+	if path == "" {
+		// The instance is omitted from the body.
+		return nil
+	}
+
+	body := make([]byte, 0, len(b.prefix)+len(path)+len(b.suffix)+2)
+	body = append(body, b.prefix...)
+	body = appendJSONString(body, path)
+	if body == nil {
+		return nil
+	}
+	return append(body, b.suffix...)
+}
+
+// appendJSONString appends s to dst as a JSON string if it does not need to
+// be escaped, and returns nil otherwise.
+func appendJSONString(dst []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < 0x20 || c >= 0x80 || c == '"' || c == '\\' || c == '<' || c == '>' || c == '&' {
+			return nil
+		}
+	}
+	dst = append(dst, '"')
+	dst = append(dst, s...)
+	return append(dst, '"')
+}
+
+// writeErrorBody writes the precomputed body of response, and reports whether
+// there was one.
+func (ka *SwissKnife) writeErrorBody(rw http.ResponseWriter, req *http.Request, response Response) bool {
+	precomputed, ok := ka.errorBodies[response]
+	if !ok {
+		return false
+	}
+	body := precomputed.render(req.URL.Path)
+	if body == nil {
+		return false
+	}
+
+	header := rw.Header()
+	header["Content-Type"] = precomputed.contentType
+	if precomputed.contentLength != nil {
+		header["Content-Length"] = precomputed.contentLength
+	} else {
+		header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	rw.WriteHeader(response.StatusCode)
+	_, _ = rw.Write(body)
+
+	if ka.logger.enabledFor(levelInfo) {
+		ka.logger.response(fmt.Sprintf("Response: %d %s", response.StatusCode, response.Message))
+	}
+	return true
+}
@@ -0,0 +1,87 @@
+package swissknife
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newRejectingHandler returns the plugin with config in the errorFormat, and
+// a request to path with a wrong key.
+func newRejectingHandler(tb testing.TB, errorFormat, path string) (*SwissKnife, *http.Request) {
+	tb.Helper()
+
+	config := CreateConfig()
+	config.Keys = []string{"secret-key-1"}
+	config.ErrorFormat = errorFormat
+	ka := newTestHandler(tb, config, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	req.URL.Path = path
+	req.Header.Set("X-API-KEY", "wrong-key")
+	return ka, req
+}
+
+func TestPrecomputedErrorBodies(t *testing.T) {
+	tests := []struct {
+		name        string
+		errorFormat string
+		path        string
+	}{
+		{name: "simple", errorFormat: "simple", path: "/orders"},
+		{name: "problem", errorFormat: "problem", path: "/orders"},
+		{name: "problem, escaped path", errorFormat: "problem", path: `/orders/"1"&<2>`},
+		{name: "problem, empty path", errorFormat: "problem", path: ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ka, req := newRejectingHandler(t, test.errorFormat, test.path)
+			precomputed := httptest.NewRecorder()
+			ka.ServeHTTP(precomputed, req)
+
+			ka.errorBodies = nil
+			encoded := httptest.NewRecorder()
+			ka.ServeHTTP(encoded, req)
+
+			if precomputed.Code != encoded.Code {
+				t.Errorf("status code = %d, want %d", precomputed.Code, encoded.Code)
+			}
+			if got, want := precomputed.Header().Get("Content-Type"), encoded.Header().Get("Content-Type"); got != want {
+				t.Errorf("Content-Type = %q, want %q", got, want)
+			}
+			if got, want := precomputed.Body.String(), encoded.Body.String(); got != want {
+				t.Errorf("body = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+// BenchmarkRejectedResponse compares the allocations of rejected requests
+// with the precomputed bodies and with bodies encoded for every request, as
+// before they were precomputed.
+func BenchmarkRejectedResponse(b *testing.B) {
+	for _, errorFormat := range []string{"simple", "problem"} {
+		for _, precomputed := range []bool{true, false} {
+			name := errorFormat + "/encoded"
+			if precomputed {
+				name = errorFormat + "/precomputed"
+			}
+			b.Run(name, func(b *testing.B) {
+				ka, req := newRejectingHandler(b, errorFormat, "/orders")
+				if !precomputed {
+					ka.errorBodies = nil
+				}
+				rw := &discardWriter{header: make(http.Header)}
+
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					for name := range rw.header {
+						delete(rw.header, name)
+					}
+					ka.ServeHTTP(rw, req)
+				}
+			})
+		}
+	}
+}
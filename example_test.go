@@ -0,0 +1,148 @@
+package swissknife_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	swissknife "github.com/quangnguyen/swiss-knife"
+)
+
+// upstream answers with the consumer name the middleware forwarded, if any.
+var upstream = http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+	_, _ = fmt.Fprint(rw, "forwarded")
+	if consumer := req.Header.Get("X-Consumer-Name"); consumer != "" {
+		_, _ = fmt.Fprintf(rw, " for %s", consumer)
+	}
+})
+
+func serve(handler http.Handler, req *http.Request) {
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	fmt.Printf("%d %s\n", rec.Code, rec.Body.String())
+}
+
+// Keys are read from the X-API-KEY header by default.
+func ExampleNew() {
+	config := swissknife.CreateConfig()
+	config.Keys = []string{"secret-key-1"}
+
+	handler, err := swissknife.New(context.Background(), upstream, config, "api-keys")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/orders", nil)
+	req.Header.Set("X-API-KEY", "secret-key-1")
+	serve(handler, req)
+
+	req = httptest.NewRequest(http.MethodGet, "/orders", nil)
+	req.Header.Set("X-API-KEY", "wrong-key")
+	serve(handler, req)
+
+	// Output:
+	// 200 forwarded
+	// 403 {"message":"Invalid API Key","statusCode":403}
+}
+
+// Named keys identify their consumer to the upstream, here with bearer
+// tokens.
+func ExampleNew_bearerKeyEntries() {
+	config := swissknife.CreateConfig()
+	config.AuthenticationHeader = false
+	config.BearerHeader = true
+	config.KeyEntries = []swissknife.KeyEntry{
+		{Name: "billing", Key: "secret-key-1"},
+		{Name: "reporting", Key: "secret-key-2"},
+	}
+
+	handler, err := swissknife.New(context.Background(), upstream, config, "bearer")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer secret-key-2")
+	serve(handler, req)
+
+	// Output:
+	// 200 forwarded for reporting
+}
+
+// Some paths can be left open, such as health checks.
+func ExampleNew_excludedPaths() {
+	config := swissknife.CreateConfig()
+	config.Keys = []string{"secret-key-1"}
+	config.ExcludedPaths = []string{"/health"}
+
+	handler, err := swissknife.New(context.Background(), upstream, config, "excluded")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	serve(handler, httptest.NewRequest(http.MethodGet, "/health", nil))
+	serve(handler, httptest.NewRequest(http.MethodGet, "/orders", nil))
+
+	// Output:
+	// 200 forwarded
+	// 403 {"message":"Invalid API Key","statusCode":403}
+}
+
+// Errors can follow RFC 7807 instead of the default JSON body.
+func ExampleNew_problemFormat() {
+	config := swissknife.CreateConfig()
+	config.Keys = []string{"secret-key-1"}
+	config.ErrorFormat = "problem"
+
+	handler, err := swissknife.New(context.Background(), upstream, config, "problem")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	serve(handler, httptest.NewRequest(http.MethodGet, "/orders", nil))
+
+	// Output:
+	// 403 {"type":"about:blank","title":"Forbidden","status":403,"detail":"Invalid API Key","instance":"/orders"}
+}
+
+// Invalid configurations are reported with all their problems at once.
+func ExampleConfig_Validate() {
+	config := swissknife.CreateConfig()
+	config.Keys = []string{"secret-key-1"}
+	config.AuthenticationHeaderName = "X API KEY"
+	config.UnauthorizedStatusCode = 200
+
+	fmt.Println(config.Validate())
+
+	// Output:
+	// invalid header name: "X API KEY" contains ' ', which is not allowed in header names
+	// unauthorized status code must be between 300 and 599, got 200
+}
+
+// Stats returns the request counters of a middleware instance.
+func ExampleSwissKnife_Stats() {
+	config := swissknife.CreateConfig()
+	config.KeyEntries = []swissknife.KeyEntry{{Name: "billing", Key: "secret-key-1"}}
+
+	handler, err := swissknife.New(context.Background(), upstream, config, "stats")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-API-KEY", "secret-key-1")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	stats := handler.(*swissknife.SwissKnife).Stats()
+	fmt.Println(stats.Requests, stats.Authorized, stats.Rejected["rejected"], stats.Keys["billing"])
+
+	// Output:
+	// 2 1 1 1
+}
@@ -0,0 +1,75 @@
+package swissknife
+
+import (
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	sourceForm = "form"
+
+	// accessTokenParam is the form and query parameter of RFC 6750.
+	accessTokenParam = "access_token"
+
+	formMaxBodyBytes = 1 << 20
+)
+
+// formExtractor finds access tokens in form-encoded bodies, as in RFC 6750
+// section 2.2: only in single-part bodies of requests other than GET.
+type formExtractor struct{}
+
+func (e *formExtractor) Extract(req *http.Request) (string, bool) {
+	form, ok := readForm(req)
+	if !ok {
+		return "", false
+	}
+	return form.Get(accessTokenParam), form.Has(accessTokenParam)
+}
+
+func (e *formExtractor) Strip(req *http.Request) {
+	form, ok := readForm(req)
+	if !ok || !form.Has(accessTokenParam) {
+		return
+	}
+	form.Del(accessTokenParam)
+
+	body := []byte(form.Encode())
+	setBody(req, body)
+	req.ContentLength = int64(len(body))
+	req.Header.Set("Content-Length", strconv.Itoa(len(body)))
+}
+
+func readForm(req *http.Request) (url.Values, bool) {
+	if req.Method == http.MethodGet || req.Body == nil || req.Body == http.NoBody {
+		return nil, false
+	}
+	contentType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil || !strings.EqualFold(contentType, "application/x-www-form-urlencoded") {
+		return nil, false
+	}
+
+	body, ok := readBodyUpTo(req, formMaxBodyBytes)
+	if !ok {
+		return nil, false
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, false
+	}
+	return form, true
+}
+
+// isRFC6750Credential reports whether c was sent with one of the methods of
+// RFC 6750: the authorization header, a form parameter or a query parameter.
+func isRFC6750Credential(c credential) bool {
+	switch e := c.extractor.(type) {
+	case *bearerExtractor, *formExtractor:
+		return true
+	case *queryExtractor:
+		return e.name == accessTokenParam
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+package swissknife
+
+import (
+	"net/http"
+	"net/url"
+)
+
+// forwardedRequest returns the request that Traefik's ForwardAuth middleware
+// is asking about, described by the X-Forwarded-Method, X-Forwarded-Host and
+// X-Forwarded-Uri headers, so that path and method rules apply to it rather
+// than to the authentication request itself.
+func forwardedRequest(req *http.Request) *http.Request {
+	method := req.Header.Get("X-Forwarded-Method")
+	uri := req.Header.Get("X-Forwarded-Uri")
+	if method == "" && uri == "" {
+		return req
+	}
+
+	forwarded := req.Clone(req.Context())
+	if method != "" {
+		forwarded.Method = method
+	}
+	if u, err := url.ParseRequestURI(uri); err == nil {
+		forwarded.URL = u
+	}
+	if host := req.Header.Get("X-Forwarded-Host"); host != "" {
+		forwarded.Host = host
+	}
+	return forwarded
+}
+
+// responseForwardAuth answers an allowed forward auth request with an empty
+// 200, carrying the consumer identity so ForwardAuth can copy it to the
+// upstream request with authResponseHeaders.
+func (ka *SwissKnife) responseForwardAuth(rw http.ResponseWriter, d decision) {
+	if name := d.consumerIdentity(); ka.consumerHeader != "" && name != "" {
+		rw.Header().Set(ka.consumerHeader, name)
+	}
+	if ka.kongHeaders {
+		setKongHeaders(rw.Header(), d)
+	}
+	if ka.prefixHeader != "" && d.matched != nil {
+		if environment := ka.keyEnvironment(d.matched.value); environment != "" {
+			rw.Header().Set(ka.prefixHeader, environment)
+		}
+	}
+	if d.entry != nil {
+		for name, value := range d.entry.headers {
+			rw.Header().Set(name, value)
+		}
+	}
+	if token := ka.upstreamTokenValue(d); token != "" {
+		rw.Header().Set(ka.upstreamTokenHeader, token)
+	}
+	switch {
+	case ka.optional && d.outcome == outcomeAnonymous:
+		rw.Header().Set(authStatusHeader, authStatusAnonymous)
+	case ka.optional && d.outcome == outcomeAuthorized:
+		rw.Header().Set(authStatusHeader, authStatusAuthenticated)
+	case d.outcome == outcomeUnchecked:
+		rw.Header().Set(authStatusHeader, authStatusUnchecked)
+	}
+	rw.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,57 @@
+package swissknife
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestConsumerIdentityOfBasicCredentials(t *testing.T) {
+	tests := []struct {
+		name        string
+		forwardAuth bool
+		keys        []string
+		entries     []KeyEntry
+		want        string
+	}{
+		{name: "forward, unnamed key", keys: []string{"secret-key-1"}, want: "alice"},
+		{name: "forward, named key", entries: []KeyEntry{{Name: "billing", Key: "secret-key-1"}}, want: "billing"},
+		{name: "forward auth, unnamed key", forwardAuth: true, keys: []string{"secret-key-1"}, want: "alice"},
+		{name: "forward auth, named key", forwardAuth: true, entries: []KeyEntry{{Name: "billing", Key: "secret-key-1"}}, want: "billing"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := CreateConfig()
+			config.BasicAuth = true
+			config.ForwardAuthMode = test.forwardAuth
+			config.Keys = test.keys
+			config.KeyEntries = test.entries
+
+			var upstream string
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				upstream = req.Header.Get("X-Consumer-Name")
+			})
+			handler, err := New(context.Background(), next, config, "basic")
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.SetBasicAuth("alice", "secret-key-1")
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status code = %d, want %d", rec.Code, http.StatusOK)
+			}
+
+			got := upstream
+			if test.forwardAuth {
+				got = rec.Header().Get("X-Consumer-Name")
+			}
+			if got != test.want {
+				t.Errorf("consumer = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
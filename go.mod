@@ -1,3 +1,5 @@
 module github.com/quangnguyen/swiss-knife
 
 go 1.22
+
+require golang.org/x/crypto v0.33.0
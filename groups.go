@@ -0,0 +1,57 @@
+package swissknife
+
+import (
+	"fmt"
+	"strings"
+)
+
+// KeyGroup gives several keys the same name and restrictions, as if each were
+// a key entry.
+//
+//nolint:all
+type KeyGroup struct {
+	Name    string            `json:"name,omitempty"`
+	Keys    []string          `json:"keys,omitempty"`
+	Paths   []string          `json:"paths,omitempty"`
+	Methods []string          `json:"methods,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// entries returns a key entry for every key of the group.
+func (g KeyGroup) entries() []KeyEntry {
+	entries := make([]KeyEntry, 0, len(g.Keys))
+	for _, key := range g.Keys {
+		entries = append(entries, KeyEntry{Name: g.Name, Key: key, Paths: g.Paths, Methods: g.Methods, Headers: g.Headers})
+	}
+	return entries
+}
+
+// validateKeyGroups checks that every group is named, has keys, and shares
+// no key with another group, as it would be unclear which rules apply.
+func validateKeyGroups(groups []KeyGroup, trim bool) []error {
+	var errs []error
+	owners := make(map[string]string)
+	for i, group := range groups {
+		if group.Name == "" {
+			errs = append(errs, fmt.Errorf("invalid key group at index %d: name must be set", i))
+			continue
+		}
+		if len(group.Keys) == 0 {
+			errs = append(errs, fmt.Errorf("invalid key group %q: keys must be set", group.Name))
+		}
+		for j, key := range group.Keys {
+			if trim {
+				key = strings.TrimSpace(key)
+			}
+			if key == "" {
+				continue
+			}
+			if owner, ok := owners[key]; ok && owner != group.Name {
+				errs = append(errs, fmt.Errorf("invalid key group %q: key at index %d is also in key group %q", group.Name, j, owner))
+				continue
+			}
+			owners[key] = group.Name
+		}
+	}
+	return errs
+}
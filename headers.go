@@ -0,0 +1,112 @@
+package swissknife
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// reservedHeaders are the headers key entries may not set: hop-by-hop headers,
+// which are not forwarded as such, and headers describing the message itself.
+var reservedHeaders = map[string]struct{}{
+	"Connection":          {},
+	"Keep-Alive":          {},
+	"Proxy-Authenticate":  {},
+	"Proxy-Authorization": {},
+	"Proxy-Connection":    {},
+	"Te":                  {},
+	"Trailer":             {},
+	"Transfer-Encoding":   {},
+	"Upgrade":             {},
+	"Host":                {},
+	"Content-Length":      {},
+}
+
+// compileHeaders validates the headers of a key entry and returns them with
+// canonical names.
+func compileHeaders(headers map[string]string) (map[string]string, error) {
+	if len(headers) == 0 {
+		return nil, nil
+	}
+
+	compiled := make(map[string]string, len(headers))
+	for name, value := range headers {
+		if !validHeaderName(name) {
+			return nil, fmt.Errorf("invalid header name %q", name)
+		}
+		canonical := http.CanonicalHeaderKey(name)
+		if _, ok := reservedHeaders[canonical]; ok {
+			return nil, fmt.Errorf("header %s cannot be set", canonical)
+		}
+		if strings.ContainsAny(value, "\r\n\x00") {
+			return nil, fmt.Errorf("invalid value for header %s", canonical)
+		}
+		compiled[canonical] = value
+	}
+	return compiled, nil
+}
+
+// compileFailureHeaders returns the headers of error responses: Cache-Control
+// set to no-store, so that rejections are not cached, and headers, which may
+// replace it. The values are shared by every response, which is safe as
+// appending to them copies them.
+func compileFailureHeaders(headers map[string]string) (http.Header, error) {
+	compiled, err := compileHeaders(headers)
+	if err != nil {
+		return nil, fmt.Errorf("invalid failure response header: %w", err)
+	}
+
+	failureHeaders := http.Header{"Cache-Control": {"no-store"}}
+	for name, value := range compiled {
+		failureHeaders[name] = []string{value}
+	}
+	return failureHeaders, nil
+}
+
+// setFailureHeaders adds the failure headers to an error response, without
+// replacing the headers it already has, such as the Retry-After of a ban.
+func (ka *SwissKnife) setFailureHeaders(rw http.ResponseWriter) {
+	header := rw.Header()
+	for name, values := range ka.failureHeaders {
+		if _, ok := header[name]; !ok {
+			header[name] = values
+		}
+	}
+}
+
+func checkUpstreamToken(token string) error {
+	if strings.ContainsAny(token, "\r\n\x00") {
+		return errors.New("upstream token must not contain control characters")
+	}
+	return nil
+}
+
+// checkHeaderName is validHeaderName with an error telling what is wrong.
+func checkHeaderName(name string) error {
+	if name == "" {
+		return errors.New("header name is empty")
+	}
+	for _, r := range name {
+		if !validHeaderName(string(r)) {
+			return fmt.Errorf("%q contains %q, which is not allowed in header names", name, r)
+		}
+	}
+	return nil
+}
+
+// validHeaderName reports whether name is a token as defined by RFC 9110.
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case strings.ContainsRune("!#$%&'*+-.^_`|~", r):
+		default:
+			return false
+		}
+	}
+	return true
+}
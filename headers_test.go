@@ -0,0 +1,84 @@
+package swissknife
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBearerHeaderNameWithTrailingSpace(t *testing.T) {
+	config := CreateConfig()
+	config.Keys = []string{"secret-key-1"}
+	config.BearerHeader = true
+	config.BearerHeaderName = "authorization "
+
+	handler, err := New(context.Background(), http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}), config, "headers")
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer secret-key-1")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("status code = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestInvalidHeaderNames(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*Config)
+		want   string
+	}{
+		{name: "inner space", modify: func(c *Config) { c.AuthenticationHeaderName = "X API-KEY" }, want: `"X API-KEY" contains ' '`},
+		{name: "colon", modify: func(c *Config) { c.AuthenticationHeaderName = "X-API-KEY:" }, want: `"X-API-KEY:" contains ':'`},
+		{name: "non-token character", modify: func(c *Config) { c.ConsumerHeader = "X-Consumer(Name)" }, want: "invalid consumer header"},
+		{name: "only whitespace", modify: func(c *Config) { c.AuthenticationHeaderName = "  " }, want: "header name must be set"},
+		{name: "bearer", modify: func(c *Config) { c.BearerHeader = true; c.BearerHeaderName = "Author ization" }, want: "invalid bearer header name"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := CreateConfig()
+			config.Keys = []string{"secret-key-1"}
+			test.modify(config)
+			err := config.Validate()
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("Validate() error = %v, want %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestHeaderNamesAreTrimmedAndCanonicalized(t *testing.T) {
+	config := CreateConfig()
+	config.Keys = []string{"secret-key-1"}
+	config.AuthenticationHeaderName = " x-api-key\t"
+	config.ConsumerHeader = "x-consumer-name "
+	if err := config.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	if config.AuthenticationHeaderName != "X-Api-Key" {
+		t.Errorf("authentication header name = %q, want %q", config.AuthenticationHeaderName, "X-Api-Key")
+	}
+	if config.ConsumerHeader != "X-Consumer-Name" {
+		t.Errorf("consumer header = %q, want %q", config.ConsumerHeader, "X-Consumer-Name")
+	}
+}
+
+func TestShadowedHeaders(t *testing.T) {
+	config := CreateConfig()
+	config.BearerHeader = true
+	config.AuthenticationHeaderName = "authorization"
+	if got := config.shadowedHeaders(); len(got) != 1 {
+		t.Errorf("shadowedHeaders() = %q, want one warning", got)
+	}
+
+	config.BearerHeader = false
+	if got := config.shadowedHeaders(); len(got) != 0 {
+		t.Errorf("shadowedHeaders() with bearer disabled = %q, want none", got)
+	}
+}
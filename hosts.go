@@ -0,0 +1,66 @@
+package swissknife
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// compileHostPatterns validates host patterns and returns them in lower case.
+// A pattern is a host name or IP address, or a host name starting with "*."
+// that matches any single label in place of the star.
+func compileHostPatterns(patterns []string) ([]string, error) {
+	compiled := make([]string, 0, len(patterns))
+	for i, pattern := range patterns {
+		p, err := compileHostPattern(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid host pattern at index %d %q: %w", i, pattern, err)
+		}
+		compiled = append(compiled, p)
+	}
+	return compiled, nil
+}
+
+func compileHostPattern(pattern string) (string, error) {
+	p := strings.ToLower(strings.TrimSuffix(strings.TrimSpace(pattern), "."))
+	p = strings.TrimSuffix(strings.TrimPrefix(p, "["), "]")
+	if p == "" {
+		return "", errors.New("pattern must not be empty")
+	}
+	if strings.Contains(strings.TrimPrefix(p, "*."), "*") {
+		return "", errors.New("only a leading *. wildcard is supported")
+	}
+	if strings.HasPrefix(p, "*.") && len(p) == 2 {
+		return "", errors.New("wildcard must be followed by a domain")
+	}
+	return p, nil
+}
+
+// requestHost returns the host of a Host header in lower case, without its
+// port, trailing dot or the brackets of an IPv6 literal.
+func requestHost(host string) string {
+	if strings.HasPrefix(host, "[") {
+		if end := strings.IndexByte(host, ']'); end > 0 {
+			host = host[1:end]
+		}
+	} else if strings.Count(host, ":") == 1 {
+		host = host[:strings.IndexByte(host, ':')]
+	}
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+func matchAnyHost(patterns []string, host string) bool {
+	for _, pattern := range patterns {
+		if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+			label, found := strings.CutSuffix(host, suffix)
+			if found && label != "" && !strings.Contains(label, ".") {
+				return true
+			}
+			continue
+		}
+		if pattern == host {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,174 @@
+package swissknife
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// errJWKSUnavailable means a token could not be verified because the key set
+// could not be fetched.
+var errJWKSUnavailable = errors.New("JWKS unavailable")
+
+const (
+	jwksFetchTimeout = 5 * time.Second
+	jwksMaxSize      = 1 << 20
+)
+
+// jwksCache holds the RSA keys published at a JWKS URL. The key set is
+// fetched again once it is older than refreshInterval, or when a token names
+// an unknown key, but never more often than minRefreshInterval so that
+// tokens with made-up key IDs cannot be used to flood the endpoint. When a
+// fetch fails, the last key set keeps being used until it is maxAge old.
+type jwksCache struct {
+	url                string
+	client             *http.Client
+	refreshInterval    time.Duration
+	minRefreshInterval time.Duration
+	maxAge             time.Duration
+
+	mu          sync.Mutex
+	keys        map[string]*rsa.PublicKey
+	fetchedAt   time.Time
+	attemptedAt time.Time
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+func newJWKSCache(config JWT) (*jwksCache, error) {
+	if config.JwksURL == "" {
+		return nil, nil
+	}
+	if _, err := url.ParseRequestURI(config.JwksURL); err != nil {
+		return nil, fmt.Errorf("invalid JWKS URL: %w", err)
+	}
+
+	refreshInterval, err := time.ParseDuration(config.JwksRefreshInterval)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWKS refresh interval: %w", err)
+	}
+	minRefreshInterval, err := time.ParseDuration(config.JwksMinRefreshInterval)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWKS min refresh interval: %w", err)
+	}
+	maxAge, err := time.ParseDuration(config.JwksMaxAge)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWKS max age: %w", err)
+	}
+	if refreshInterval <= 0 || minRefreshInterval <= 0 || maxAge < refreshInterval {
+		return nil, errors.New("JWKS intervals must be positive, with a max age of at least the refresh interval")
+	}
+
+	return &jwksCache{
+		url:                config.JwksURL,
+		client:             newHTTPClient(jwksFetchTimeout),
+		refreshInterval:    refreshInterval,
+		minRefreshInterval: minRefreshInterval,
+		maxAge:             maxAge,
+	}, nil
+}
+
+// key returns the key with ID kid, fetching the key set if needed.
+func (jc *jwksCache) key(ctx context.Context, kid string, now time.Time) (*rsa.PublicKey, error) {
+	jc.mu.Lock()
+	key, known := jc.keys[kid]
+	stale := now.Sub(jc.fetchedAt) >= jc.refreshInterval
+	fetch := (stale || !known) && now.Sub(jc.attemptedAt) >= jc.minRefreshInterval
+	if fetch {
+		jc.attemptedAt = now
+	}
+	jc.mu.Unlock()
+
+	if fetch {
+		keys, err := jc.fetch(ctx)
+		jc.mu.Lock()
+		if err == nil {
+			jc.keys = keys
+			jc.fetchedAt = now
+		}
+		key, known = jc.keys[kid]
+		jc.mu.Unlock()
+		if err != nil && !known {
+			return nil, fmt.Errorf("%w: %s", errJWKSUnavailable, err.Error())
+		}
+	}
+
+	jc.mu.Lock()
+	expired := now.Sub(jc.fetchedAt) >= jc.maxAge
+	jc.mu.Unlock()
+	if expired {
+		return nil, fmt.Errorf("%w: key set expired", errJWKSUnavailable)
+	}
+	if !known {
+		return nil, fmt.Errorf("unknown key ID %q", kid)
+	}
+	return key, nil
+}
+
+func (jc *jwksCache) fetch(ctx context.Context) (map[string]*rsa.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, jc.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := jc.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("JWKS endpoint returned status %d", resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, jwksMaxSize)).Decode(&set); err != nil {
+		return nil, fmt.Errorf("decoding JWKS: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Kty != "RSA" || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+		key, err := jwk.rsaPublicKey()
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %q: %w", jwk.Kid, err)
+		}
+		keys[jwk.Kid] = key
+	}
+	return keys, nil
+}
+
+func (jwk jsonWebKey) rsaPublicKey() (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+	if err != nil {
+		return nil, errors.New("malformed modulus")
+	}
+	e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+	if err != nil || len(e) == 0 || len(e) > 4 {
+		return nil, errors.New("malformed exponent")
+	}
+
+	exponent := 0
+	for _, b := range e {
+		exponent = exponent<<8 | int(b)
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exponent}, nil
+}
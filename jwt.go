@@ -0,0 +1,242 @@
+package swissknife
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+//nolint:all
+type JWT struct {
+	HS256Secret            string   `json:"hs256Secret,omitempty"`
+	Issuer                 string   `json:"issuer,omitempty"`
+	Audiences              []string `json:"audiences,omitempty"`
+	RequiredScopes         []string `json:"requiredScopes,omitempty"`
+	JwksURL                string   `json:"jwksUrl,omitempty"`
+	JwksRefreshInterval    string   `json:"jwksRefreshInterval,omitempty"`
+	JwksMinRefreshInterval string   `json:"jwksMinRefreshInterval,omitempty"`
+	JwksMaxAge             string   `json:"jwksMaxAge,omitempty"`
+}
+
+// jwtVerifier verifies JSON Web Tokens signed with HS256, or with RS256 by a
+// key of the JWKS.
+type jwtVerifier struct {
+	secret         []byte
+	jwks           *jwksCache
+	issuer         string
+	audiences      []string
+	requiredScopes []string
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// jwtClaims holds the claims the plugin looks at.
+type jwtClaims struct {
+	Exp   *float64   `json:"exp"`
+	Nbf   *float64   `json:"nbf"`
+	Iss   string     `json:"iss"`
+	Sub   string     `json:"sub"`
+	Aud   stringList `json:"aud"`
+	Scope string     `json:"scope"`
+	Scp   stringList `json:"scp"`
+}
+
+// stringList is a claim that is either a single string or an array of
+// strings.
+type stringList []string
+
+func (l *stringList) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*l = stringList{s}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return errors.New("must be a string or an array of strings")
+	}
+	*l = list
+	return nil
+}
+
+func newJWTVerifier(config *Config) (*jwtVerifier, error) {
+	if config.JWT.HS256Secret == "" && config.JWT.JwksURL == "" {
+		return nil, nil
+	}
+	if !config.BearerHeader {
+		return nil, errors.New("bearer header must be true when a JWT secret or JWKS URL is set")
+	}
+
+	jwks, err := newJWKSCache(config.JWT)
+	if err != nil {
+		return nil, err
+	}
+
+	var secret []byte
+	if config.JWT.HS256Secret != "" {
+		secret = []byte(config.JWT.HS256Secret)
+	}
+	return &jwtVerifier{
+		secret:         secret,
+		jwks:           jwks,
+		issuer:         config.JWT.Issuer,
+		audiences:      config.JWT.Audiences,
+		requiredScopes: config.JWT.RequiredScopes,
+	}, nil
+}
+
+// looksLikeJWT reports whether token has the shape of a JWT: three
+// base64url-encoded segments, the first of which is a JSON object. Other
+// tokens are treated as opaque keys.
+func looksLikeJWT(token string) bool {
+	if strings.Count(token, ".") != 2 {
+		return false
+	}
+	header, err := base64.RawURLEncoding.DecodeString(token[:strings.IndexByte(token, '.')])
+	return err == nil && json.Valid(header) && strings.HasPrefix(string(header), "{")
+}
+
+// verify checks the signature, validity period, issuer and audience of token.
+func (jv *jwtVerifier) verify(ctx context.Context, token string, now time.Time) (*jwtClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed header: %w", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed signature")
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+
+	// Only the configured algorithms are accepted, never "none", and a
+	// public key is never used as an HMAC secret.
+	switch {
+	case header.Alg == "HS256" && jv.secret != nil:
+		mac := hmac.New(sha256.New, jv.secret)
+		_, _ = mac.Write(signed)
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return nil, errors.New("invalid signature")
+		}
+	case header.Alg == "RS256" && jv.jwks != nil:
+		key, err := jv.jwks.key(ctx, header.Kid, now)
+		if err != nil {
+			return nil, err
+		}
+		digest := sha256.Sum256(signed)
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+			return nil, errors.New("invalid signature")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q", header.Alg)
+	}
+
+	var claims jwtClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed claims: %w", err)
+	}
+	unix := float64(now.Unix())
+	if claims.Exp != nil && unix >= *claims.Exp {
+		return nil, errors.New("token expired")
+	}
+	if claims.Nbf != nil && unix < *claims.Nbf {
+		return nil, errors.New("token not valid yet")
+	}
+	if jv.issuer != "" && claims.Iss != jv.issuer {
+		return nil, fmt.Errorf("issuer %q not allowed", claims.Iss)
+	}
+	if len(jv.audiences) > 0 && !containsAny(claims.Aud, jv.audiences) {
+		return nil, errors.New("no allowed audience")
+	}
+	return &claims, nil
+}
+
+// missingScope returns a required scope missing from the "scope" (space
+// separated) and "scp" claims, if any.
+func (jv *jwtVerifier) missingScope(claims *jwtClaims) string {
+	granted := append(strings.Fields(claims.Scope), claims.Scp...)
+	for _, scope := range jv.requiredScopes {
+		if !containsAny(granted, []string{scope}) {
+			return scope
+		}
+	}
+	return ""
+}
+
+func containsAny(values, wanted []string) bool {
+	for _, v := range values {
+		for _, w := range wanted {
+			if v == w {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// authorizeJWT verifies the bearer credentials that look like JWTs, and
+// returns the first valid one with its claims. The other credentials are
+// returned in rest to be looked up as keys. err is the reason the first JWT
+// was invalid, if none was valid.
+func (ka *SwissKnife) authorizeJWT(ctx context.Context, credentials []credential) (matched *credential, claims *jwtClaims, rest []credential, err error) {
+	for i := range credentials {
+		c := &credentials[i]
+		if c.source != sourceBearer || !looksLikeJWT(c.value) {
+			rest = append(rest, *c)
+			continue
+		}
+		if matched != nil {
+			continue
+		}
+
+		verified, verr := ka.jwt.verify(ctx, c.value, ka.now())
+		if verr != nil {
+			if err == nil {
+				err = verr
+			}
+			continue
+		}
+		matched, claims = c, verified
+	}
+	if matched != nil {
+		err = nil
+	}
+	return matched, claims, rest, err
+}
+
+// responseInvalidToken rejects an invalid JWT. It is always a 401, so clients
+// know to get a new token.
+func (ka *SwissKnife) responseInvalidToken(rw http.ResponseWriter, req *http.Request) {
+	ka.delayFailure(req.Context())
+
+	rw.Header().Set("WWW-Authenticate", fmt.Sprintf(`%s realm=%q, error="invalid_token"`, ka.bearerSchemes[0], ka.realm))
+	ka.writeResponse(rw, req, Response{
+		Message:    messageInvalidToken,
+		StatusCode: http.StatusUnauthorized,
+	})
+}
@@ -0,0 +1,43 @@
+package swissknife
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+)
+
+// patternKeyStore accepts the keys matching any of its patterns, for keys
+// whose shape is enough to accept them.
+type patternKeyStore struct {
+	patterns []*regexp.Regexp
+}
+
+// compileKeyPatterns compiles the keyPatterns option. Patterns must match the
+// whole key.
+func compileKeyPatterns(patterns []string) (*patternKeyStore, error) {
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for i, pattern := range patterns {
+		if pattern == "" {
+			return nil, fmt.Errorf("invalid key pattern at index %d: pattern must not be empty", i)
+		}
+		// Compiled alone first so errors quote the configured pattern.
+		if _, err := regexp.Compile(pattern); err != nil {
+			return nil, fmt.Errorf("invalid key pattern at index %d: %w", i, err)
+		}
+		compiled = append(compiled, regexp.MustCompile(`^(?:`+pattern+`)$`))
+	}
+	return &patternKeyStore{patterns: compiled}, nil
+}
+
+func (s *patternKeyStore) Validate(_ context.Context, key string) (KeyInfo, bool, error) {
+	for _, pattern := range s.patterns {
+		if pattern.MatchString(key) {
+			return KeyInfo{}, true, nil
+		}
+	}
+	return KeyInfo{}, false, nil
+}
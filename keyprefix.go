@@ -0,0 +1,74 @@
+package swissknife
+
+import (
+	"fmt"
+	"strings"
+)
+
+// checkKeyPrefixes checks that the plaintext keys of entries start with one
+// of the allowed prefixes, to catch keys pasted into the wrong place.
+// Hashed keys cannot be checked.
+func (ka *SwissKnife) checkKeyPrefixes(entries []KeyEntry, origin string) error {
+	if len(ka.keyPrefixes) == 0 || ka.hashedKeys {
+		return nil
+	}
+
+	for i, entry := range entries {
+		if entry.Key == "" || strings.HasPrefix(entry.Key, sha256KeyPrefix) || strings.HasPrefix(entry.Key, bcryptKeyPrefix) {
+			continue
+		}
+		if _, ok := ka.keyPrefix(entry.Key); !ok {
+			return fmt.Errorf("invalid %s at index %d: key must start with one of the key prefixes", origin, i)
+		}
+	}
+	return nil
+}
+
+// prefixSeparators are trimmed from the prefixes sent to the upstream.
+const prefixSeparators = "_-."
+
+func validateKeyPrefixes(prefixes []string) error {
+	for i, prefix := range prefixes {
+		if strings.TrimRight(prefix, prefixSeparators) == "" {
+			return fmt.Errorf("invalid key prefix at index %d: prefix must not be empty", i)
+		}
+	}
+	return nil
+}
+
+// keyPrefix returns the longest allowed prefix key starts with.
+func (ka *SwissKnife) keyPrefix(key string) (string, bool) {
+	longest, found := "", false
+	for _, prefix := range ka.keyPrefixes {
+		if strings.HasPrefix(key, prefix) && len(prefix) > len(longest) {
+			longest, found = prefix, true
+		}
+	}
+	return longest, found
+}
+
+// withAllowedPrefix returns the credentials starting with an allowed prefix.
+// The others cannot be valid, so they are not looked up.
+func (ka *SwissKnife) withAllowedPrefix(credentials []credential) []credential {
+	if len(ka.keyPrefixes) == 0 {
+		return credentials
+	}
+
+	var allowed []credential
+	for _, c := range credentials {
+		if _, ok := ka.keyPrefix(c.value); ok {
+			allowed = append(allowed, c)
+		}
+	}
+	return allowed
+}
+
+// keyEnvironment returns the value of the prefix header for key: its prefix
+// without the trailing separators.
+func (ka *SwissKnife) keyEnvironment(key string) string {
+	prefix, ok := ka.keyPrefix(key)
+	if !ok {
+		return ""
+	}
+	return strings.TrimRight(prefix, prefixSeparators)
+}
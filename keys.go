@@ -0,0 +1,517 @@
+package swissknife
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	sha256KeyPrefix = "sha256:"
+	bcryptKeyPrefix = "bcrypt:"
+
+	// bcryptMaxPasswordLength is the number of bytes bcrypt takes into
+	// account; longer keys cannot have produced a configured hash.
+	bcryptMaxPasswordLength = 72
+)
+
+type keyEntry struct {
+	name               string
+	paths              []pathPattern
+	methods            map[string]struct{}
+	expiresAt          time.Time
+	deprecated         bool
+	deprecatedAfter    time.Time
+	cidrs              []netip.Prefix
+	hosts              []string
+	origins            []originPattern
+	allowMissingOrigin bool
+	windows            []accessWindow
+	quota              *quotaPolicy
+	requireTLS         bool
+	headers            map[string]string
+	upstreamToken      string
+	digest             [sha256.Size]byte
+	bcryptHash         []byte
+}
+
+type keySet struct {
+	digestEntries []*keyEntry
+	bcryptEntries []*keyEntry
+
+	// headerNames are the names of the headers set by any entry, which are
+	// removed from every incoming request.
+	headerNames map[string]struct{}
+
+	// revokedDigests are the digests of the revoked keys, which are rejected
+	// whether or not they are allowed.
+	revokedDigests [][sha256.Size]byte
+
+	// names maps the names of the entries to the first entry with that name.
+	names map[string]*keyEntry
+}
+
+func stringKeyEntries(keys []string) []KeyEntry {
+	entries := make([]KeyEntry, 0, len(keys))
+	for _, key := range keys {
+		entries = append(entries, KeyEntry{Key: key})
+	}
+	return entries
+}
+
+// add adds entries to the key set. origin describes where the entries were
+// configured, so errors can point at the offending one without echoing it.
+func (ks *keySet) add(entries []KeyEntry, origin string, hashed bool, maxBcryptCost int) error {
+	for i, entry := range entries {
+		if strings.TrimSpace(entry.Key) == "" {
+			return fmt.Errorf("invalid %s at index %d: key must not be empty", origin, i)
+		}
+
+		paths, err := compilePathPatterns(entry.Paths)
+		if err != nil {
+			return fmt.Errorf("invalid %s at index %d: %w", origin, i, err)
+		}
+		methods, err := methodSet(entry.Methods)
+		if err != nil {
+			return fmt.Errorf("invalid %s at index %d: %w", origin, i, err)
+		}
+		cidrs, err := parsePrefixes(entry.AllowedCIDRs)
+		if err != nil {
+			return fmt.Errorf("invalid %s at index %d: %w", origin, i, err)
+		}
+		hosts, err := compileHostPatterns(entry.Hosts)
+		if err != nil {
+			return fmt.Errorf("invalid %s at index %d: %w", origin, i, err)
+		}
+		origins, err := compileOriginPatterns(entry.AllowedOrigins)
+		if err != nil {
+			return fmt.Errorf("invalid %s at index %d: %w", origin, i, err)
+		}
+		windows, err := compileAccessWindows(entry.AccessWindows)
+		if err != nil {
+			return fmt.Errorf("invalid %s at index %d: %w", origin, i, err)
+		}
+		quota, err := compileQuota(entry.Quota)
+		if err != nil {
+			return fmt.Errorf("invalid %s at index %d: %w", origin, i, err)
+		}
+		headers, err := compileHeaders(entry.Headers)
+		if err != nil {
+			return fmt.Errorf("invalid %s at index %d: %w", origin, i, err)
+		}
+		for name := range headers {
+			if ks.headerNames == nil {
+				ks.headerNames = make(map[string]struct{})
+			}
+			ks.headerNames[name] = struct{}{}
+		}
+		compiled := &keyEntry{name: entry.Name, paths: paths, methods: methods, cidrs: cidrs, hosts: hosts, origins: origins, allowMissingOrigin: entry.AllowMissingOrigin, windows: windows, quota: quota, requireTLS: entry.RequireTLS, headers: headers}
+		if entry.UpstreamToken != "" {
+			if err := checkUpstreamToken(entry.UpstreamToken); err != nil {
+				return fmt.Errorf("invalid %s at index %d: %w", origin, i, err)
+			}
+			compiled.upstreamToken = entry.UpstreamToken
+		}
+		if entry.Name != "" {
+			if ks.names == nil {
+				ks.names = make(map[string]*keyEntry)
+			}
+			if _, ok := ks.names[entry.Name]; !ok {
+				ks.names[entry.Name] = compiled
+			}
+		}
+		if entry.ExpiresAt != "" {
+			compiled.expiresAt, err = time.Parse(time.RFC3339, entry.ExpiresAt)
+			if err != nil {
+				return fmt.Errorf("invalid %s at index %d: invalid expiry: %w", origin, i, err)
+			}
+		}
+		compiled.deprecated = entry.Deprecated
+		if entry.DeprecatedAfter != "" {
+			compiled.deprecatedAfter, err = time.Parse(time.RFC3339, entry.DeprecatedAfter)
+			if err != nil {
+				return fmt.Errorf("invalid %s at index %d: invalid deprecation time: %w", origin, i, err)
+			}
+		}
+
+		if hash, ok := strings.CutPrefix(entry.Key, bcryptKeyPrefix); ok {
+			if err := checkBcryptHash(hash, maxBcryptCost); err != nil {
+				return fmt.Errorf("invalid %s at index %d: %w", origin, i, err)
+			}
+			compiled.bcryptHash = []byte(hash)
+			ks.bcryptEntries = append(ks.bcryptEntries, compiled)
+			continue
+		}
+
+		compiled.digest, err = keyDigest(entry.Key, hashed)
+		if err != nil {
+			return fmt.Errorf("invalid %s at index %d: %w", origin, i, err)
+		}
+		ks.digestEntries = append(ks.digestEntries, compiled)
+	}
+	return nil
+}
+
+// revoke adds keys, plaintext or SHA-256 digests like allowed keys, to the
+// revoked keys.
+func (ks *keySet) revoke(keys []string, origin string, hashed bool) error {
+	for i, key := range keys {
+		if strings.TrimSpace(key) == "" {
+			return fmt.Errorf("invalid %s at index %d: key must not be empty", origin, i)
+		}
+		if strings.HasPrefix(key, bcryptKeyPrefix) {
+			return fmt.Errorf("invalid %s at index %d: bcrypt hashes cannot be revoked, use the key or its sha256 digest", origin, i)
+		}
+		digest, err := keyDigest(key, hashed)
+		if err != nil {
+			return fmt.Errorf("invalid %s at index %d: %w", origin, i, err)
+		}
+		ks.revokedDigests = append(ks.revokedDigests, digest)
+	}
+	return nil
+}
+
+// revoked reports whether key is revoked, comparing its digest against every
+// revoked digest like lookup.
+func (ks *keySet) revoked(key string) bool {
+	digest := sha256.Sum256([]byte(key))
+	match := 0
+	for _, revoked := range ks.revokedDigests {
+		match |= subtle.ConstantTimeCompare(digest[:], revoked[:])
+	}
+	return match == 1
+}
+
+// revokedAndAllowed returns the number of revoked keys that are also allowed.
+// Revoked keys matching a bcrypt entry are not counted.
+func (ks *keySet) revokedAndAllowed() int {
+	n := 0
+	for _, revoked := range ks.revokedDigests {
+		for _, entry := range ks.digestEntries {
+			if entry.digest == revoked {
+				n++
+				break
+			}
+		}
+	}
+	return n
+}
+
+func (ks *keySet) len() int {
+	return len(ks.digestEntries) + len(ks.bcryptEntries)
+}
+
+// keyDigest returns the SHA-256 digest a presented key is compared against.
+// Entries prefixed with "sha256:", or every entry when hashed is true, are
+// hex-encoded digests; anything else is a plaintext key. Both forms may be
+// mixed in one list.
+func keyDigest(key string, hashed bool) ([sha256.Size]byte, error) {
+	var digest [sha256.Size]byte
+
+	encoded, prefixed := strings.CutPrefix(key, sha256KeyPrefix)
+	if !prefixed && !hashed {
+		return sha256.Sum256([]byte(key)), nil
+	}
+
+	if len(encoded) != hex.EncodedLen(sha256.Size) {
+		return digest, fmt.Errorf("sha256 digest must be %d hex characters", hex.EncodedLen(sha256.Size))
+	}
+	if _, err := hex.Decode(digest[:], []byte(encoded)); err != nil {
+		return digest, errors.New("sha256 digest must be hex encoded")
+	}
+	return digest, nil
+}
+
+func checkBcryptHash(hash string, maxCost int) error {
+	cost, err := bcrypt.Cost([]byte(hash))
+	if err != nil {
+		return fmt.Errorf("invalid bcrypt hash: %w", err)
+	}
+	if cost > maxCost {
+		return fmt.Errorf("bcrypt cost %d exceeds the maximum of %d", cost, maxCost)
+	}
+	return nil
+}
+
+// lookup compares the digest of key against every valid key digest without
+// short-circuiting, so the time taken does not depend on which key (or how
+// much of it) matched. bcrypt hashes are only tried when no digest matched.
+func (ks *keySet) lookup(key string) (*keyEntry, bool) {
+	digest := sha256.Sum256([]byte(key))
+	match := -1
+	for i, entry := range ks.digestEntries {
+		match = subtle.ConstantTimeSelect(subtle.ConstantTimeCompare(digest[:], entry.digest[:]), i, match)
+	}
+	if match >= 0 {
+		return ks.digestEntries[match], true
+	}
+
+	if len(key) > bcryptMaxPasswordLength {
+		return nil, false
+	}
+	for _, entry := range ks.bcryptEntries {
+		if bcrypt.CompareHashAndPassword(entry.bcryptHash, []byte(key)) == nil {
+			return entry, true
+		}
+	}
+	return nil, false
+}
+
+// named returns the entry named name.
+func (ks *keySet) named(name string) (*keyEntry, bool) {
+	entry, ok := ks.names[name]
+	return entry, ok
+}
+
+// trimKeyEntries returns a copy of entries with whitespace, such as a newline
+// left by the tool that wrote the secret, trimmed from both ends of the keys.
+func trimKeyEntries(entries []KeyEntry) []KeyEntry {
+	trimmed := make([]KeyEntry, len(entries))
+	for i, entry := range entries {
+		entry.Key = strings.TrimSpace(entry.Key)
+		trimmed[i] = entry
+	}
+	return trimmed
+}
+
+// trimmed returns keys trimmed like trimKeyEntries with trimKeys, and keys
+// otherwise.
+func (ka *SwissKnife) trimmed(keys []string) []string {
+	if !ka.trimKeys {
+		return keys
+	}
+
+	trimmed := make([]string, len(keys))
+	for i, key := range keys {
+		trimmed[i] = strings.TrimSpace(key)
+	}
+	return trimmed
+}
+
+// splitKeysCSV returns the comma-separated keys in csv, trimmed of the
+// whitespace around them. "\," is a literal comma. Empty keys are skipped.
+func splitKeysCSV(csv string) []string {
+	var keys []string
+	var key strings.Builder
+	for i := 0; i <= len(csv); i++ {
+		if i < len(csv) && csv[i] == '\\' && i+1 < len(csv) && csv[i+1] == ',' {
+			key.WriteByte(',')
+			i++
+			continue
+		}
+		if i < len(csv) && csv[i] != ',' {
+			key.WriteByte(csv[i])
+			continue
+		}
+		if trimmed := strings.TrimSpace(key.String()); trimmed != "" {
+			keys = append(keys, trimmed)
+		}
+		key.Reset()
+	}
+	return keys
+}
+
+// mergeKeys appends to keys the extra keys it does not already contain.
+func mergeKeys(keys, extra []string) []string {
+	seen := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		seen[key] = struct{}{}
+	}
+	for _, key := range extra {
+		if _, ok := seen[key]; !ok {
+			seen[key] = struct{}{}
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// readKeysFile returns the newline-separated keys in path, skipping blank
+// lines and lines starting with "#".
+func readKeysFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		keys = append(keys, line)
+	}
+	return keys, nil
+}
+
+// readKeysDir returns a key entry for every regular file in dir, named after
+// the file, with the content of the file as key. A single trailing newline is
+// removed. Hidden files, such as the ..data link of a mounted Kubernetes
+// secret, and directories are skipped.
+func readKeysDir(dir string) ([]KeyEntry, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []KeyEntry
+	for _, file := range files {
+		if strings.HasPrefix(file.Name(), ".") {
+			continue
+		}
+		// Secret files are usually symbolic links, which are followed.
+		path := filepath.Join(dir, file.Name())
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		key := strings.TrimSuffix(string(data), "\n")
+		key = strings.TrimSuffix(key, "\r")
+		if key == "" {
+			return nil, fmt.Errorf("key file %q is empty", file.Name())
+		}
+		entries = append(entries, KeyEntry{Name: file.Name(), Key: key})
+	}
+	return entries, nil
+}
+
+// addKeys adds entries to keys once their strength has been checked.
+func (ka *SwissKnife) addKeys(keys *keySet, entries []KeyEntry, origin string) error {
+	if ka.trimKeys {
+		entries = trimKeyEntries(entries)
+	}
+	if err := ka.checkKeyStrength(entries, origin); err != nil {
+		return err
+	}
+	if err := ka.checkKeyPrefixes(entries, origin); err != nil {
+		return err
+	}
+	if ka.maxCredentialLength > 0 && !ka.hashedKeys {
+		for i, entry := range entries {
+			if len(entry.Key) > ka.maxCredentialLength && !strings.HasPrefix(entry.Key, sha256KeyPrefix) && !strings.HasPrefix(entry.Key, bcryptKeyPrefix) {
+				return fmt.Errorf("invalid %s at index %d: key is longer than the max credential length", origin, i)
+			}
+		}
+	}
+	return keys.add(entries, origin, ka.hashedKeys, ka.maxBcryptCost)
+}
+
+func (ka *SwissKnife) loadKeys() (*keySet, error) {
+	keys := &keySet{}
+	if err := ka.addKeys(keys, stringKeyEntries(ka.staticKeys), "key"); err != nil {
+		return nil, err
+	}
+	if err := ka.addKeys(keys, ka.keyEntries, "key entry"); err != nil {
+		return nil, err
+	}
+	for _, group := range ka.keyGroups {
+		if err := ka.addKeys(keys, group.entries(), fmt.Sprintf("key of key group %q", group.Name)); err != nil {
+			return nil, err
+		}
+	}
+
+	if ka.keysFile != "" {
+		fileKeys, err := readKeysFile(ka.keysFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading keys file: %w", err)
+		}
+		if err := ka.addKeys(keys, stringKeyEntries(fileKeys), "key in keys file"); err != nil {
+			return nil, err
+		}
+	}
+
+	if ka.keysDir != "" {
+		dirEntries, err := readKeysDir(ka.keysDir)
+		if err != nil {
+			return nil, fmt.Errorf("reading keys directory: %w", err)
+		}
+		if err := ka.addKeys(keys, dirEntries, "key in keys directory"); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := keys.revoke(ka.trimmed(ka.revokedKeys), "revoked key", ka.hashedKeys); err != nil {
+		return nil, err
+	}
+	if ka.revokedKeysFile != "" {
+		revoked, err := readKeysFile(ka.revokedKeysFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading revoked keys file: %w", err)
+		}
+		if err := keys.revoke(revoked, "key in revoked keys file", ka.hashedKeys); err != nil {
+			return nil, err
+		}
+	}
+
+	if keys.len() == 0 && len(ka.stores) == 1 && ka.signatures == nil && ka.jwt == nil && ka.clientCerts == nil {
+		return nil, errors.New("must specify at least one valid key")
+	}
+	return keys, nil
+}
+
+// reloadKeys re-reads the keys files every interval until ctx is done. A failed
+// reload keeps the last good key set.
+func (ka *SwissKnife) reloadKeys(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			ka.keysMu.Lock()
+			keys, err := ka.loadKeys()
+			if err == nil {
+				ka.static.set(keys)
+			}
+			ka.keysMu.Unlock()
+
+			if err != nil {
+				ka.logger.error(nil, "", fmt.Sprintf("Error reloading keys, keeping previous keys: %s", err.Error()))
+				continue
+			}
+			ka.logger.info(nil, "", "Reloaded keys")
+		}
+	}
+}
+
+// UpdateKeys replaces the keys of the keys and keyEntries options with keys,
+// for programs embedding the plugin. The keys of keysFile and keysDir, and the
+// revoked keys, are read again. Requests being served keep using the previous
+// keys; if keys are invalid, an error is returned and they stay in use.
+//
+//nolint:all
+func (ka *SwissKnife) UpdateKeys(keys []KeyEntry) error {
+	ka.keysMu.Lock()
+	defer ka.keysMu.Unlock()
+
+	staticKeys, keyEntries := ka.staticKeys, ka.keyEntries
+	ka.staticKeys, ka.keyEntries = nil, append([]KeyEntry(nil), keys...)
+	set, err := ka.loadKeys()
+	if err != nil {
+		ka.staticKeys, ka.keyEntries = staticKeys, keyEntries
+		return err
+	}
+	ka.static.set(set)
+	return nil
+}
@@ -0,0 +1,440 @@
+package swissknife
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestUpdateKeysWhileServing(t *testing.T) {
+	config := CreateConfig()
+	config.KeyEntries = []KeyEntry{{Name: "first", Key: "secret-key-1"}}
+	config.RemoveHeadersOnSuccess = false
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		info, _ := FromContext(req.Context())
+		rw.Header().Set("X-Test-Consumer", info.KeyName)
+	})
+	ka := newTestHandler(t, config, next)
+
+	sets := [][]KeyEntry{
+		{{Name: "first", Key: "secret-key-1"}},
+		{{Name: "second", Key: "secret-key-1"}, {Name: "other", Key: "secret-key-2"}},
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				req := httptest.NewRequest(http.MethodGet, "/", nil)
+				req.Header.Set("X-API-KEY", "secret-key-1")
+				rec := httptest.NewRecorder()
+				ka.ServeHTTP(rec, req)
+				if rec.Code != http.StatusOK {
+					t.Errorf("status code = %d, want %d", rec.Code, http.StatusOK)
+					return
+				}
+				if consumer := rec.Header().Get("X-Test-Consumer"); consumer != "first" && consumer != "second" {
+					t.Errorf("consumer = %q, want first or second", consumer)
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 200; i++ {
+		if err := ka.UpdateKeys(sets[i%len(sets)]); err != nil {
+			t.Errorf("UpdateKeys() error = %v", err)
+			break
+		}
+	}
+	close(done)
+	wg.Wait()
+}
+
+func TestUpdateKeysInvalidKeepsPrevious(t *testing.T) {
+	config := CreateConfig()
+	config.Keys = []string{"secret-key-1"}
+	ka := newTestHandler(t, config, nil)
+
+	if err := ka.UpdateKeys([]KeyEntry{{Name: "empty"}}); err == nil {
+		t.Fatal("UpdateKeys() error = nil, want error")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-API-KEY", "secret-key-1")
+	rec := httptest.NewRecorder()
+	ka.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("status code = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestEmptyKeysRejected(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*Config)
+	}{
+		{name: "empty key", modify: func(c *Config) { c.Keys = []string{""} }},
+		{name: "whitespace key", modify: func(c *Config) { c.Keys = []string{"  "} }},
+		{name: "empty key among others", modify: func(c *Config) { c.Keys = []string{"secret-key-1", ""} }},
+		{name: "empty key entry", modify: func(c *Config) { c.KeyEntries = []KeyEntry{{Name: "billing"}} }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := CreateConfig()
+			test.modify(config)
+			_, err := New(context.Background(), noopHandler, config, "test")
+			if err == nil || !strings.Contains(err.Error(), "key must not be empty") {
+				t.Errorf("New() error = %v, want empty key error", err)
+			}
+		})
+	}
+}
+
+func TestRequestWithoutKeyRejected(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*Config)
+		values []string
+	}{
+		{name: "default"},
+		{name: "empty header", values: []string{""}},
+		{name: "blank header with trimmed keys", modify: func(c *Config) { c.TrimKeys = true }, values: []string{"  "}},
+		{name: "bearer", modify: func(c *Config) { c.BearerHeader = true }},
+		{name: "query", modify: func(c *Config) { c.QueryParam = true }},
+		{name: "trimmed keys", modify: func(c *Config) { c.TrimKeys = true }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := CreateConfig()
+			config.Keys = []string{"secret-key-1"}
+			if test.modify != nil {
+				test.modify(config)
+			}
+			ka := newTestHandler(t, config, nil)
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header = http.Header{}
+			for _, value := range test.values {
+				req.Header.Add("X-API-KEY", value)
+			}
+			rec := httptest.NewRecorder()
+			ka.ServeHTTP(rec, req)
+			if rec.Code != http.StatusForbidden {
+				t.Errorf("status code = %d, want %d", rec.Code, http.StatusForbidden)
+			}
+		})
+	}
+}
+
+func sha256Hex(key string) string {
+	digest := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(digest[:])
+}
+
+func TestHashedKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		keys    []string
+		hashed  bool
+		wantErr string
+		allowed []string
+		denied  []string
+	}{
+		{
+			name:    "prefixed digest",
+			keys:    []string{"sha256:" + sha256Hex("secret-key-1")},
+			allowed: []string{"secret-key-1"},
+			denied:  []string{"secret-key-2", sha256Hex("secret-key-1"), "sha256:" + sha256Hex("secret-key-1")},
+		},
+		{
+			name:    "hashedKeys option",
+			keys:    []string{sha256Hex("secret-key-1")},
+			hashed:  true,
+			allowed: []string{"secret-key-1"},
+			denied:  []string{sha256Hex("secret-key-1")},
+		},
+		{
+			name:    "uppercase digest",
+			keys:    []string{strings.ToUpper(sha256Hex("secret-key-1"))},
+			hashed:  true,
+			allowed: []string{"secret-key-1"},
+		},
+		{
+			name:    "mixed plaintext and prefixed digest",
+			keys:    []string{"secret-key-1", "sha256:" + sha256Hex("secret-key-2")},
+			allowed: []string{"secret-key-1", "secret-key-2"},
+			denied:  []string{"sha256:" + sha256Hex("secret-key-2")},
+		},
+		{name: "plaintext with hashedKeys", keys: []string{"secret-key-1"}, hashed: true, wantErr: "sha256 digest must be 64 hex characters"},
+		{name: "short digest", keys: []string{"sha256:abcdef"}, wantErr: "sha256 digest must be 64 hex characters"},
+		{name: "not hex", keys: []string{"sha256:" + strings.Repeat("z", 64)}, wantErr: "sha256 digest must be hex encoded"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := CreateConfig()
+			config.Keys = test.keys
+			config.HashedKeys = test.hashed
+			if test.wantErr != "" {
+				_, err := New(context.Background(), noopHandler, config, "test")
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("New() error = %v, want %q", err, test.wantErr)
+				}
+				if err != nil && strings.Contains(err.Error(), "secret-key") {
+					t.Errorf("New() error = %v, must not contain the key", err)
+				}
+				return
+			}
+
+			ka := newTestHandler(t, config, nil)
+			for _, key := range test.allowed {
+				if code := statusFor(ka, key); code != http.StatusOK {
+					t.Errorf("status code for %q = %d, want %d", key, code, http.StatusOK)
+				}
+			}
+			for _, key := range test.denied {
+				if code := statusFor(ka, key); code != http.StatusForbidden {
+					t.Errorf("status code for %q = %d, want %d", key, code, http.StatusForbidden)
+				}
+			}
+		})
+	}
+}
+
+func bcryptKey(t *testing.T, key string, cost int) string {
+	t.Helper()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(key), cost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return "bcrypt:" + string(hash)
+}
+
+func TestBcryptKeys(t *testing.T) {
+	long := strings.Repeat("k", 72)
+
+	tests := []struct {
+		name    string
+		keys    []string
+		maxCost int
+		wantErr string
+		allowed []string
+		denied  []string
+	}{
+		{
+			name:    "mixed plaintext, sha256 and bcrypt",
+			keys:    []string{"secret-key-1", "sha256:" + sha256Hex("secret-key-2"), bcryptKey(t, "secret-key-3", bcrypt.MinCost)},
+			allowed: []string{"secret-key-1", "secret-key-2", "secret-key-3"},
+			denied:  []string{"secret-key-4", "secret-key-3 "},
+		},
+		{
+			name:    "longer than bcrypt takes into account",
+			keys:    []string{bcryptKey(t, long, bcrypt.MinCost)},
+			allowed: []string{long},
+			denied:  []string{long + "x"},
+		},
+		{name: "cost at the maximum", keys: []string{bcryptKey(t, "secret-key-1", bcrypt.MinCost+1)}, maxCost: bcrypt.MinCost + 1, allowed: []string{"secret-key-1"}},
+		{name: "cost above the maximum", keys: []string{bcryptKey(t, "secret-key-1", bcrypt.MinCost+1)}, maxCost: bcrypt.MinCost, wantErr: "bcrypt cost 5 exceeds the maximum of 4"},
+		{name: "invalid hash", keys: []string{"bcrypt:$2a$04$tooshort"}, wantErr: "invalid bcrypt hash"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := CreateConfig()
+			config.Keys = test.keys
+			if test.maxCost != 0 {
+				config.MaxBcryptCost = test.maxCost
+			}
+			if test.wantErr != "" {
+				_, err := New(context.Background(), noopHandler, config, "test")
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("New() error = %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+
+			ka := newTestHandler(t, config, nil)
+			for _, key := range test.allowed {
+				if code := statusFor(ka, key); code != http.StatusOK {
+					t.Errorf("status code for %q = %d, want %d", key, code, http.StatusOK)
+				}
+			}
+			for _, key := range test.denied {
+				if code := statusFor(ka, key); code != http.StatusForbidden {
+					t.Errorf("status code for %q = %d, want %d", key, code, http.StatusForbidden)
+				}
+			}
+		})
+	}
+}
+
+func TestTrimKeys(t *testing.T) {
+	tests := []struct {
+		name       string
+		trim       bool
+		configured string
+		presented  string
+		want       int
+	}{
+		{name: "configured with newline", trim: true, configured: "secret-key-1\n", presented: "secret-key-1", want: http.StatusOK},
+		{name: "configured with CRLF", trim: true, configured: "secret-key-1\r\n", presented: "secret-key-1", want: http.StatusOK},
+		{name: "configured with non-breaking spaces", trim: true, configured: "\u00a0secret-key-1\u00a0", presented: "secret-key-1", want: http.StatusOK},
+		{name: "presented with newline", trim: true, configured: "secret-key-1", presented: "secret-key-1\n", want: http.StatusOK},
+		{name: "presented with CRLF", trim: true, configured: "secret-key-1", presented: "secret-key-1\r\n", want: http.StatusOK},
+		{name: "presented with non-breaking space", trim: true, configured: "secret-key-1", presented: "secret-key-1\u00a0", want: http.StatusOK},
+		{name: "inner whitespace kept", trim: true, configured: "secret-key-1", presented: "secret- key-1", want: http.StatusForbidden},
+		{name: "configured with newline, not trimmed", configured: "secret-key-1\n", presented: "secret-key-1", want: http.StatusForbidden},
+		{name: "presented with CRLF, not trimmed", configured: "secret-key-1", presented: "secret-key-1\r\n", want: http.StatusForbidden},
+		{name: "presented with non-breaking space, not trimmed", configured: "secret-key-1", presented: "secret-key-1\u00a0", want: http.StatusForbidden},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := CreateConfig()
+			config.Keys = []string{test.configured}
+			config.TrimKeys = test.trim
+			ka := newTestHandler(t, config, nil)
+
+			// Set directly, as the header API would reject the control
+			// characters a client could still smuggle in.
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header["X-Api-Key"] = []string{test.presented}
+			if code := serveRecorded(ka, req).Code; code != test.want {
+				t.Errorf("status code = %d, want %d", code, test.want)
+			}
+		})
+	}
+}
+
+func TestTrimKeysRejectsBlankKeys(t *testing.T) {
+	for _, key := range []string{"\n", "\r\n", "\u00a0", " \t\u00a0\r\n"} {
+		config := CreateConfig()
+		config.Keys = []string{key}
+		config.TrimKeys = true
+		_, err := New(context.Background(), noopHandler, config, "test")
+		if err == nil || !strings.Contains(err.Error(), "key must not be empty") {
+			t.Errorf("New() with key %q error = %v, want empty key error", key, err)
+		}
+	}
+}
+
+func TestSplitKeysCSV(t *testing.T) {
+	tests := []struct {
+		name string
+		csv  string
+		want []string
+	}{
+		{name: "empty", csv: "", want: nil},
+		{name: "single", csv: "abc", want: []string{"abc"}},
+		{name: "several", csv: "abc,def,ghi", want: []string{"abc", "def", "ghi"}},
+		{name: "whitespace trimmed", csv: " abc ,\tdef\t, ghi", want: []string{"abc", "def", "ghi"}},
+		{name: "inner whitespace kept", csv: "ab c,def", want: []string{"ab c", "def"}},
+		{name: "empty entries skipped", csv: ",abc,, ,def,", want: []string{"abc", "def"}},
+		{name: "escaped comma", csv: `ab\,c,def`, want: []string{"ab,c", "def"}},
+		{name: "escaped comma at the edges", csv: `\,abc\,`, want: []string{",abc,"}},
+		{name: "escaped comma only", csv: `\,`, want: []string{","}},
+		{name: "backslash kept", csv: `ab\c,d\\e`, want: []string{`ab\c`, `d\\e`}},
+		{name: "trailing backslash kept", csv: `abc\`, want: []string{`abc\`}},
+		{name: "escaped backslash before comma", csv: `ab\\,c`, want: []string{`ab\,c`}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := splitKeysCSV(test.csv); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("splitKeysCSV(%q) = %q, want %q", test.csv, got, test.want)
+			}
+		})
+	}
+}
+
+func TestKeysCSV(t *testing.T) {
+	tests := []struct {
+		name     string
+		keys     []string
+		csv      string
+		accepted []string
+		rejected []string
+	}{
+		{name: "csv only", csv: "secret-key-1, secret-key-2", accepted: []string{"secret-key-1", "secret-key-2"}},
+		{name: "merged with keys", keys: []string{"secret-key-1"}, csv: "secret-key-2", accepted: []string{"secret-key-1", "secret-key-2"}},
+		{name: "escaped comma", csv: `secret\,key`, accepted: []string{"secret,key"}, rejected: []string{"secret", "key", `secret\,key`}},
+		{name: "duplicates across fields", keys: []string{"secret-key-1"}, csv: "secret-key-1,secret-key-2,secret-key-2", accepted: []string{"secret-key-1", "secret-key-2"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := CreateConfig()
+			config.Keys = test.keys
+			config.KeysCSV = test.csv
+			ka := newTestHandler(t, config, nil)
+			ka.SetLogger(&logRecorder{})
+
+			for _, key := range test.accepted {
+				if code := statusFor(ka, key); code != http.StatusOK {
+					t.Errorf("status code for %q = %d, want %d", key, code, http.StatusOK)
+				}
+			}
+			for _, key := range test.rejected {
+				if code := statusFor(ka, key); code != http.StatusForbidden {
+					t.Errorf("status code for %q = %d, want %d", key, code, http.StatusForbidden)
+				}
+			}
+		})
+	}
+}
+
+// TestKeysCSVDuplicatesSilent checks that keys repeated across keys and
+// keysCSV are not reported as duplicates.
+func TestKeysCSVDuplicatesSilent(t *testing.T) {
+	config := CreateConfig()
+	config.Keys = []string{"secret-key-1"}
+	config.KeysCSV = "secret-key-1, secret-key-1"
+
+	expanded, err := expandConfigEnv(config)
+	if err != nil {
+		t.Fatalf("expandConfigEnv() error = %v", err)
+	}
+	if want := []string{"secret-key-1"}; !reflect.DeepEqual(expanded.Keys, want) {
+		t.Errorf("keys = %q, want %q", expanded.Keys, want)
+	}
+	if duplicates := expanded.duplicateKeys(); len(duplicates) != 0 {
+		t.Errorf("duplicateKeys() = %q, want none", duplicates)
+	}
+}
+
+// BenchmarkKeySetLookup rejects keys sharing a prefix of increasing length
+// with a valid key. The time per lookup should not depend on that length.
+func BenchmarkKeySetLookup(b *testing.B) {
+	const key = "0123456789abcdef0123456789abcdef"
+	keys := &keySet{}
+	entries := []KeyEntry{{Key: key}, {Key: strings.Repeat("x", len(key))}}
+	if err := keys.add(entries, "key", false, CreateConfig().MaxBcryptCost); err != nil {
+		b.Fatal(err)
+	}
+
+	for _, prefix := range []int{0, 1, 8, 16, 31} {
+		wrong := key[:prefix] + strings.Repeat("-", len(key)-prefix)
+		b.Run(fmt.Sprintf("prefix=%d", prefix), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, ok := keys.lookup(wrong); ok {
+					b.Fatal("lookup() accepted a wrong key")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package swissknife
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// KeyStore validates the keys presented by clients. An error means the store
+// could not tell whether the key is valid, and the request is answered with
+// validationUnavailableStatusCode.
+type KeyStore interface {
+	Validate(ctx context.Context, key string) (KeyInfo, bool, error)
+}
+
+// KeyInfo describes a valid key.
+type KeyInfo struct {
+	// Name identifies the key, and is forwarded in the consumer header.
+	Name string
+
+	entry *keyEntry
+}
+
+// StaticKeyStore holds keys configured upfront. Its keys can be replaced as a
+// whole, as done when the keys file is reloaded.
+type StaticKeyStore struct {
+	mu   sync.RWMutex
+	keys *keySet
+}
+
+// NewStaticKeyStore returns a store holding entries, with the same syntax as
+// the keyEntries option.
+func NewStaticKeyStore(entries []KeyEntry) (*StaticKeyStore, error) {
+	keys := &keySet{}
+	if err := keys.add(entries, "key entry", false, CreateConfig().MaxBcryptCost); err != nil {
+		return nil, err
+	}
+	return &StaticKeyStore{keys: keys}, nil
+}
+
+func (s *StaticKeyStore) Validate(_ context.Context, key string) (KeyInfo, bool, error) {
+	entry, ok := s.current().lookup(key)
+	if !ok {
+		return KeyInfo{}, false, nil
+	}
+	return KeyInfo{Name: entry.name, entry: entry}, true, nil
+}
+
+func (s *StaticKeyStore) current() *keySet {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.keys
+}
+
+func (s *StaticKeyStore) set(keys *keySet) {
+	s.mu.Lock()
+	s.keys = keys
+	s.mu.Unlock()
+}
+
+// remoteKeyStore validates keys against the remote validation endpoint,
+// answering from the cache when it holds a result for the key.
+type remoteKeyStore struct {
+	validator *remoteValidator
+	cache     *validationCache
+	breaker   *circuitBreaker
+	logger    *logger
+}
+
+func (s *remoteKeyStore) Validate(ctx context.Context, key string) (KeyInfo, bool, error) {
+	valid, err := s.validate(ctx, key)
+	return KeyInfo{}, valid, err
+}
+
+func (s *remoteKeyStore) validate(ctx context.Context, key string) (bool, error) {
+	if s.cache == nil {
+		return s.call(ctx, key)
+	}
+
+	valid, ok := s.cache.get(key)
+	if s.logger.enabledFor(levelDebug) {
+		hits, misses := s.cache.stats()
+		s.logger.debug(nil, "", fmt.Sprintf("Validation cache hit: %t (hits: %d, misses: %d)", ok, hits, misses))
+	}
+	if ok {
+		return valid, nil
+	}
+
+	valid, err := s.call(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	s.cache.add(key, valid)
+	return valid, nil
+}
+
+// call calls the validation endpoint, through the circuit breaker if any.
+func (s *remoteKeyStore) call(ctx context.Context, key string) (bool, error) {
+	if s.breaker == nil {
+		return s.validator.validate(ctx, key)
+	}
+
+	if err := s.breaker.allow(); err != nil {
+		return false, err
+	}
+	valid, err := s.validator.validate(ctx, key)
+	if err != nil && ctx.Err() != nil {
+		// The client went away or ran out of time: the endpoint is not to
+		// blame.
+		s.breaker.release()
+		return valid, err
+	}
+	s.breaker.record(err)
+	return valid, err
+}
@@ -0,0 +1,155 @@
+package swissknife
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestInvalidKeyResponseGolden pins the 403 response to a wrong key, which
+// must stay the same whichever store holds the keys.
+func TestInvalidKeyResponseGolden(t *testing.T) {
+	golden, err := os.ReadFile("testdata/invalid_key.golden")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantHeader := http.Header{
+		"Cache-Control":  {"no-store"},
+		"Content-Length": {"47"},
+		"Content-Type":   {"application/json; charset=utf-8"},
+	}
+
+	tests := []struct {
+		name    string
+		handler func(t *testing.T) http.Handler
+	}{
+		{name: "keys", handler: func(t *testing.T) http.Handler {
+			config := CreateConfig()
+			config.Keys = []string{"secret-key-1"}
+			return newTestHandler(t, config, nil)
+		}},
+		{name: "key entries", handler: func(t *testing.T) http.Handler {
+			config := CreateConfig()
+			config.KeyEntries = []KeyEntry{{Name: "billing", Key: "secret-key-1"}}
+			return newTestHandler(t, config, nil)
+		}},
+		{name: "key store", handler: func(t *testing.T) http.Handler {
+			store, err := NewStaticKeyStore([]KeyEntry{{Name: "billing", Key: "secret-key-1"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			handler, err := NewWithKeyStores(context.Background(), noopHandler, CreateConfig(), "test", store)
+			if err != nil {
+				t.Fatalf("NewWithKeyStores() error = %v", err)
+			}
+			t.Cleanup(func() { _ = handler.(*SwissKnife).Close() })
+			return handler
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("X-API-KEY", "wrong-key")
+			rec := httptest.NewRecorder()
+			test.handler(t).ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusForbidden {
+				t.Errorf("status code = %d, want %d", rec.Code, http.StatusForbidden)
+			}
+			if !reflect.DeepEqual(rec.Header(), wantHeader) {
+				t.Errorf("headers = %v, want %v", rec.Header(), wantHeader)
+			}
+			if body := rec.Body.String(); body != string(golden) {
+				t.Errorf("body = %q, want %q", body, golden)
+			}
+		})
+	}
+}
+
+// newBreakerStore returns a remote key store for url behind a circuit breaker
+// opening after one error, and the clock of the breaker.
+func newBreakerStore(t *testing.T, url string) (*remoteKeyStore, *time.Time) {
+	t.Helper()
+
+	config := CreateConfig()
+	config.ValidationURL = url
+	config.CircuitBreakerThreshold = 1
+	config.CircuitBreakerCooldown = "1m"
+	logger, err := newLogger(config, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	validator, err := newRemoteValidator(config)
+	if err != nil {
+		t.Fatalf("newRemoteValidator() error = %v", err)
+	}
+	breaker, err := newCircuitBreakerFromConfig(config, logger)
+	if err != nil {
+		t.Fatalf("newCircuitBreakerFromConfig() error = %v", err)
+	}
+	clock := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	breaker.now = func() time.Time { return clock }
+	return &remoteKeyStore{validator: validator, breaker: breaker, logger: logger}, &clock
+}
+
+func TestCircuitBreakerIgnoresClientCancellation(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancelExpired := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancelExpired()
+
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		status   int
+		wantOpen bool
+	}{
+		{name: "client canceled", ctx: canceled, status: http.StatusOK},
+		{name: "client deadline exceeded", ctx: expired, status: http.StatusOK},
+		{name: "endpoint error", ctx: context.Background(), status: http.StatusInternalServerError, wantOpen: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, _ := failingServer(t, 0, 0, test.status)
+			store, _ := newBreakerStore(t, server.URL)
+
+			if _, err := store.call(test.ctx, "secret-key-1"); err == nil {
+				t.Fatal("call() error = nil, want error")
+			}
+			if err := store.breaker.allow(); (err != nil) != test.wantOpen {
+				t.Errorf("allow() error = %v, want open circuit %v", err, test.wantOpen)
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerProbeCanceledByClient(t *testing.T) {
+	status := int64(http.StatusInternalServerError)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(int(atomic.LoadInt64(&status)))
+	}))
+	defer server.Close()
+	store, clock := newBreakerStore(t, server.URL)
+
+	if _, err := store.call(context.Background(), "secret-key-1"); err == nil {
+		t.Fatal("call() error = nil, want error")
+	}
+	*clock = clock.Add(2 * time.Minute)
+
+	// The probe is abandoned by its client; the next call probes instead.
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := store.call(canceled, "secret-key-1"); err == nil || errors.Is(err, errCircuitOpen) {
+		t.Fatalf("probe error = %v, want the cancellation", err)
+	}
+	atomic.StoreInt64(&status, http.StatusOK)
+	if valid, err := store.call(context.Background(), "secret-key-1"); err != nil || !valid {
+		t.Errorf("call() = %v, %v, want the next probe to close the circuit", valid, err)
+	}
+}
@@ -0,0 +1,55 @@
+package swissknife
+
+import (
+	"fmt"
+	"strings"
+)
+
+// weakKeys are keys that are never accepted when key entropy is required.
+var weakKeys = map[string]struct{}{
+	"test":     {},
+	"changeme": {},
+	"password": {},
+	"secret":   {},
+	"apikey":   {},
+	"api-key":  {},
+}
+
+// checkKeyStrength checks the plaintext keys of entries against the minimum
+// length and entropy requirements. Errors give the index of a weak key, never
+// the key itself. Digests and bcrypt hashes cannot be checked.
+func (ka *SwissKnife) checkKeyStrength(entries []KeyEntry, origin string) error {
+	if ka.allowWeakKeys || ka.hashedKeys {
+		return nil
+	}
+
+	for i, entry := range entries {
+		if strings.HasPrefix(entry.Key, sha256KeyPrefix) || strings.HasPrefix(entry.Key, bcryptKeyPrefix) {
+			continue
+		}
+		if reason := ka.weakKeyReason(entry.Key); reason != "" {
+			return fmt.Errorf("invalid %s at index %d: %s", origin, i, reason)
+		}
+	}
+	return nil
+}
+
+func (ka *SwissKnife) weakKeyReason(key string) string {
+	if key == "" {
+		// Empty keys are rejected when they are added.
+		return ""
+	}
+	if len(key) < ka.minKeyLength {
+		return fmt.Sprintf("key must be at least %d characters long", ka.minKeyLength)
+	}
+	if !ka.requireKeyEntropy {
+		return ""
+	}
+	if _, ok := weakKeys[strings.ToLower(key)]; ok {
+		return "key is a well-known weak key"
+	}
+	if strings.Count(key, key[:1]) == len(key) {
+		return "key must not repeat a single character"
+	}
+	return ""
+}
@@ -0,0 +1,47 @@
+package swissknife
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+)
+
+// The headers Kong sets on requests from its consumers, for upstreams moving
+// from Kong.
+const (
+	kongConsumerIDHeader       = "X-Consumer-Id"
+	kongConsumerUsernameHeader = "X-Consumer-Username"
+	kongAnonymousHeader        = "X-Anonymous-Consumer"
+)
+
+var kongHeaders = []string{kongConsumerIDHeader, kongConsumerUsernameHeader, kongAnonymousHeader}
+
+// setKongHeaders sets the Kong consumer headers of an allowed request: the
+// name of the key entry and an ID derived from it, or X-Anonymous-Consumer
+// for anonymous requests. Keys without a name set none.
+func setKongHeaders(header http.Header, d decision) {
+	if d.outcome == outcomeAnonymous {
+		header.Set(kongAnonymousHeader, "true")
+		return
+	}
+
+	name := d.consumerName()
+	if d.outcome != outcomeAuthorized || name == "" {
+		return
+	}
+	header.Set(kongConsumerIDHeader, kongConsumerID(name))
+	header.Set(kongConsumerUsernameHeader, name)
+}
+
+// kongConsumerID returns a stable ID for the consumer name, formatted like
+// the UUIDs of Kong consumers (a version 8 UUID built from the SHA-256 digest
+// of the name).
+func kongConsumerID(name string) string {
+	digest := sha256.Sum256([]byte(name))
+	id := digest[:16]
+	id[6] = id[6]&0x0f | 0x80
+	id[8] = id[8]&0x3f | 0x80
+
+	hexID := hex.EncodeToString(id)
+	return hexID[0:8] + "-" + hexID[8:12] + "-" + hexID[12:16] + "-" + hexID[16:20] + "-" + hexID[20:32]
+}
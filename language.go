@@ -0,0 +1,136 @@
+package swissknife
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// errorMessages holds the unauthorized message in several languages, keyed
+// by lowercase language tag.
+type errorMessages struct {
+	messages map[string]string
+	fallback string
+}
+
+// newErrorMessages returns nil when no message is configured. fallback is
+// sent when no language of the request is available and no default
+// language is set.
+func newErrorMessages(messages map[string]string, defaultLanguage, fallback string) (*errorMessages, error) {
+	if len(messages) == 0 {
+		if defaultLanguage != "" {
+			return nil, fmt.Errorf("default language %q requires error messages", defaultLanguage)
+		}
+		return nil, nil
+	}
+
+	localized := &errorMessages{messages: make(map[string]string, len(messages)), fallback: fallback}
+	for tag, message := range messages {
+		if !validLanguageTag(tag) {
+			return nil, fmt.Errorf("invalid error message language %q", tag)
+		}
+		localized.messages[strings.ToLower(tag)] = message
+	}
+	if defaultLanguage != "" {
+		message, ok := localized.messages[strings.ToLower(defaultLanguage)]
+		if !ok {
+			return nil, fmt.Errorf("default language %q has no error message", defaultLanguage)
+		}
+		localized.fallback = message
+	}
+	return localized, nil
+}
+
+// message returns the message in the language of acceptLanguage that fits
+// best.
+func (m *errorMessages) message(acceptLanguage string) string {
+	for _, languageRange := range parseAcceptLanguage(acceptLanguage) {
+		if languageRange == "*" {
+			break
+		}
+		for tag := languageRange; tag != ""; tag = truncateLanguageTag(tag) {
+			if message, ok := m.messages[tag]; ok {
+				return message
+			}
+		}
+	}
+	return m.fallback
+}
+
+// parseAcceptLanguage returns the lowercase language ranges of an
+// Accept-Language header, by decreasing quality value. Ranges with a
+// quality of 0 and malformed entries are dropped.
+func parseAcceptLanguage(header string) []string {
+	type weightedRange struct {
+		tag     string
+		quality float64
+	}
+
+	var ranges []weightedRange
+	for _, entry := range strings.Split(header, ",") {
+		params := strings.Split(entry, ";")
+		tag := strings.ToLower(strings.TrimSpace(params[0]))
+		if tag != "*" && !validLanguageTag(tag) {
+			continue
+		}
+
+		quality, valid := 1.0, true
+		for _, param := range params[1:] {
+			name, value, found := strings.Cut(strings.TrimSpace(param), "=")
+			if !found || !strings.EqualFold(strings.TrimSpace(name), "q") {
+				continue
+			}
+			q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil || q < 0 || q > 1 {
+				valid = false
+				break
+			}
+			quality = q
+		}
+		if valid && quality > 0 {
+			ranges = append(ranges, weightedRange{tag: tag, quality: quality})
+		}
+	}
+
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].quality > ranges[j].quality
+	})
+	tags := make([]string, len(ranges))
+	for i, r := range ranges {
+		tags[i] = r.tag
+	}
+	return tags
+}
+
+// truncateLanguageTag removes the last subtag of tag, and a single letter
+// subtag left before it, as the lookup of RFC 4647 section 3.4 does. It
+// returns "" for a primary tag.
+func truncateLanguageTag(tag string) string {
+	i := strings.LastIndexByte(tag, '-')
+	if i < 0 {
+		return ""
+	}
+	tag = tag[:i]
+	if i = strings.LastIndexByte(tag, '-'); i >= 0 && len(tag)-i == 2 {
+		tag = tag[:i]
+	}
+	return tag
+}
+
+// validLanguageTag reports whether tag is made of subtags of 1 to 8 letters
+// or digits separated by hyphens, the primary one letters only.
+func validLanguageTag(tag string) bool {
+	for i, subtag := range strings.Split(tag, "-") {
+		if len(subtag) == 0 || len(subtag) > 8 {
+			return false
+		}
+		for _, c := range subtag {
+			letter := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+			if !letter && (i == 0 || c < '0' || c > '9') {
+				return false
+			}
+		}
+	}
+	return true
+}
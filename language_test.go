@@ -0,0 +1,187 @@
+package swissknife
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseAcceptLanguage(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   []string
+	}{
+		{name: "empty", header: "", want: []string{}},
+		{name: "single", header: "fr", want: []string{"fr"}},
+		{name: "lowercased", header: "fr-CA", want: []string{"fr-ca"}},
+		{name: "ordered by quality", header: "en;q=0.5, fr-CA, de;q=0.8", want: []string{"fr-ca", "de", "en"}},
+		{name: "equal qualities keep order", header: "de;q=0.5, fr;q=0.5", want: []string{"de", "fr"}},
+		{name: "zero quality dropped", header: "fr;q=0, de", want: []string{"de"}},
+		{name: "wildcard", header: "fr, *;q=0.1", want: []string{"fr", "*"}},
+		{name: "uppercase q", header: "fr;Q=0.3, de", want: []string{"de", "fr"}},
+		{name: "spaces around parameters", header: "fr ; q = 0.3 , de", want: []string{"de", "fr"}},
+		{name: "other parameters ignored", header: "fr;level=1", want: []string{"fr"}},
+		{name: "malformed quality dropped", header: "fr;q=high, de", want: []string{"de"}},
+		{name: "quality above 1 dropped", header: "fr;q=2, de", want: []string{"de"}},
+		{name: "negative quality dropped", header: "fr;q=-1, de", want: []string{"de"}},
+		{name: "invalid tag dropped", header: "fr_FR, de", want: []string{"de"}},
+		{name: "empty entries dropped", header: ", ,fr,", want: []string{"fr"}},
+		{name: "digit in the primary subtag dropped", header: "f1, es-419", want: []string{"es-419"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := parseAcceptLanguage(test.header); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("parseAcceptLanguage(%q) = %q, want %q", test.header, got, test.want)
+			}
+		})
+	}
+}
+
+func TestTruncateLanguageTag(t *testing.T) {
+	tests := []struct {
+		tag  string
+		want string
+	}{
+		{tag: "fr", want: ""},
+		{tag: "fr-ca", want: "fr"},
+		{tag: "zh-hant-cn", want: "zh-hant"},
+		{tag: "de-ch-x-phonebk", want: "de-ch"},
+		{tag: "de-ch-1996", want: "de-ch"},
+	}
+	for _, test := range tests {
+		if got := truncateLanguageTag(test.tag); got != test.want {
+			t.Errorf("truncateLanguageTag(%q) = %q, want %q", test.tag, got, test.want)
+		}
+	}
+}
+
+func TestValidLanguageTag(t *testing.T) {
+	tests := []struct {
+		tag  string
+		want bool
+	}{
+		{tag: "fr", want: true},
+		{tag: "fr-CA", want: true},
+		{tag: "es-419", want: true},
+		{tag: "x-private", want: true},
+		{tag: "", want: false},
+		{tag: "fr-", want: false},
+		{tag: "-fr", want: false},
+		{tag: "fr--ca", want: false},
+		{tag: "fr_CA", want: false},
+		{tag: "123", want: false},
+		{tag: "abcdefghi", want: false},
+		{tag: "fr-abcdefghi", want: false},
+	}
+	for _, test := range tests {
+		if got := validLanguageTag(test.tag); got != test.want {
+			t.Errorf("validLanguageTag(%q) = %v, want %v", test.tag, got, test.want)
+		}
+	}
+}
+
+func TestErrorMessagesMessage(t *testing.T) {
+	messages := map[string]string{
+		"en":    "Invalid API key",
+		"fr":    "Clé d'API invalide",
+		"de":    "Ungültiger API-Schlüssel",
+		"de-CH": "Ungültiger API-Schlüssel (CH)",
+	}
+
+	tests := []struct {
+		name            string
+		defaultLanguage string
+		header          string
+		want            string
+	}{
+		{name: "exact", header: "fr", want: messages["fr"]},
+		{name: "case insensitive", header: "DE-ch", want: messages["de-CH"]},
+		{name: "range fallback", header: "fr-CA", want: messages["fr"]},
+		{name: "best quality", header: "fr;q=0.5, de", want: messages["de"]},
+		{name: "unavailable first", header: "it, fr;q=0.9", want: messages["fr"]},
+		{name: "more specific than available", header: "de-CH-1996", want: messages["de-CH"]},
+		{name: "zero quality skipped", header: "fr;q=0, de;q=0.1", want: messages["de"]},
+		{name: "no header", header: "", want: "Invalid API Key"},
+		{name: "unavailable", header: "it", want: "Invalid API Key"},
+		{name: "wildcard", header: "*", want: "Invalid API Key"},
+		{name: "default language", defaultLanguage: "EN", header: "it", want: messages["en"]},
+		{name: "available before wildcard", defaultLanguage: "en", header: "de, *;q=0.5", want: messages["de"]},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m, err := newErrorMessages(messages, test.defaultLanguage, "Invalid API Key")
+			if err != nil {
+				t.Fatalf("newErrorMessages() error = %v", err)
+			}
+			if got := m.message(test.header); got != test.want {
+				t.Errorf("message(%q) = %q, want %q", test.header, got, test.want)
+			}
+		})
+	}
+}
+
+func TestInvalidErrorMessages(t *testing.T) {
+	tests := []struct {
+		name            string
+		messages        map[string]string
+		defaultLanguage string
+		want            string
+	}{
+		{name: "invalid language", messages: map[string]string{"fr_FR": "Clé d'API invalide"}, want: `invalid error message language "fr_FR"`},
+		{name: "default language without message", messages: map[string]string{"fr": "Clé d'API invalide"}, defaultLanguage: "de", want: `default language "de" has no error message`},
+		{name: "default language without messages", defaultLanguage: "en", want: `default language "en" requires error messages`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := CreateConfig()
+			config.Keys = []string{"secret-key-1"}
+			config.ErrorMessages = test.messages
+			config.DefaultLanguage = test.defaultLanguage
+			_, err := New(context.Background(), noopHandler, config, "test")
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("New() error = %v, want %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestLocalizedErrorResponse(t *testing.T) {
+	config := CreateConfig()
+	config.Keys = []string{"secret-key-1"}
+	config.ErrorMessages = map[string]string{"en": "Invalid API key", "fr": "Clé d'API invalide"}
+	config.DefaultLanguage = "en"
+	ka := newTestHandler(t, config, nil)
+
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{header: "fr-CA, en;q=0.5", want: "Clé d'API invalide"},
+		{header: "de", want: "Invalid API key"},
+	}
+	for _, test := range tests {
+		req := newKeyRequest("wrong-key")
+		req.Header.Set("Accept-Language", test.header)
+		rec := serveRecorded(ka, req)
+
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("status code for %q = %d, want %d", test.header, rec.Code, http.StatusForbidden)
+		}
+		var body struct {
+			Message string `json:"message"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("decoding body %q: %v", rec.Body.String(), err)
+		}
+		if body.Message != test.want {
+			t.Errorf("message for %q = %q, want %q", test.header, body.Message, test.want)
+		}
+		if got := rec.Header().Get("Vary"); got != "Accept-Language" {
+			t.Errorf("Vary = %q, want %q", got, "Accept-Language")
+		}
+	}
+}
@@ -0,0 +1,353 @@
+package swissknife
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	levelDebug = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+const (
+	outcomeAuthorized    = "authorized"
+	outcomeBypassed      = "bypassed"
+	outcomeRejected      = "rejected"
+	outcomeForbidden     = "forbidden"
+	outcomeBanned        = "banned"
+	outcomeUnavailable   = "unavailable"
+	outcomeAnonymous     = "anonymous"
+	outcomeReplayed      = "replayed"
+	outcomeInvalidToken  = "invalid_token"
+	outcomeUnchecked     = "unchecked"
+	outcomeConflict      = "conflict"
+	outcomeInsecure      = "insecure"
+	outcomeQuotaExceeded = "quota_exceeded"
+	outcomeMaintenance   = "maintenance"
+)
+
+var levelNames = map[string]int{
+	"debug": levelDebug,
+	"info":  levelInfo,
+	"warn":  levelWarn,
+	"error": levelError,
+}
+
+// logger writes one line per message, either as plain text or as a JSON
+// object. Warnings and errors are always written; other levels only when
+// enabled.
+//
+// Key values must never be passed to the logger: the request URL is logged
+// with the query parameters holding keys redacted.
+type logger struct {
+	mu           sync.Mutex
+	enabled      bool
+	level        int
+	json         bool
+	plugin       string
+	redactParams []string
+	sink         Logger
+	sampleRate   float64
+	limiter      *logLimiter
+	accessLog    bool
+	accessJSON   bool
+	traceHeaders bool
+	clientIP     func(req *http.Request) (netip.Addr, bool)
+}
+
+type logRecord struct {
+	Time       string `json:"time"`
+	Level      string `json:"level"`
+	Plugin     string `json:"plugin"`
+	Msg        string `json:"msg"`
+	Method     string `json:"method,omitempty"`
+	Path       string `json:"path,omitempty"`
+	RemoteAddr string `json:"remoteAddr,omitempty"`
+	ClientIP   string `json:"clientIP,omitempty"`
+	Outcome    string `json:"outcome,omitempty"`
+}
+
+// logField is an additional named value attached to a log line.
+type logField struct {
+	name  string
+	value string
+}
+
+func newLogger(config *Config, name string) (*logger, error) {
+	level, ok := levelNames[config.LogLevel]
+	if !ok {
+		return nil, fmt.Errorf("log level must be debug, info, warn or error, got %q", config.LogLevel)
+	}
+	if config.LogFormat != "text" && config.LogFormat != "json" {
+		return nil, fmt.Errorf("log format must be text or json, got %q", config.LogFormat)
+	}
+
+	if config.AccessLog && config.AccessLogFormat != "common" && config.AccessLogFormat != "json" {
+		return nil, fmt.Errorf("access log format must be common or json, got %q", config.AccessLogFormat)
+	}
+	if config.LogSampleRate < 0 || config.LogSampleRate > 1 {
+		return nil, fmt.Errorf("log sample rate must be between 0 and 1, got %v", config.LogSampleRate)
+	}
+	if config.MaxLogLinesPerSecond < 0 {
+		return nil, fmt.Errorf("max log lines per second must not be negative, got %d", config.MaxLogLinesPerSecond)
+	}
+
+	sink, err := newLogSink(config.LogSink)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &logger{
+		enabled:      config.EnableLog,
+		level:        level,
+		json:         config.LogFormat == "json",
+		plugin:       name,
+		sink:         sink,
+		sampleRate:   config.LogSampleRate,
+		limiter:      newLogLimiter(config.MaxLogLinesPerSecond),
+		accessLog:    config.AccessLog,
+		accessJSON:   config.AccessLogFormat == "json",
+		traceHeaders: config.TraceHeaders,
+	}
+	if config.QueryParam {
+		l.redactParams = append(l.redactParams, config.QueryParamName)
+	}
+	if config.Rfc6750Sources {
+		l.redactParams = append(l.redactParams, accessTokenParam)
+	}
+	return l, nil
+}
+
+// requestIP returns the client IP of req, if it can be determined.
+func (l *logger) requestIP(req *http.Request) (netip.Addr, bool) {
+	if l.clientIP == nil {
+		return ClientIP(req.Context())
+	}
+	return l.clientIP(req)
+}
+
+func (l *logger) enabledFor(level int) bool {
+	return level >= levelWarn || (l.enabled && level >= l.level)
+}
+
+func (l *logger) debug(req *http.Request, outcome, msg string, fields ...logField) {
+	l.log(levelDebug, req, outcome, msg, fields...)
+}
+
+func (l *logger) info(req *http.Request, outcome, msg string, fields ...logField) {
+	l.log(levelInfo, req, outcome, msg, fields...)
+}
+
+func (l *logger) warn(req *http.Request, outcome, msg string, fields ...logField) {
+	l.log(levelWarn, req, outcome, msg, fields...)
+}
+
+func (l *logger) error(req *http.Request, outcome, msg string, fields ...logField) {
+	l.log(levelError, req, outcome, msg, fields...)
+}
+
+// log writes msg at level. In text format a request is appended to the
+// message as "msg: METHOD URL", followed by any fields as "name=value"; in
+// JSON format they are all separate fields.
+func (l *logger) log(level int, req *http.Request, outcome, msg string, fields ...logField) {
+	if !l.enabledFor(level) || !l.sampled(level, req != nil) || l.limited(level, isFailureOutcome(outcome)) {
+		return
+	}
+	l.write(level, req, outcome, msg, fields)
+}
+
+// response logs the response sent to a rejected request. It is sampled and
+// limited like the line logging the rejection.
+func (l *logger) response(msg string) {
+	if !l.enabledFor(levelInfo) || !l.sampled(levelInfo, true) || l.limited(levelInfo, true) {
+		return
+	}
+	l.write(levelInfo, nil, "", msg, nil)
+}
+
+func (l *logger) write(level int, req *http.Request, outcome, msg string, fields []logField) {
+	if l.traceHeaders && req != nil {
+		if id := requestTraceID(req); id != "" {
+			fields = append(fields[:len(fields):len(fields)], logField{name: "traceId", value: id})
+		}
+	}
+
+	var line string
+	if l.json {
+		line = l.jsonLine(level, req, outcome, msg, fields)
+	} else {
+		var b strings.Builder
+		b.WriteString(msg)
+		if req != nil {
+			b.WriteString(": ")
+			b.WriteString(req.Method)
+			b.WriteString(" ")
+			b.WriteString(l.redactedURL(req.URL))
+		}
+		for _, field := range fields {
+			b.WriteString(" ")
+			b.WriteString(field.name)
+			b.WriteString("=")
+			b.WriteString(field.value)
+		}
+		line = b.String()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sink.Log(levelName(level), line)
+}
+
+func (l *logger) jsonLine(level int, req *http.Request, outcome, msg string, fields []logField) string {
+	record := logRecord{
+		Time:    time.Now().UTC().Format(time.RFC3339Nano),
+		Level:   levelName(level),
+		Plugin:  l.plugin,
+		Msg:     msg,
+		Outcome: outcome,
+	}
+	if req != nil {
+		record.Method = req.Method
+		record.Path = req.URL.Path
+		record.RemoteAddr = req.RemoteAddr
+		if addr, ok := l.requestIP(req); ok {
+			record.ClientIP = addr.String()
+		}
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Sprintf("{\"level\":\"error\",\"msg\":%q}", err.Error())
+	}
+
+	data = data[:len(data)-1]
+	for _, field := range fields {
+		name, _ := json.Marshal(field.name)
+		value, _ := json.Marshal(field.value)
+		data = append(data, ',')
+		data = append(data, name...)
+		data = append(data, ':')
+		data = append(data, value...)
+	}
+	return string(data) + "}"
+}
+
+// fingerprint identifies key in logs without revealing it: the first four
+// characters (only for keys long enough that most of the key stays hidden)
+// followed by the start of the key's SHA-256 digest.
+func fingerprint(key string) string {
+	if key == "" {
+		return "<empty>"
+	}
+
+	digest := sha256.Sum256([]byte(key))
+	short := hex.EncodeToString(digest[:4])
+
+	runes := []rune(key)
+	if len(runes) <= 8 {
+		return "…:" + short
+	}
+	return string(runes[:4]) + "…:" + short
+}
+
+func (l *logger) redactedURL(u *url.URL) string {
+	if len(l.redactParams) == 0 || u.RawQuery == "" {
+		return u.String()
+	}
+
+	query := u.Query()
+	redact := false
+	for _, param := range l.redactParams {
+		if query.Has(param) {
+			query.Set(param, "REDACTED")
+			redact = true
+		}
+	}
+	if !redact {
+		return u.String()
+	}
+
+	redacted := *u
+	redacted.RawQuery = query.Encode()
+	return redacted.String()
+}
+
+func levelName(level int) string {
+	for name, value := range levelNames {
+		if value == level {
+			return name
+		}
+	}
+	return ""
+}
+
+// redacted returns a copy of the config that is safe to log.
+func (c Config) redacted() Config {
+	c.Keys = []string{fmt.Sprintf("<%d keys>", len(c.Keys))}
+	if c.KeysCSV != "" {
+		c.KeysCSV = fmt.Sprintf("<%d keys>", len(splitKeysCSV(c.KeysCSV)))
+	}
+	entries := make([]KeyEntry, 0, len(c.KeyEntries))
+	for _, entry := range c.KeyEntries {
+		entries = append(entries, KeyEntry{Name: entry.Name, Key: "REDACTED"})
+	}
+	c.KeyEntries = entries
+	groups := make([]KeyGroup, 0, len(c.KeyGroups))
+	for _, group := range c.KeyGroups {
+		groups = append(groups, KeyGroup{Name: group.Name, Keys: []string{fmt.Sprintf("<%d keys>", len(group.Keys))}, Paths: group.Paths, Methods: group.Methods})
+	}
+	c.KeyGroups = groups
+	if len(c.RevokedKeys) > 0 {
+		c.RevokedKeys = []string{fmt.Sprintf("<%d keys>", len(c.RevokedKeys))}
+	}
+	if c.UpstreamToken != "" {
+		c.UpstreamToken = "REDACTED"
+	}
+	secrets := make([]SignatureSecret, 0, len(c.SignatureAuth.Secrets))
+	for _, secret := range c.SignatureAuth.Secrets {
+		secrets = append(secrets, SignatureSecret{Name: secret.Name, Secret: "REDACTED"})
+	}
+	c.SignatureAuth.Secrets = secrets
+	if c.JWT.HS256Secret != "" {
+		c.JWT.HS256Secret = "REDACTED"
+	}
+	if c.SignedTokens.Secret != "" {
+		c.SignedTokens.Secret = "REDACTED"
+	}
+	if c.FailureWebhook.Headers != nil {
+		headers := make(map[string]string, len(c.FailureWebhook.Headers))
+		for name := range c.FailureWebhook.Headers {
+			headers[name] = "REDACTED"
+		}
+		c.FailureWebhook.Headers = headers
+	}
+	c.ValidationURL = redactedPassword(c.ValidationURL)
+	c.JWT.JwksURL = redactedPassword(c.JWT.JwksURL)
+	c.FailureWebhook.URL = redactedPassword(c.FailureWebhook.URL)
+	c.UsageReportURL = redactedPassword(c.UsageReportURL)
+	return c
+}
+
+// redactedPassword returns rawURL with the password of its user info, if
+// any, replaced.
+func redactedPassword(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	if _, ok := u.User.Password(); !ok {
+		return rawURL
+	}
+	return u.Redacted()
+}
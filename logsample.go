@@ -0,0 +1,92 @@
+package swissknife
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// logSummaryInterval is how often the number of failure lines dropped by
+// the log limiter is logged.
+const logSummaryInterval = time.Minute
+
+// sampled tells whether a line is kept by the log sample rate. Only the
+// debug and info lines about requests are sampled.
+func (l *logger) sampled(level int, perRequest bool) bool {
+	if l.sampleRate >= 1 || !perRequest || level > levelInfo {
+		return true
+	}
+	return rand.Float64() < l.sampleRate
+}
+
+// isFailureOutcome tells whether outcome is a rejection.
+func isFailureOutcome(outcome string) bool {
+	for _, rejected := range rejectedOutcomes {
+		if outcome == rejected {
+			return true
+		}
+	}
+	return false
+}
+
+// logLimiter is a token bucket capping the failure lines logged per second,
+// so that a flood of bad keys cannot fill the disk. It counts the lines it
+// drops, to be logged as a summary.
+type logLimiter struct {
+	mu         sync.Mutex
+	rate       float64
+	tokens     float64
+	last       time.Time
+	suppressed int64
+	since      time.Time
+}
+
+func newLogLimiter(perSecond int) *logLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+
+	now := time.Now()
+	return &logLimiter{rate: float64(perSecond), tokens: float64(perSecond), last: now, since: now}
+}
+
+// take takes a token for a line at now. Once per logSummaryInterval, it
+// also returns the number of lines dropped since the last summary.
+func (b *logLimiter) take(now time.Time) (allowed bool, suppressed int64, period time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+	b.last = now
+
+	if now.Sub(b.since) >= logSummaryInterval {
+		suppressed, period = b.suppressed, now.Sub(b.since)
+		b.suppressed, b.since = 0, now
+	}
+
+	if b.tokens < 1 {
+		b.suppressed++
+		return false, suppressed, period
+	}
+	b.tokens--
+	return true, suppressed, period
+}
+
+// limited tells whether a failure line is dropped by the log limiter, and
+// logs the summary of the dropped lines when one is due. Errors are never
+// dropped.
+func (l *logger) limited(level int, failure bool) bool {
+	if l.limiter == nil || level >= levelError || !failure {
+		return false
+	}
+
+	allowed, suppressed, period := l.limiter.take(time.Now())
+	if suppressed > 0 {
+		l.warn(nil, "", fmt.Sprintf("Suppressed %d similar messages in the last %ds", suppressed, int64(period.Seconds())))
+	}
+	return !allowed
+}
@@ -0,0 +1,127 @@
+package swissknife
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// levelAudit is the level audit records are passed to the Logger with.
+const levelAudit = "audit"
+
+// Logger receives the lines logged by the plugin, without the trailing
+// newline. level is debug, info, warn, error, audit or access. Log lines are
+// formatted following logFormat, and only passed on when enabled by
+// enableLog and logLevel.
+type Logger interface {
+	Log(level, line string)
+}
+
+// SetLogger replaces the sink of the plugin logs, as set by logSink.
+//
+//nolint:all
+func (ka *SwissKnife) SetLogger(sink Logger) {
+	ka.logger.mu.Lock()
+	ka.logger.sink = sink
+	ka.logger.mu.Unlock()
+}
+
+// newLogSink returns the sink named by the logSink option: stdout, stderr,
+// discard or the path of a file.
+func newLogSink(name string) (Logger, error) {
+	switch name {
+	case "", "stdout":
+		return &writerLogger{out: os.Stdout, errOut: os.Stderr}, nil
+	case "stderr":
+		return &writerLogger{out: os.Stderr, errOut: os.Stderr}, nil
+	case "discard":
+		return &writerLogger{out: io.Discard, errOut: io.Discard}, nil
+	default:
+		return openFileLogger(name)
+	}
+}
+
+// writerLogger writes errors and audit records to errOut, and the other
+// lines to out.
+type writerLogger struct {
+	out    io.Writer
+	errOut io.Writer
+}
+
+func (w *writerLogger) Log(level, line string) {
+	out := w.out
+	if level == "error" || level == levelAudit {
+		out = w.errOut
+	}
+	_, _ = io.WriteString(out, line+"\n")
+}
+
+// fileReopenInterval is how often a file sink checks whether its file was
+// replaced, as done by log rotation.
+const fileReopenInterval = time.Second
+
+// fileLogger appends the lines to a file, reopening it when it was moved or
+// removed so rotated logs need no signal.
+type fileLogger struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	info    os.FileInfo
+	checked time.Time
+}
+
+func openFileLogger(path string) (*fileLogger, error) {
+	l := &fileLogger{path: path}
+	if err := l.open(); err != nil {
+		return nil, fmt.Errorf("invalid log sink: %w", err)
+	}
+	return l, nil
+}
+
+func (l *fileLogger) open() error {
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	if l.file != nil {
+		_ = l.file.Close()
+	}
+	l.file, l.info, l.checked = file, info, time.Now()
+	return nil
+}
+
+// close closes the file. Lines logged afterwards are dropped.
+func (l *fileLogger) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file != nil {
+		_ = l.file.Close()
+		l.file = nil
+	}
+}
+
+func (l *fileLogger) Log(_, line string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return
+	}
+	if time.Since(l.checked) >= fileReopenInterval {
+		l.checked = time.Now()
+		if info, err := os.Stat(l.path); err != nil || !os.SameFile(info, l.info) {
+			// Keep writing to the old file if the new one cannot be opened.
+			_ = l.open()
+		}
+	}
+	_, _ = io.WriteString(l.file, line+"\n")
+}
@@ -0,0 +1,79 @@
+package swissknife
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maintenanceSwitch tells whether the plugin is in maintenance mode, either
+// because it is configured so or because the maintenance file exists. The
+// file is checked at most once per interval.
+type maintenanceSwitch struct {
+	enabled    bool
+	file       string
+	interval   time.Duration
+	message    string
+	retryAfter string
+
+	mu        sync.Mutex
+	checkedAt time.Time
+	exists    bool
+}
+
+func newMaintenanceSwitchFromConfig(config *Config) (*maintenanceSwitch, error) {
+	if !config.MaintenanceMode && config.MaintenanceFile == "" {
+		return nil, nil
+	}
+
+	interval, err := time.ParseDuration(config.MaintenanceCheckInterval)
+	if err != nil {
+		return nil, fmt.Errorf("invalid maintenance check interval: %w", err)
+	}
+	if interval < 0 {
+		return nil, errors.New("maintenance check interval must not be negative")
+	}
+	retryAfter, err := time.ParseDuration(config.MaintenanceRetryAfter)
+	if err != nil {
+		return nil, fmt.Errorf("invalid maintenance retry after: %w", err)
+	}
+	if retryAfter < 0 {
+		return nil, errors.New("maintenance retry after must not be negative")
+	}
+
+	return &maintenanceSwitch{
+		enabled:    config.MaintenanceMode,
+		file:       config.MaintenanceFile,
+		interval:   interval,
+		message:    config.MaintenanceMessage,
+		retryAfter: strconv.FormatInt(int64(retryAfter.Seconds()), 10),
+	}, nil
+}
+
+func (ms *maintenanceSwitch) active(now time.Time) bool {
+	if ms.enabled {
+		return true
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if ms.checkedAt.IsZero() || now.Sub(ms.checkedAt) >= ms.interval {
+		_, err := os.Stat(ms.file)
+		ms.exists = err == nil
+		ms.checkedAt = now
+	}
+	return ms.exists
+}
+
+// responseMaintenance rejects a request received in maintenance mode.
+func (ka *SwissKnife) responseMaintenance(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Retry-After", ka.maintenance.retryAfter)
+	ka.writeResponse(rw, req, Response{
+		Message:    ka.maintenance.message,
+		StatusCode: http.StatusServiceUnavailable,
+	})
+}
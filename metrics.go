@@ -0,0 +1,167 @@
+package swissknife
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// rejectedOutcomes are the outcomes counted as rejections, by reason.
+var rejectedOutcomes = []string{outcomeRejected, outcomeForbidden, outcomeBanned, outcomeUnavailable, outcomeReplayed, outcomeInvalidToken, outcomeConflict, outcomeInsecure, outcomeQuotaExceeded, outcomeMaintenance}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// metrics counts the requests handled by the middleware. The counters are
+// returned by Stats, and exposed in the Prometheus text format on the
+// metrics path.
+type metrics struct {
+	prefix     string
+	requests   int64
+	authorized int64
+	deprecated int64
+	bypassed   int64
+	rejected   map[string]*int64
+
+	// keys counts the authorized requests of at most maxKeys key names, the
+	// others go to otherKeys.
+	keysMu    sync.Mutex
+	keys      map[string]*int64
+	maxKeys   int
+	otherKeys int64
+}
+
+func newMetricsFromConfig(config *Config, name string) (*metrics, error) {
+	if config.MetricsPath != "" && !strings.HasPrefix(config.MetricsPath, "/") {
+		return nil, errors.New("metrics path must start with /")
+	}
+	if config.MetricsMaxKeys < 0 {
+		return nil, errors.New("metrics max keys must not be negative")
+	}
+
+	rejected := make(map[string]*int64, len(rejectedOutcomes))
+	for _, outcome := range rejectedOutcomes {
+		rejected[outcome] = new(int64)
+	}
+
+	return &metrics{
+		prefix:   metricPrefix(name),
+		rejected: rejected,
+		keys:     make(map[string]*int64),
+		maxKeys:  config.MetricsMaxKeys,
+	}, nil
+}
+
+// metricPrefix returns the prefix of the metric names of the instance name,
+// with the characters Prometheus does not allow in names replaced.
+func metricPrefix(name string) string {
+	if name == "" {
+		return "swissknife"
+	}
+
+	var b strings.Builder
+	b.WriteString("swissknife_")
+	for _, r := range name {
+		if r < 0x80 && (r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+func (m *metrics) record(d decision) {
+	atomic.AddInt64(&m.requests, 1)
+
+	if counter, ok := m.rejected[d.outcome]; ok {
+		atomic.AddInt64(counter, 1)
+		return
+	}
+	if d.outcome == outcomeBypassed {
+		atomic.AddInt64(&m.bypassed, 1)
+		return
+	}
+	if d.outcome != outcomeAuthorized {
+		return
+	}
+
+	atomic.AddInt64(&m.authorized, 1)
+	if d.deprecated {
+		atomic.AddInt64(&m.deprecated, 1)
+	}
+	if name := d.consumerName(); name != "" {
+		m.keysMu.Lock()
+		counter, ok := m.keys[name]
+		if !ok && len(m.keys) < m.maxKeys {
+			counter = new(int64)
+			m.keys[name] = counter
+		}
+		m.keysMu.Unlock()
+		if counter == nil {
+			atomic.AddInt64(&m.otherKeys, 1)
+		} else {
+			atomic.AddInt64(counter, 1)
+		}
+	}
+}
+
+func (m *metrics) write(b *strings.Builder) {
+	stats := m.stats()
+	writeHeader := func(name, help string) {
+		fmt.Fprintf(b, "# HELP %s_%s %s\n# TYPE %s_%s counter\n", m.prefix, name, help, m.prefix, name)
+	}
+
+	writeHeader("requests_total", "Requests handled by the middleware.")
+	fmt.Fprintf(b, "%s_requests_total %d\n", m.prefix, stats.Requests)
+
+	writeHeader("authorized_total", "Requests authorized with a valid key.")
+	fmt.Fprintf(b, "%s_authorized_total %d\n", m.prefix, stats.Authorized)
+
+	writeHeader("deprecated_total", "Requests authorized with a deprecated key.")
+	fmt.Fprintf(b, "%s_deprecated_total %d\n", m.prefix, stats.Deprecated)
+
+	writeHeader("bypassed_total", "Requests let through without checking a key.")
+	fmt.Fprintf(b, "%s_bypassed_total %d\n", m.prefix, stats.Bypassed)
+
+	writeHeader("rejected_total", "Requests rejected, by reason.")
+	for _, outcome := range rejectedOutcomes {
+		fmt.Fprintf(b, "%s_rejected_total{reason=\"%s\"} %d\n", m.prefix, outcome, stats.Rejected[outcome])
+	}
+
+	names := make([]string, 0, len(stats.Keys))
+	for name := range stats.Keys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	writeHeader("key_authorized_total", "Requests authorized, by key name.")
+	for _, name := range names {
+		fmt.Fprintf(b, "%s_key_authorized_total{key=\"%s\"} %d\n", m.prefix, labelValueEscaper.Replace(name), stats.Keys[name])
+	}
+
+	writeHeader("other_keys_authorized_total", "Requests authorized with key names beyond metricsMaxKeys.")
+	fmt.Fprintf(b, "%s_other_keys_authorized_total %d\n", m.prefix, stats.OtherKeys)
+}
+
+func (ka *SwissKnife) isMetricsRequest(req *http.Request) bool {
+	return ka.metricsPath != "" && req.URL.Path == ka.metricsPath
+}
+
+func (ka *SwissKnife) responseMetrics(rw http.ResponseWriter) {
+	var b strings.Builder
+	ka.metrics.write(&b)
+	if ka.breaker != nil {
+		ka.breaker.writeMetrics(&b, ka.metrics.prefix)
+	}
+	if ka.usage != nil {
+		ka.usage.writeMetrics(&b, ka.metrics.prefix)
+	}
+
+	rw.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	rw.WriteHeader(http.StatusOK)
+	_, _ = rw.Write([]byte(b.String()))
+}
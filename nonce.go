@@ -0,0 +1,53 @@
+package swissknife
+
+import (
+	"sync"
+	"time"
+)
+
+// maxNonceLength bounds the memory used by each remembered nonce.
+const maxNonceLength = 128
+
+// nonceStore remembers the nonces of recent signed requests until they
+// expire. It holds at most a fixed number of them: once full, the oldest one
+// is forgotten, which is normally expired already.
+type nonceStore struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	expires map[string]time.Time
+	ring    []nonceEntry
+	next    int
+}
+
+type nonceEntry struct {
+	key     string
+	expires time.Time
+}
+
+func newNonceStore(ttl time.Duration, size int) *nonceStore {
+	return &nonceStore{
+		ttl:     ttl,
+		expires: make(map[string]time.Time, size),
+		ring:    make([]nonceEntry, size),
+	}
+}
+
+// add remembers key at now and reports whether it was not already known.
+func (ns *nonceStore) add(key string, now time.Time) bool {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	if expires, ok := ns.expires[key]; ok && now.Before(expires) {
+		return false
+	}
+
+	// The oldest entry may be for a key that was added again since.
+	if oldest := ns.ring[ns.next]; oldest.key != "" && ns.expires[oldest.key].Equal(oldest.expires) {
+		delete(ns.expires, oldest.key)
+	}
+	entry := nonceEntry{key: key, expires: now.Add(ns.ttl)}
+	ns.ring[ns.next] = entry
+	ns.next = (ns.next + 1) % len(ns.ring)
+	ns.expires[key] = entry.expires
+	return true
+}
@@ -0,0 +1,92 @@
+package swissknife
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// originPattern matches the origin of a request: its scheme, port and host,
+// the host being a pattern as in compileHostPattern.
+type originPattern struct {
+	scheme string
+	host   string
+	port   string
+}
+
+func compileOriginPatterns(patterns []string) ([]originPattern, error) {
+	compiled := make([]originPattern, 0, len(patterns))
+	for i, pattern := range patterns {
+		p, err := compileOriginPattern(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid origin pattern at index %d %q: %w", i, pattern, err)
+		}
+		compiled = append(compiled, p)
+	}
+	return compiled, nil
+}
+
+func compileOriginPattern(pattern string) (originPattern, error) {
+	u, err := url.Parse(strings.TrimSpace(pattern))
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return originPattern{}, errors.New("pattern must be a scheme and host, e.g. https://app.example.com")
+	}
+	if (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+		return originPattern{}, errors.New("pattern must not have a path, query or user")
+	}
+
+	host, err := compileHostPattern(u.Hostname())
+	if err != nil {
+		return originPattern{}, err
+	}
+	scheme := strings.ToLower(u.Scheme)
+	return originPattern{scheme: scheme, host: host, port: originPort(scheme, u.Port())}, nil
+}
+
+// originPort returns port, or the default port of scheme if it is empty.
+func originPort(scheme, port string) string {
+	if port != "" {
+		return port
+	}
+	switch scheme {
+	case "http", "ws":
+		return "80"
+	case "https", "wss":
+		return "443"
+	}
+	return ""
+}
+
+// requestOrigin returns the origin of req, from its Origin header or else
+// its Referer. found is false when it has neither.
+func requestOrigin(req *http.Request) (u *url.URL, found bool) {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		origin = req.Header.Get("Referer")
+	}
+	if origin == "" {
+		return nil, false
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return nil, true
+	}
+	return u, true
+}
+
+func matchAnyOrigin(patterns []originPattern, u *url.URL) bool {
+	if u == nil || u.Host == "" {
+		return false
+	}
+	scheme := strings.ToLower(u.Scheme)
+	port := originPort(scheme, u.Port())
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	for _, p := range patterns {
+		if p.scheme == scheme && p.port == port && matchAnyHost([]string{p.host}, host) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+package swissknife
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+const (
+	matchExact = iota
+	matchPrefix
+	matchSuffix
+	matchGlob
+)
+
+// pathPattern matches request paths. A pattern is either an exact path, a
+// prefix ending in "/*", a suffix starting with "*", or a glob as understood
+// by path.Match. Trailing slashes are ignored when matching.
+type pathPattern struct {
+	kind  int
+	value string
+}
+
+func compilePathPatterns(patterns []string) ([]pathPattern, error) {
+	compiled := make([]pathPattern, 0, len(patterns))
+	for i, pattern := range patterns {
+		p, err := compilePathPattern(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path pattern at index %d %q: %w", i, pattern, err)
+		}
+		compiled = append(compiled, p)
+	}
+	return compiled, nil
+}
+
+func compilePathPattern(pattern string) (pathPattern, error) {
+	if pattern == "" {
+		return pathPattern{}, errors.New("pattern must not be empty")
+	}
+	if !strings.HasPrefix(pattern, "/") && !strings.HasPrefix(pattern, "*") {
+		return pathPattern{}, errors.New("pattern must start with / or *")
+	}
+
+	if !strings.ContainsAny(pattern, "*?[\\") {
+		return pathPattern{kind: matchExact, value: trimTrailingSlash(pattern)}, nil
+	}
+
+	if prefix, ok := strings.CutSuffix(pattern, "/*"); ok && !strings.ContainsAny(prefix, "*?[\\") {
+		return pathPattern{kind: matchPrefix, value: prefix}, nil
+	}
+
+	if suffix, ok := strings.CutPrefix(pattern, "*"); ok && !strings.ContainsAny(suffix, "*?[\\") {
+		return pathPattern{kind: matchSuffix, value: trimTrailingSlash(suffix)}, nil
+	}
+
+	if _, err := path.Match(pattern, ""); err != nil {
+		return pathPattern{}, err
+	}
+	return pathPattern{kind: matchGlob, value: trimTrailingSlash(pattern)}, nil
+}
+
+func (p pathPattern) match(requestPath string) bool {
+	requestPath = trimTrailingSlash(requestPath)
+
+	switch p.kind {
+	case matchExact:
+		return requestPath == p.value
+	case matchPrefix:
+		return requestPath == p.value || strings.HasPrefix(requestPath, p.value+"/")
+	case matchSuffix:
+		return strings.HasSuffix(requestPath, p.value)
+	default:
+		matched, _ := path.Match(p.value, requestPath)
+		return matched
+	}
+}
+
+// containsPathPattern reports whether patterns holds p, compiled from the
+// same pattern.
+func containsPathPattern(patterns []pathPattern, p pathPattern) bool {
+	for _, q := range patterns {
+		if q == p {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAnyPath(patterns []pathPattern, requestPath string) bool {
+	for _, p := range patterns {
+		if p.match(requestPath) {
+			return true
+		}
+	}
+	return false
+}
+
+func trimTrailingSlash(p string) string {
+	if len(p) > 1 {
+		return strings.TrimSuffix(p, "/")
+	}
+	return p
+}
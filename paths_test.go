@@ -0,0 +1,99 @@
+package swissknife
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPathPatternMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{pattern: "/health", path: "/health", want: true},
+		{pattern: "/health", path: "/health/", want: true},
+		{pattern: "/health/", path: "/health", want: true},
+		{pattern: "/health", path: "/healthz", want: false},
+		{pattern: "/health", path: "/health/live", want: false},
+		{pattern: "/public/*", path: "/public", want: true},
+		{pattern: "/public/*", path: "/public/", want: true},
+		{pattern: "/public/*", path: "/public/css/site.css", want: true},
+		{pattern: "/public/*", path: "/publicity", want: false},
+		{pattern: "*.css", path: "/public/site.css", want: true},
+		{pattern: "*.css", path: "/public/site.css/", want: true},
+		{pattern: "*.css", path: "/public/site.js", want: false},
+		{pattern: "/v?/status", path: "/v1/status", want: true},
+		{pattern: "/v?/status", path: "/v1/status/", want: true},
+		{pattern: "/v?/status", path: "/v10/status", want: false},
+		{pattern: "/", path: "/", want: true},
+		{pattern: "/", path: "/orders", want: false},
+	}
+	for _, test := range tests {
+		t.Run(test.pattern+" "+test.path, func(t *testing.T) {
+			p, err := compilePathPattern(test.pattern)
+			if err != nil {
+				t.Fatalf("compilePathPattern() error = %v", err)
+			}
+			if got := p.match(test.path); got != test.want {
+				t.Errorf("match() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestInvalidPathPatterns(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    string
+	}{
+		{pattern: "", want: "pattern must not be empty"},
+		{pattern: "health", want: "pattern must start with / or *"},
+		{pattern: "/v[1/status", want: "syntax error in pattern"},
+	}
+	for _, test := range tests {
+		t.Run(test.pattern, func(t *testing.T) {
+			config := CreateConfig()
+			config.Keys = []string{"secret-key-1"}
+			config.ExcludedPaths = []string{test.pattern}
+			_, err := New(context.Background(), noopHandler, config, "test")
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("New() error = %v, want %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestExcludedPaths(t *testing.T) {
+	config := CreateConfig()
+	config.Keys = []string{"secret-key-1"}
+	config.ExcludedPaths = []string{"/health", "/public/*", "/robots.txt"}
+	ka := newTestHandler(t, config, nil)
+
+	tests := []struct {
+		target string
+		want   int
+	}{
+		{target: "/health", want: http.StatusOK},
+		{target: "/health/", want: http.StatusOK},
+		{target: "/health?verbose=1", want: http.StatusOK},
+		{target: "/health/?verbose=1", want: http.StatusOK},
+		{target: "/robots.txt?x=/orders", want: http.StatusOK},
+		{target: "/public/index.html?v=2", want: http.StatusOK},
+		{target: "/orders?path=/health", want: http.StatusForbidden},
+		{target: "/healthz", want: http.StatusForbidden},
+		{target: "/publicity", want: http.StatusForbidden},
+	}
+	for _, test := range tests {
+		t.Run(test.target, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			ka.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, test.target, nil))
+			if rec.Code != test.want {
+				t.Errorf("status code = %d, want %d", rec.Code, test.want)
+			}
+		})
+	}
+}
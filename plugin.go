@@ -2,30 +2,190 @@
 package swissknife
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"net/http"
-	"os"
+	"net/netip"
+	"net/url"
+	"strconv"
 	"strings"
+	"sync"
+	"text/template"
+	"time"
 )
 
 //nolint:all
 type Config struct {
-	AuthenticationHeader     bool     `json:"authenticationHeader,omitempty"`
-	AuthenticationHeaderName string   `json:"headerName,omitempty"`
-	BearerHeader             bool     `json:"bearerHeader,omitempty"`
-	BearerHeaderName         string   `json:"bearerHeaderName,omitempty"`
-	Keys                     []string `json:"keys,omitempty"`
-	RemoveHeadersOnSuccess   bool     `json:"removeHeadersOnSuccess,omitempty"`
-	EnableLog                bool     `json:"enableLog,omitempty"`
+	AuthenticationHeader            bool              `json:"authenticationHeader,omitempty"`
+	AuthenticationHeaderName        string            `json:"headerName,omitempty"`
+	AuthenticationHeaderNames       []string          `json:"authenticationHeaderNames,omitempty"`
+	BearerHeader                    bool              `json:"bearerHeader,omitempty"`
+	BearerHeaderName                string            `json:"bearerHeaderName,omitempty"`
+	BearerSchemes                   []string          `json:"bearerSchemes,omitempty"`
+	BasicAuth                       bool              `json:"basicAuth,omitempty"`
+	BodyAuth                        BodyAuth          `json:"bodyAuth,omitempty"`
+	Rfc6750Sources                  bool              `json:"rfc6750Sources,omitempty"`
+	QueryParam                      bool              `json:"queryParam,omitempty"`
+	QueryParamName                  string            `json:"queryParamName,omitempty"`
+	Cookie                          bool              `json:"cookie,omitempty"`
+	CookieName                      string            `json:"cookieName,omitempty"`
+	WebSocketProtocolAuth           bool              `json:"webSocketProtocolAuth,omitempty"`
+	ExtractorOrder                  []string          `json:"extractorOrder,omitempty"`
+	TryAllExtractors                bool              `json:"tryAllExtractors,omitempty"`
+	SourceOrder                     []string          `json:"sourceOrder,omitempty"`
+	ExhaustiveSearch                bool              `json:"exhaustiveSearch,omitempty"`
+	StrictConflicts                 bool              `json:"strictConflicts,omitempty"`
+	SignatureAuth                   SignatureAuth     `json:"signatureAuth,omitempty"`
+	JWT                             JWT               `json:"jwt,omitempty"`
+	SignedTokens                    SignedTokens      `json:"signedTokens,omitempty"`
+	ClientCertAuth                  ClientCertAuth    `json:"clientCertAuth,omitempty"`
+	Keys                            []string          `json:"keys,omitempty"`
+	KeysCSV                         string            `json:"keysCSV,omitempty"`
+	KeyEntries                      []KeyEntry        `json:"keyEntries,omitempty"`
+	KeyPatterns                     []string          `json:"keyPatterns,omitempty"`
+	KeyGroups                       []KeyGroup        `json:"keyGroups,omitempty"`
+	ConsumerHeader                  string            `json:"consumerHeader,omitempty"`
+	KongCompatibleHeaders           bool              `json:"kongCompatibleHeaders,omitempty"`
+	KeyPrefixes                     []string          `json:"keyPrefixes,omitempty"`
+	ForwardPrefixHeader             string            `json:"forwardPrefixHeader,omitempty"`
+	UpstreamToken                   string            `json:"upstreamToken,omitempty"`
+	UpstreamTokenHeader             string            `json:"upstreamTokenHeader,omitempty"`
+	UpstreamTokenScheme             string            `json:"upstreamTokenScheme,omitempty"`
+	KeysFile                        string            `json:"keysFile,omitempty"`
+	KeysDir                         string            `json:"keysDir,omitempty"`
+	RevokedKeys                     []string          `json:"revokedKeys,omitempty"`
+	RevokedKeysFile                 string            `json:"revokedKeysFile,omitempty"`
+	ReloadInterval                  string            `json:"reloadInterval,omitempty"`
+	DeprecationGracePeriod          string            `json:"deprecationGracePeriod,omitempty"`
+	HashedKeys                      bool              `json:"hashedKeys,omitempty"`
+	MaxBcryptCost                   int               `json:"maxBcryptCost,omitempty"`
+	MinKeyLength                    int               `json:"minKeyLength,omitempty"`
+	TrimKeys                        bool              `json:"trimKeys,omitempty"`
+	MaxCredentialLength             int               `json:"maxCredentialLength,omitempty"`
+	RequireKeyEntropy               bool              `json:"requireKeyEntropy,omitempty"`
+	AllowWeakKeys                   bool              `json:"allowWeakKeys,omitempty"`
+	RemoveHeadersOnSuccess          bool              `json:"removeHeadersOnSuccess,omitempty"`
+	RemoveAllCredentialHeaders      bool              `json:"removeAllCredentialHeaders,omitempty"`
+	ExcludedPaths                   []string          `json:"excludedPaths,omitempty"`
+	ProtectedPaths                  []string          `json:"protectedPaths,omitempty"`
+	AllowPreflight                  bool              `json:"allowPreflight,omitempty"`
+	BypassMethods                   []string          `json:"bypassMethods,omitempty"`
+	ProtectedMethods                []string          `json:"protectedMethods,omitempty"`
+	AllowedCIDRs                    []string          `json:"allowedCIDRs,omitempty"`
+	ForwardedDepth                  int               `json:"forwardedDepth,omitempty"`
+	TrustedProxies                  []string          `json:"trustedProxies,omitempty"`
+	ClientIPHeader                  string            `json:"clientIPHeader,omitempty"`
+	RequireTLS                      bool              `json:"requireTLS,omitempty"`
+	UnauthorizedStatusCode          int               `json:"unauthorizedStatusCode,omitempty"`
+	UnauthorizedMessage             string            `json:"unauthorizedMessage,omitempty"`
+	DistinguishMissingCredential    bool              `json:"distinguishMissingCredential,omitempty"`
+	MissingCredentialMessage        string            `json:"missingCredentialMessage,omitempty"`
+	MissingCredentialStatusCode     int               `json:"missingCredentialStatusCode,omitempty"`
+	ErrorMessages                   map[string]string `json:"errorMessages,omitempty"`
+	DefaultLanguage                 string            `json:"defaultLanguage,omitempty"`
+	Realm                           string            `json:"realm,omitempty"`
+	Rfc6750Compliant                bool              `json:"rfc6750Compliant,omitempty"`
+	StealthMode                     bool              `json:"stealthMode,omitempty"`
+	ForwardAuthMode                 bool              `json:"forwardAuthMode,omitempty"`
+	ReportOnly                      bool              `json:"reportOnly,omitempty"`
+	Optional                        bool              `json:"optional,omitempty"`
+	MetricsPath                     string            `json:"metricsPath,omitempty"`
+	MetricsPublic                   bool              `json:"metricsPublic,omitempty"`
+	MetricsMaxKeys                  int               `json:"metricsMaxKeys,omitempty"`
+	ErrorFormat                     string            `json:"errorFormat,omitempty"`
+	ProblemType                     string            `json:"problemType,omitempty"`
+	ErrorBodyTemplate               string            `json:"errorBodyTemplate,omitempty"`
+	ErrorContentType                string            `json:"errorContentType,omitempty"`
+	FailureResponseHeaders          map[string]string `json:"failureResponseHeaders,omitempty"`
+	FailureCORS                     FailureCORS       `json:"failureCORS,omitempty"`
+	RedirectOnFailure               string            `json:"redirectOnFailure,omitempty"`
+	RedirectOnlyForBrowsers         bool              `json:"redirectOnlyForBrowsers,omitempty"`
+	RedirectAllowedHosts            []string          `json:"redirectAllowedHosts,omitempty"`
+	FailureDelay                    string            `json:"failureDelay,omitempty"`
+	MaxFailures                     int               `json:"maxFailures,omitempty"`
+	FailureWindow                   string            `json:"failureWindow,omitempty"`
+	BanDuration                     string            `json:"banDuration,omitempty"`
+	MaxTrackedClients               int               `json:"maxTrackedClients,omitempty"`
+	ValidationURL                   string            `json:"validationURL,omitempty"`
+	ValidationMethod                string            `json:"validationMethod,omitempty"`
+	ValidationHeader                string            `json:"validationHeader,omitempty"`
+	ValidationTimeout               string            `json:"validationTimeout,omitempty"`
+	ValidationRetries               int               `json:"validationRetries,omitempty"`
+	ValidationRetryBackoff          string            `json:"validationRetryBackoff,omitempty"`
+	ValidationMaxDuration           string            `json:"validationMaxDuration,omitempty"`
+	ValidationTLS                   ValidationTLS     `json:"validationTLS,omitempty"`
+	ValidationMaxIdleConns          int               `json:"validationMaxIdleConns,omitempty"`
+	ValidationIdleConnTimeout       string            `json:"validationIdleConnTimeout,omitempty"`
+	ValidationUnavailableStatusCode int               `json:"validationUnavailableStatusCode,omitempty"`
+	FailurePolicy                   string            `json:"failurePolicy,omitempty"`
+	CircuitBreakerThreshold         int               `json:"circuitBreakerThreshold,omitempty"`
+	CircuitBreakerCooldown          string            `json:"circuitBreakerCooldown,omitempty"`
+	CacheTTL                        string            `json:"cacheTTL,omitempty"`
+	NegativeCacheTTL                string            `json:"negativeCacheTTL,omitempty"`
+	CacheMaxEntries                 int               `json:"cacheMaxEntries,omitempty"`
+	EnableLog                       bool              `json:"enableLog,omitempty"`
+	LogFormat                       string            `json:"logFormat,omitempty"`
+	LogLevel                        string            `json:"logLevel,omitempty"`
+	LogSink                         string            `json:"logSink,omitempty"`
+	LogSampleRate                   float64           `json:"logSampleRate,omitempty"`
+	MaxLogLinesPerSecond            int               `json:"maxLogLinesPerSecond,omitempty"`
+	LogKeyFingerprint               bool              `json:"logKeyFingerprint,omitempty"`
+	AuditLog                        bool              `json:"auditLog,omitempty"`
+	AccessLog                       bool              `json:"accessLog,omitempty"`
+	AccessLogFormat                 string            `json:"accessLogFormat,omitempty"`
+	RecoverPanics                   bool              `json:"recoverPanics,omitempty"`
+	TraceHeaders                    bool              `json:"traceHeaders,omitempty"`
+	FailureWebhook                  FailureWebhook    `json:"failureWebhook,omitempty"`
+	UsageReportURL                  string            `json:"usageReportUrl,omitempty"`
+	UsageFlushInterval              string            `json:"usageFlushInterval,omitempty"`
+	UsageFlushEvents                int               `json:"usageFlushEvents,omitempty"`
+	UsageBufferSize                 int               `json:"usageBufferSize,omitempty"`
+	MaintenanceMode                 bool              `json:"maintenanceMode,omitempty"`
+	MaintenanceFile                 string            `json:"maintenanceFile,omitempty"`
+	MaintenanceCheckInterval        string            `json:"maintenanceCheckInterval,omitempty"`
+	MaintenanceMessage              string            `json:"maintenanceMessage,omitempty"`
+	MaintenanceRetryAfter           string            `json:"maintenanceRetryAfter,omitempty"`
+}
+
+//nolint:all
+type KeyEntry struct {
+	Name               string            `json:"name,omitempty"`
+	Key                string            `json:"key,omitempty"`
+	Paths              []string          `json:"paths,omitempty"`
+	Methods            []string          `json:"methods,omitempty"`
+	ExpiresAt          string            `json:"expiresAt,omitempty"`
+	Deprecated         bool              `json:"deprecated,omitempty"`
+	DeprecatedAfter    string            `json:"deprecatedAfter,omitempty"`
+	AllowedCIDRs       []string          `json:"allowedCIDRs,omitempty"`
+	Hosts              []string          `json:"hosts,omitempty"`
+	AllowedOrigins     []string          `json:"allowedOrigins,omitempty"`
+	AllowMissingOrigin bool              `json:"allowMissingOrigin,omitempty"`
+	AccessWindows      []AccessWindow    `json:"accessWindows,omitempty"`
+	Quota              Quota             `json:"quota,omitempty"`
+	RequireTLS         bool              `json:"requireTLS,omitempty"`
+	Headers            map[string]string `json:"headers,omitempty"`
+	UpstreamToken      string            `json:"upstreamToken,omitempty"`
 }
 
 //nolint:all
 type Response struct {
 	Message    string `json:"message"`
 	StatusCode int    `json:"statusCode"`
+	TraceID    string `json:"traceId,omitempty"`
+}
+
+//nolint:all
+type ProblemResponse struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	TraceID  string `json:"traceId,omitempty"`
 }
 
 //nolint:all
@@ -35,115 +195,1341 @@ func CreateConfig() *Config {
 		AuthenticationHeaderName: "X-API-KEY",
 		BearerHeader:             true,
 		BearerHeaderName:         "Authorization",
-		Keys:                     []string{},
-		RemoveHeadersOnSuccess:   true,
-		EnableLog:                false,
+		BearerSchemes:            []string{"Bearer"},
+		BasicAuth:                false,
+		Rfc6750Sources:           false,
+		BodyAuth: BodyAuth{
+			ContentType:  "application/json",
+			MaxBodyBytes: 1 << 20,
+			Methods:      []string{"POST", "PUT", "PATCH"},
+		},
+		QueryParam:            false,
+		QueryParamName:        "api_key",
+		Cookie:                false,
+		CookieName:            "",
+		WebSocketProtocolAuth: false,
+		ExtractorOrder:        []string{},
+		TryAllExtractors:      false,
+		SourceOrder:           []string{},
+		ExhaustiveSearch:      false,
+		StrictConflicts:       false,
+		SignatureAuth: SignatureAuth{
+			SignatureHeader: "X-Signature",
+			TimestampHeader: "X-Timestamp",
+			ClockSkew:       "5m",
+			MaxBodySize:     1 << 20,
+			NonceHeader:     "X-Nonce",
+			RequireNonce:    false,
+			MaxNonces:       10000,
+		},
+		JWT: JWT{
+			HS256Secret:            "",
+			JwksRefreshInterval:    "1h",
+			JwksMinRefreshInterval: "1m",
+			JwksMaxAge:             "24h",
+		},
+		SignedTokens: SignedTokens{
+			Secret: "",
+			MaxAge: "1h",
+		},
+		ClientCertAuth: ClientCertAuth{
+			Fingerprints:   []string{},
+			SANs:           []string{},
+			Header:         "",
+			HeaderVerified: false,
+		},
+		Keys:                         []string{},
+		KeysCSV:                      "",
+		KeyEntries:                   []KeyEntry{},
+		KeyPatterns:                  []string{},
+		KeyGroups:                    []KeyGroup{},
+		ConsumerHeader:               "X-Consumer-Name",
+		KongCompatibleHeaders:        false,
+		KeyPrefixes:                  []string{},
+		ForwardPrefixHeader:          "X-Key-Environment",
+		UpstreamToken:                "",
+		UpstreamTokenHeader:          "Authorization",
+		UpstreamTokenScheme:          "Bearer",
+		KeysFile:                     "",
+		KeysDir:                      "",
+		RevokedKeys:                  []string{},
+		RevokedKeysFile:              "",
+		ReloadInterval:               "",
+		DeprecationGracePeriod:       "168h",
+		HashedKeys:                   false,
+		MaxBcryptCost:                12,
+		MinKeyLength:                 0,
+		TrimKeys:                     false,
+		MaxCredentialLength:          512,
+		RequireKeyEntropy:            false,
+		AllowWeakKeys:                false,
+		RemoveHeadersOnSuccess:       true,
+		RemoveAllCredentialHeaders:   false,
+		ExcludedPaths:                []string{},
+		ProtectedPaths:               []string{},
+		AllowPreflight:               false,
+		BypassMethods:                []string{},
+		ProtectedMethods:             []string{},
+		AllowedCIDRs:                 []string{},
+		ForwardedDepth:               0,
+		TrustedProxies:               []string{},
+		ClientIPHeader:               "X-Forwarded-For",
+		RequireTLS:                   false,
+		UnauthorizedStatusCode:       http.StatusForbidden,
+		UnauthorizedMessage:          "Invalid API Key",
+		DistinguishMissingCredential: false,
+		MissingCredentialMessage:     "Missing API key",
+		MissingCredentialStatusCode:  http.StatusUnauthorized,
+		ErrorMessages:                map[string]string{},
+		DefaultLanguage:              "",
+		Realm:                        "api",
+		Rfc6750Compliant:             false,
+		StealthMode:                  false,
+		ForwardAuthMode:              false,
+		ReportOnly:                   false,
+		Optional:                     false,
+		MetricsPath:                  "",
+		MetricsPublic:                false,
+		MetricsMaxKeys:               1000,
+		ErrorFormat:                  "simple",
+		ProblemType:                  "about:blank",
+		ErrorBodyTemplate:            "",
+		ErrorContentType:             "application/json; charset=utf-8",
+		FailureResponseHeaders:       map[string]string{},
+		FailureCORS: FailureCORS{
+			AllowedOrigins:   []string{},
+			AllowCredentials: false,
+		},
+		RedirectOnFailure:       "",
+		RedirectOnlyForBrowsers: true,
+		RedirectAllowedHosts:    []string{},
+		FailureDelay:            "0s",
+		MaxFailures:             0,
+		FailureWindow:           "1m",
+		BanDuration:             "10m",
+		MaxTrackedClients:       10000,
+		ValidationURL:           "",
+		ValidationMethod:        http.MethodPost,
+		ValidationHeader:        "X-API-KEY",
+		ValidationTimeout:       "5s",
+		ValidationRetries:       0,
+		ValidationRetryBackoff:  "100ms",
+		ValidationMaxDuration:   "",
+		ValidationTLS: ValidationTLS{
+			ReloadInterval: "1m",
+		},
+		ValidationMaxIdleConns:          100,
+		ValidationIdleConnTimeout:       "90s",
+		ValidationUnavailableStatusCode: http.StatusServiceUnavailable,
+		FailurePolicy:                   "closed",
+		CircuitBreakerThreshold:         0,
+		CircuitBreakerCooldown:          "30s",
+		CacheTTL:                        "",
+		NegativeCacheTTL:                "",
+		CacheMaxEntries:                 1000,
+		EnableLog:                       false,
+		LogFormat:                       "text",
+		LogLevel:                        "debug",
+		LogSink:                         "stdout",
+		LogSampleRate:                   1,
+		MaxLogLinesPerSecond:            0,
+		LogKeyFingerprint:               false,
+		AuditLog:                        false,
+		AccessLog:                       false,
+		AccessLogFormat:                 "common",
+		RecoverPanics:                   false,
+		TraceHeaders:                    false,
+		FailureWebhook: FailureWebhook{
+			MinInterval: "10s",
+			BatchSize:   100,
+		},
+		UsageReportURL:           "",
+		UsageFlushInterval:       "10s",
+		UsageFlushEvents:         500,
+		UsageBufferSize:          10000,
+		MaintenanceMode:          false,
+		MaintenanceFile:          "",
+		MaintenanceCheckInterval: "5s",
+		MaintenanceMessage:       "Service under maintenance",
+		MaintenanceRetryAfter:    "5m",
 	}
 }
 
 //nolint:all
 type SwissKnife struct {
-	next                     http.Handler
-	authenticationHeader     bool
-	authenticationHeaderName string
-	bearerHeader             bool
-	bearerHeaderName         string
-	keys                     map[string]struct{}
-	removeHeadersOnSuccess   bool
-	enableLog                bool
+	next                            http.Handler
+	extractors                      []Extractor
+	tryAllExtractors                bool
+	strictConflicts                 bool
+	rfc6750Sources                  bool
+	requireTLS                      bool
+	quotas                          QuotaCounter
+	signatures                      *signatureVerifier
+	jwt                             *jwtVerifier
+	signedTokens                    *signedTokenVerifier
+	clientCerts                     *clientCertVerifier
+	bearerHeader                    bool
+	bearerSchemes                   []string
+	static                          *StaticKeyStore
+	stores                          []KeyStore
+	keysMu                          sync.Mutex
+	cancel                          context.CancelFunc
+	background                      sync.WaitGroup
+	closeOnce                       sync.Once
+	staticKeys                      []string
+	keyEntries                      []KeyEntry
+	keyGroups                       []KeyGroup
+	consumerHeader                  string
+	kongHeaders                     bool
+	keyPrefixes                     []string
+	prefixHeader                    string
+	upstreamToken                   string
+	upstreamTokenHeader             string
+	upstreamTokenScheme             string
+	keysFile                        string
+	keysDir                         string
+	revokedKeys                     []string
+	revokedKeysFile                 string
+	hashedKeys                      bool
+	maxBcryptCost                   int
+	minKeyLength                    int
+	trimKeys                        bool
+	maxCredentialLength             int
+	requireKeyEntropy               bool
+	allowWeakKeys                   bool
+	removeHeadersOnSuccess          bool
+	removeAllCredentials            bool
+	excludedPaths                   []pathPattern
+	protectedPaths                  []pathPattern
+	allowPreflight                  bool
+	bypassMethods                   map[string]struct{}
+	protectedMethods                map[string]struct{}
+	allowedCIDRs                    []netip.Prefix
+	forwardedDepth                  int
+	trustedProxies                  []netip.Prefix
+	clientIPHeader                  string
+	unauthorizedStatusCode          int
+	unauthorizedMessage             string
+	distinguishMissing              bool
+	missingMessage                  string
+	errorMessages                   *errorMessages
+	missingStatusCode               int
+	realm                           string
+	rfc6750Compliant                bool
+	stealthMode                     bool
+	forwardAuthMode                 bool
+	deprecationGracePeriod          time.Duration
+	reportOnly                      bool
+	wouldDeny                       int64
+	optional                        bool
+	metrics                         *metrics
+	breaker                         *circuitBreaker
+	metricsPath                     string
+	metricsPublic                   bool
+	problemFormat                   bool
+	problemType                     string
+	errorBodyTemplate               *template.Template
+	errorContentType                string
+	failureHeaders                  http.Header
+	failureCORS                     *failureCORS
+	errorBodies                     map[Response]errorBody
+	redirectURL                     *url.URL
+	redirectOnlyForBrowsers         bool
+	failureDelay                    time.Duration
+	bans                            *banTracker
+	validationUnavailableStatusCode int
+	failOpen                        bool
+	logger                          *logger
+	now                             func() time.Time
+	logKeyFingerprint               bool
+	auditLog                        bool
+	recoverPanics                   bool
+	traceHeaders                    bool
+	webhook                         *failureWebhook
+	usage                           *usageReporter
+	maintenance                     *maintenanceSwitch
+	contextInfo                     bool
 }
 
 //nolint:all
 func New(ctx context.Context, next http.Handler, config *Config, name string) (http.Handler, error) {
-	if config.EnableLog {
-		_, _ = os.Stdout.WriteString(fmt.Sprintf("Creating plugin: %s instance: %+v, ctx: %+v\n", name, *config, ctx))
+	return NewWithOptions(ctx, next, config, name, Options{})
+}
+
+// NewWithKeyStores creates the plugin like New, additionally validating keys
+// against stores once the configured keys and validation endpoint did not
+// match.
+//
+//nolint:all
+func NewWithKeyStores(ctx context.Context, next http.Handler, config *Config, name string, stores ...KeyStore) (http.Handler, error) {
+	return NewWithOptions(ctx, next, config, name, Options{KeyStores: stores})
+}
+
+// Options extends the plugin when it is used as a library.
+//
+//nolint:all
+type Options struct {
+	// KeyStores are tried after the configured keys and validation endpoint.
+	KeyStores []KeyStore
+	// Extractors are tried after the configured sources.
+	Extractors []Extractor
+	// QuotaCounter counts the requests against the key quotas, in memory
+	// if nil.
+	QuotaCounter QuotaCounter
+	// ContextInfo attaches the AuthInfo and the client IP to the requests
+	// passed to the next handler, for FromContext and ClientIP. It is off
+	// by default, since attaching them allocates.
+	ContextInfo bool
+}
+
+// NewWithOptions creates the plugin like New, extended with options.
+//
+//nolint:all
+func NewWithOptions(ctx context.Context, next http.Handler, config *Config, name string, options Options) (http.Handler, error) {
+	if next == nil {
+		return nil, errors.New("next handler must not be nil")
+	}
+
+	logger, err := newLogger(config, name)
+	if err != nil {
+		return nil, err
+	}
+	logger.info(nil, "", fmt.Sprintf("Creating plugin: %s instance: %+v, ctx: %+v", name, config.redacted(), ctx))
+
+	config, err = expandConfigEnv(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := config.validate(options); err != nil {
+		return nil, err
+	}
+	for _, duplicate := range config.duplicateKeys() {
+		logger.warn(nil, "", duplicate)
+	}
+	for _, shadowed := range config.shadowedHeaders() {
+		logger.warn(nil, "", shadowed)
+	}
+
+	patterns, err := compileKeyPatterns(config.KeyPatterns)
+	if err != nil {
+		return nil, err
+	}
+
+	var errorBodyTemplate *template.Template
+	if config.ErrorBodyTemplate != "" {
+		errorBodyTemplate, err = template.New("errorBody").Option("missingkey=error").Parse(config.ErrorBodyTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("invalid error body template: %w", err)
+		}
+	}
+
+	failureHeaders, err := compileFailureHeaders(config.FailureResponseHeaders)
+	if err != nil {
+		return nil, err
+	}
+	failureCORS, err := newFailureCORS(config.FailureCORS)
+	if err != nil {
+		return nil, err
+	}
+	errorMessages, err := newErrorMessages(config.ErrorMessages, config.DefaultLanguage, config.UnauthorizedMessage)
+	if err != nil {
+		return nil, err
+	}
+
+	var redirectURL *url.URL
+	if config.RedirectOnFailure != "" {
+		redirectURL, err = parseRedirectURL(config.RedirectOnFailure, config.RedirectAllowedHosts)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redirect on failure: %w", err)
+		}
+	}
+
+	extractors, err := newExtractors(config, authenticationHeaderNames(config))
+	if err != nil {
+		return nil, err
+	}
+	extractors = append(extractors, options.Extractors...)
+
+	signatures, err := newSignatureVerifier(config.SignatureAuth)
+	if err != nil {
+		return nil, err
+	}
+
+	jwt, err := newJWTVerifier(config)
+	if err != nil {
+		return nil, err
+	}
+
+	signedTokens, err := newSignedTokenVerifier(config)
+	if err != nil {
+		return nil, err
+	}
+
+	clientCerts, err := newClientCertVerifier(config.ClientCertAuth)
+	if err != nil {
+		return nil, err
+	}
+
+	deprecationGracePeriod, err := time.ParseDuration(config.DeprecationGracePeriod)
+	if err != nil {
+		return nil, fmt.Errorf("invalid deprecation grace period: %w", err)
+	}
+	if deprecationGracePeriod < 0 {
+		return nil, errors.New("deprecation grace period must not be negative")
+	}
+
+	var reloadInterval time.Duration
+	if config.ReloadInterval != "" {
+		reloadInterval, err = time.ParseDuration(config.ReloadInterval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid reload interval: %w", err)
+		}
+		if reloadInterval < 0 {
+			return nil, errors.New("reload interval must not be negative")
+		}
+	}
+
+	excludedPaths, err := compilePathPatterns(config.ExcludedPaths)
+	if err != nil {
+		return nil, fmt.Errorf("invalid excluded paths: %w", err)
+	}
+	protectedPaths, err := compilePathPatterns(config.ProtectedPaths)
+	if err != nil {
+		return nil, fmt.Errorf("invalid protected paths: %w", err)
+	}
+
+	bypassMethods, err := methodSet(config.BypassMethods)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bypass methods: %w", err)
+	}
+
+	protectedMethods, err := methodSet(config.ProtectedMethods)
+	if err != nil {
+		return nil, fmt.Errorf("invalid protected methods: %w", err)
+	}
+
+	allowedCIDRs, err := parsePrefixes(config.AllowedCIDRs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid allowed CIDRs: %w", err)
+	}
+
+	trustedProxies, err := parsePrefixes(config.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trusted proxies: %w", err)
+	}
+
+	var failureDelay time.Duration
+	if config.FailureDelay != "" {
+		failureDelay, err = time.ParseDuration(config.FailureDelay)
+		if err != nil {
+			return nil, fmt.Errorf("invalid failure delay: %w", err)
+		}
+		if failureDelay < 0 {
+			return nil, errors.New("failure delay must not be negative")
+		}
+	}
+
+	bans, err := newBanTrackerFromConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	remote, err := newRemoteValidator(config)
+	if err != nil {
+		return nil, err
 	}
 
-	// Check for empty keys
-	if len(config.Keys) == 0 {
-		return nil, errors.New("must specify at least one valid key")
+	cache, err := newCacheFromConfig(config)
+	if err != nil {
+		return nil, err
 	}
 
-	// Check at least one header is set
-	if !config.AuthenticationHeader && !config.BearerHeader {
-		return nil, errors.New("at least one header type must be true")
+	breaker, err := newCircuitBreakerFromConfig(config, logger)
+	if err != nil {
+		return nil, err
 	}
 
-	keysMap := make(map[string]struct{})
-	for _, key := range config.Keys {
-		keysMap[key] = struct{}{}
+	metrics, err := newMetricsFromConfig(config, name)
+	if err != nil {
+		return nil, err
 	}
 
-	return &SwissKnife{
-		next:                     next,
-		authenticationHeader:     config.AuthenticationHeader,
-		authenticationHeaderName: config.AuthenticationHeaderName,
-		bearerHeader:             config.BearerHeader,
-		bearerHeaderName:         config.BearerHeaderName,
-		keys:                     keysMap,
-		removeHeadersOnSuccess:   config.RemoveHeadersOnSuccess,
-		enableLog:                config.EnableLog,
-	}, nil
+	webhook, err := newFailureWebhook(config.FailureWebhook, name, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	usage, err := newUsageReporterFromConfig(config, name, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	maintenance, err := newMaintenanceSwitchFromConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	quotas := options.QuotaCounter
+	if quotas == nil {
+		quotas = newMemoryQuotaCounter()
+	}
+
+	ka := &SwissKnife{
+		next:                            next,
+		extractors:                      extractors,
+		tryAllExtractors:                config.TryAllExtractors || config.ExhaustiveSearch,
+		contextInfo:                     options.ContextInfo,
+		strictConflicts:                 config.StrictConflicts,
+		rfc6750Sources:                  config.Rfc6750Sources,
+		quotas:                          quotas,
+		requireTLS:                      config.RequireTLS,
+		signatures:                      signatures,
+		jwt:                             jwt,
+		signedTokens:                    signedTokens,
+		clientCerts:                     clientCerts,
+		bearerHeader:                    config.BearerHeader,
+		bearerSchemes:                   config.BearerSchemes,
+		staticKeys:                      config.Keys,
+		keyEntries:                      config.KeyEntries,
+		keyGroups:                       config.KeyGroups,
+		consumerHeader:                  http.CanonicalHeaderKey(config.ConsumerHeader),
+		kongHeaders:                     config.KongCompatibleHeaders,
+		keyPrefixes:                     config.KeyPrefixes,
+		prefixHeader:                    http.CanonicalHeaderKey(config.ForwardPrefixHeader),
+		upstreamToken:                   config.UpstreamToken,
+		upstreamTokenHeader:             http.CanonicalHeaderKey(config.UpstreamTokenHeader),
+		upstreamTokenScheme:             config.UpstreamTokenScheme,
+		keysFile:                        config.KeysFile,
+		keysDir:                         config.KeysDir,
+		revokedKeys:                     config.RevokedKeys,
+		revokedKeysFile:                 config.RevokedKeysFile,
+		hashedKeys:                      config.HashedKeys,
+		maxBcryptCost:                   config.MaxBcryptCost,
+		minKeyLength:                    config.MinKeyLength,
+		trimKeys:                        config.TrimKeys,
+		maxCredentialLength:             config.MaxCredentialLength,
+		requireKeyEntropy:               config.RequireKeyEntropy,
+		allowWeakKeys:                   config.AllowWeakKeys,
+		removeHeadersOnSuccess:          config.RemoveHeadersOnSuccess,
+		removeAllCredentials:            config.RemoveAllCredentialHeaders,
+		excludedPaths:                   excludedPaths,
+		protectedPaths:                  protectedPaths,
+		allowPreflight:                  config.AllowPreflight,
+		bypassMethods:                   bypassMethods,
+		protectedMethods:                protectedMethods,
+		allowedCIDRs:                    allowedCIDRs,
+		forwardedDepth:                  config.ForwardedDepth,
+		trustedProxies:                  trustedProxies,
+		clientIPHeader:                  http.CanonicalHeaderKey(config.ClientIPHeader),
+		unauthorizedStatusCode:          config.UnauthorizedStatusCode,
+		unauthorizedMessage:             config.UnauthorizedMessage,
+		distinguishMissing:              config.DistinguishMissingCredential,
+		missingMessage:                  config.MissingCredentialMessage,
+		errorMessages:                   errorMessages,
+		missingStatusCode:               config.MissingCredentialStatusCode,
+		realm:                           config.Realm,
+		rfc6750Compliant:                config.Rfc6750Compliant,
+		stealthMode:                     config.StealthMode,
+		forwardAuthMode:                 config.ForwardAuthMode,
+		deprecationGracePeriod:          deprecationGracePeriod,
+		reportOnly:                      config.ReportOnly,
+		optional:                        config.Optional,
+		metrics:                         metrics,
+		breaker:                         breaker,
+		metricsPath:                     config.MetricsPath,
+		metricsPublic:                   config.MetricsPublic,
+		problemFormat:                   config.ErrorFormat == "problem",
+		problemType:                     config.ProblemType,
+		errorBodyTemplate:               errorBodyTemplate,
+		errorContentType:                config.ErrorContentType,
+		failureHeaders:                  failureHeaders,
+		failureCORS:                     failureCORS,
+		redirectURL:                     redirectURL,
+		redirectOnlyForBrowsers:         config.RedirectOnlyForBrowsers,
+		failureDelay:                    failureDelay,
+		bans:                            bans,
+		validationUnavailableStatusCode: config.ValidationUnavailableStatusCode,
+		failOpen:                        config.FailurePolicy == "open",
+		logger:                          logger,
+		now:                             time.Now,
+		logKeyFingerprint:               config.LogKeyFingerprint,
+		auditLog:                        config.AuditLog,
+		recoverPanics:                   config.RecoverPanics,
+		traceHeaders:                    config.TraceHeaders,
+		webhook:                         webhook,
+		usage:                           usage,
+		maintenance:                     maintenance,
+	}
+
+	if err := ka.precomputeErrorBodies(); err != nil {
+		return nil, err
+	}
+
+	// The configured keys are checked first, then the key patterns, then the
+	// remote endpoint, then the stores of the caller.
+	ka.static = &StaticKeyStore{}
+	ka.stores = []KeyStore{ka.static}
+	if patterns != nil {
+		ka.stores = append(ka.stores, patterns)
+	}
+	ka.logger.clientIP = ka.requestIP
+	if remote != nil {
+		ka.stores = append(ka.stores, &remoteKeyStore{validator: remote, cache: cache, breaker: breaker, logger: logger})
+	}
+	ka.stores = append(ka.stores, options.KeyStores...)
+
+	keys, err := ka.loadKeys()
+	if err != nil {
+		return nil, err
+	}
+	ka.static.set(keys)
+	if n := keys.revokedAndAllowed(); n > 0 {
+		ka.logger.warn(nil, "", fmt.Sprintf("Keys both allowed and revoked, which will be rejected: %d", n))
+	}
+
+	for i, pattern := range protectedPaths {
+		if containsPathPattern(excludedPaths, pattern) {
+			ka.logger.warn(nil, "", fmt.Sprintf("Path both excluded and protected, which will require a key: %s", config.ProtectedPaths[i]))
+		}
+	}
+
+	// Background work stops when ctx is done, as when Traefik replaces the
+	// middleware, or on Close.
+	ctx, ka.cancel = context.WithCancel(ctx)
+	if (ka.keysFile != "" || ka.keysDir != "" || ka.revokedKeysFile != "") && reloadInterval > 0 {
+		ka.goBackground(ctx, func(ctx context.Context) { ka.reloadKeys(ctx, reloadInterval) })
+	}
+	if ka.bans != nil {
+		ka.goBackground(ctx, ka.sweepBans)
+	}
+	if ka.webhook != nil {
+		ka.goBackground(ctx, ka.webhook.run)
+	}
+	if ka.usage != nil {
+		ka.goBackground(ctx, ka.usage.run)
+	}
+	go func() {
+		<-ctx.Done()
+		_ = ka.Close()
+	}()
+
+	return ka, nil
 }
 
-func contains(key string, validKeys map[string]struct{}) bool {
-	_, exists := validKeys[key]
-	return exists
+func usesUpstreamToken(config *Config) bool {
+	if config.UpstreamToken != "" {
+		return true
+	}
+	for _, entry := range config.KeyEntries {
+		if entry.UpstreamToken != "" {
+			return true
+		}
+	}
+	return false
 }
 
-func bearer(key string, validKeys map[string]struct{}) bool {
-	if !strings.HasPrefix(key, "Bearer ") {
-		return false
+func authenticationHeaderNames(config *Config) []string {
+	if len(config.AuthenticationHeaderNames) == 0 {
+		return []string{config.AuthenticationHeaderName}
 	}
-	extractedKey := strings.TrimPrefix(key, "Bearer ")
-	return contains(extractedKey, validKeys)
+	return config.AuthenticationHeaderNames
 }
 
 func (ka *SwissKnife) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
-	if ka.enableLog {
-		_, _ = os.Stdout.WriteString(fmt.Sprintf("Request: %s %s\n", req.Method, req.URL.String()))
+	if ka.logger.accessLog {
+		ka.serveLogged(rw, req)
+		return
+	}
+	ka.serve(rw, req, nil)
+}
+
+// serve handles a request. access, if not nil, is filled in for the access
+// log once the request is authenticated.
+func (ka *SwissKnife) serve(rw http.ResponseWriter, req *http.Request, access *accessEntry) {
+	if ka.forwardAuthMode {
+		req = forwardedRequest(req)
+	}
+	if ka.contextInfo {
+		if addr, err := ka.clientIP(req); err == nil {
+			req = withClientIP(req, addr)
+		}
+	}
+
+	ka.logger.debug(req, "", "Request")
+
+	if ka.metricsPublic && ka.isMetricsRequest(req) {
+		ka.responseMetrics(rw)
+		return
+	}
+
+	if ka.consumerHeader != "" {
+		req.Header.Del(ka.consumerHeader)
+	}
+	if len(ka.keyPrefixes) > 0 && ka.prefixHeader != "" {
+		req.Header.Del(ka.prefixHeader)
+	}
+	if ka.kongHeaders {
+		for _, name := range kongHeaders {
+			req.Header.Del(name)
+		}
+	}
+	if ka.reportOnly {
+		req.Header.Del(reportOnlyHeader)
+	}
+	if ka.optional || ka.failOpen {
+		req.Header.Del(authStatusHeader)
+	}
+	for name := range ka.static.current().headerNames {
+		req.Header.Del(name)
+	}
+
+	buf := credentialBuffers.Get().(*[]credential)
+	defer releaseCredentials(buf)
+	d := ka.decide(req, buf)
+	ka.metrics.record(d)
+	if ka.webhook != nil && !d.allowed() && d.outcome != outcomeMaintenance {
+		ka.notifyFailure(req, d)
+	}
+	if ka.reportOnly && !d.allowed() && d.outcome != outcomeMaintenance {
+		// Let the request through as if it were bypassed, flagging it for the
+		// upstream.
+		ka.reportWouldDeny(req, d)
+		req.Header.Set(reportOnlyHeader, "would-deny")
+		d = decision{outcome: outcomeBypassed}
+	}
+	if ka.traceHeaders {
+		rw.Header().Set(authDecisionHeader, authDecision(d))
+	}
+	if access != nil {
+		access.req, access.outcome, access.key = req, d.outcome, d.consumerName()
+		access.allowed, access.auth = d.allowed(), ka.now().Sub(access.start)
+	}
+	if d.deprecated {
+		rw.Header().Add("Warning", deprecationWarning(d.rotateBy))
+	}
+	if d.quota.limit > 0 {
+		ka.setQuotaHeaders(rw, d.quota)
+	}
+	switch {
+	case d.outcome == outcomeAuthorized && ka.isMetricsRequest(req):
+		ka.responseMetrics(rw)
+	case ka.forwardAuthMode && d.allowed():
+		ka.responseForwardAuth(rw, d)
+	case d.outcome == outcomeBypassed:
+		ka.serveNext(rw, req)
+	case d.outcome == outcomeAnonymous:
+		req.Header.Set(authStatusHeader, authStatusAnonymous)
+		if ka.kongHeaders {
+			setKongHeaders(req.Header, d)
+		}
+		ka.serveNext(rw, req)
+	case d.outcome == outcomeUnchecked:
+		req.Header.Set(authStatusHeader, authStatusUnchecked)
+		ka.serveNext(rw, req)
+	case d.outcome == outcomeAuthorized:
+		ka.forward(rw, req, d)
+	default:
+		ka.respond(rw, req, d)
+	}
+}
+
+// decision is the outcome of authenticating a request.
+type decision struct {
+	outcome     string
+	credentials []credential
+	matched     *credential
+	entry       *keyEntry
+	bannedUntil time.Time
+
+	// deprecated is set for a key being rotated out, which stops working at
+	// rotateBy if it is not zero.
+	deprecated bool
+	rotateBy   time.Time
+
+	// missing is set when the request was rejected for sending nothing in
+	// any source, as opposed to an invalid or empty key.
+	missing bool
+
+	// quota is the state of the quota of the key, if it has one.
+	quota quotaStatus
+}
+
+// decide authenticates req, logging and accounting for the outcome. It does
+// not modify req or write a response, so it is shared by the middleware and
+// forward auth modes. The credentials of req are collected in buf.
+func (ka *SwissKnife) decide(req *http.Request, buf *[]credential) decision {
+	if reason := ka.bypassReason(req); reason != "" {
+		if ka.logger.enabledFor(levelInfo) {
+			ka.logger.info(req, outcomeBypassed, fmt.Sprintf("Bypassed request (%s)", reason))
+		}
+		return decision{outcome: outcomeBypassed}
+	}
+
+	if ka.maintenance != nil && ka.maintenance.active(ka.now()) {
+		ka.logger.info(req, outcomeMaintenance, "Maintenance mode")
+		return decision{outcome: outcomeMaintenance}
+	}
+
+	// Keys sent over plain HTTP are rejected before being looked at, so they
+	// are never accepted even if valid.
+	if ka.requireTLS && !ka.isSecure(req) {
+		ka.logger.info(req, outcomeInsecure, "Insecure request")
+		return decision{outcome: outcomeInsecure}
+	}
+
+	if ka.bans != nil {
+		if addr, ok := ka.requestIP(req); ok {
+			until, banned, ended := ka.bans.banned(addr, ka.now())
+			if ended {
+				ka.logBanEnded(addr)
+			}
+			if banned {
+				ka.logger.debug(req, outcomeBanned, "Banned client")
+				return decision{outcome: outcomeBanned, bannedUntil: until}
+			}
+		}
+	}
+
+	if ka.signatures != nil && req.Header.Get(ka.signatures.header) != "" {
+		return ka.decideSignature(req)
+	}
+	if ka.clientCerts != nil {
+		if d, ok := ka.decideClientCert(req); ok {
+			return d
+		}
+	}
+
+	credentials, presented, oversized := ka.credentials(req, *buf)
+	if cap(credentials) > cap(*buf) {
+		*buf = credentials[:0]
+	}
+	if oversized != "" {
+		ka.logger.info(req, outcomeRejected, "Unauthorized request (credential too long)", logField{name: "source", value: oversized})
+		ka.recordFailure(req)
+		return decision{outcome: outcomeRejected}
+	}
+	if !presented && ka.optional {
+		ka.logger.info(req, outcomeAnonymous, "Anonymous request")
+		return decision{outcome: outcomeAnonymous}
+	}
+
+	// A revoked key is rejected even if it is also allowed, or would be
+	// accepted by the validation endpoint.
+	keys := ka.static.current()
+	for _, c := range credentials {
+		if c.value != "" && keys.revoked(c.value) {
+			ka.logger.info(req, outcomeRejected, "Unauthorized request (revoked)", ka.keyFingerprints(c)...)
+			ka.recordFailure(req)
+			return decision{outcome: outcomeRejected, credentials: credentials}
+		}
+	}
+
+	var matched *credential
+	var entry *keyEntry
+	var claims *jwtClaims
+	var err, jwtErr, signedErr error
+	keyCredentials := credentials
+	if ka.jwt != nil {
+		matched, claims, keyCredentials, jwtErr = ka.authorizeJWT(req.Context(), credentials)
+	}
+	if claims != nil {
+		entry = &keyEntry{name: claims.Sub}
+		if scope := ka.jwt.missingScope(claims); scope != "" {
+			if ka.logger.enabledFor(levelInfo) {
+				ka.logger.info(req, outcomeForbidden, fmt.Sprintf("Forbidden request (missing scope %q)", scope))
+			}
+			return decision{outcome: outcomeForbidden, credentials: credentials, matched: matched, entry: entry}
+		}
+	}
+	if matched == nil && ka.signedTokens != nil {
+		matched, entry, keyCredentials, signedErr = ka.authorizeSignedToken(keyCredentials)
+	}
+	if matched == nil {
+		matched, entry, err = ka.authorize(req.Context(), ka.withAllowedPrefix(keyCredentials))
+	}
+	if err != nil {
+		return ka.unavailable(req, credentials, err)
+	}
+	if matched == nil && errors.Is(jwtErr, errJWKSUnavailable) {
+		return ka.unavailable(req, credentials, jwtErr)
+	}
+
+	if matched == nil && (jwtErr != nil || signedErr != nil) {
+		var reason string
+		if jwtErr != nil {
+			reason = "invalid JWT: " + jwtErr.Error()
+		} else {
+			reason = "invalid signed token: " + signedErr.Error()
+		}
+		if ka.logger.enabledFor(levelInfo) {
+			ka.logger.info(req, outcomeInvalidToken, fmt.Sprintf("Unauthorized request (%s)", reason))
+		}
+		ka.recordFailure(req)
+		return decision{outcome: outcomeInvalidToken, credentials: credentials}
+	}
+	if matched == nil && !presented {
+		ka.logger.info(req, outcomeRejected, "Unauthorized request (missing key)")
+		ka.recordFailure(req)
+		return decision{outcome: outcomeRejected, missing: true}
+	}
+	if matched == nil {
+		ka.logger.info(req, outcomeRejected, "Unauthorized request", ka.keyFingerprints(credentials...)...)
+		ka.recordFailure(req)
+		return decision{outcome: outcomeRejected, credentials: credentials}
+	}
+
+	if ka.strictConflicts {
+		if d, conflict := ka.conflict(req, credentials, matched); conflict {
+			return d
+		}
+	}
+
+	d := decision{credentials: credentials, matched: matched, entry: entry}
+	if entry != nil && ka.keyExpired(entry) {
+		ka.logger.info(req, outcomeRejected, "Unauthorized request (expired key)", ka.keyFingerprints(*matched)...)
+		ka.recordFailure(req)
+		d.outcome = outcomeRejected
+		return d
+	}
+	if entry != nil && ka.keyRetired(entry) {
+		ka.logger.info(req, outcomeRejected, "Unauthorized request (deprecated key past its grace period)", ka.keyFingerprints(*matched)...)
+		ka.recordFailure(req)
+		d.outcome = outcomeRejected
+		return d
+	}
+
+	if reason := ka.keyPolicyViolation(req, entry); reason != "" {
+		if ka.logger.enabledFor(levelInfo) {
+			ka.logger.info(req, outcomeForbidden, fmt.Sprintf("Forbidden request (%s)", reason), ka.keyFingerprints(*matched)...)
+		}
+		d.outcome = outcomeForbidden
+		return d
+	}
+
+	if entry != nil {
+		d.deprecated, d.rotateBy = ka.keyDeprecation(entry)
+		if d.deprecated {
+			fields := ka.keyFingerprints(*matched)
+			if entry.name != "" {
+				fields = append(fields, logField{name: "consumer", value: entry.name})
+			}
+			ka.logger.warn(req, outcomeAuthorized, "Deprecated key used", fields...)
+		}
+	}
+
+	if entry != nil && entry.quota != nil {
+		status, err := ka.useQuota(entry)
+		if err != nil {
+			return ka.unavailable(req, credentials, err)
+		}
+		d.quota = status
+		if status.exceeded {
+			ka.logger.info(req, outcomeQuotaExceeded, "Quota exceeded", ka.keyFingerprints(*matched)...)
+			d.outcome = outcomeQuotaExceeded
+			return d
+		}
+	}
+
+	fields := ka.keyFingerprints(*matched)
+	if matched.user != "" {
+		fields = append(fields, logField{name: "user", value: matched.user})
+	}
+	ka.logger.info(req, outcomeAuthorized, "Authorized request", fields...)
+	ka.recordSuccess(req)
+	d.outcome = outcomeAuthorized
+	return d
+}
+
+// unavailable decides on a request whose key could not be validated because
+// of err, according to the failure policy. It never applies to invalid keys.
+func (ka *SwissKnife) unavailable(req *http.Request, credentials []credential, err error) decision {
+	if ka.failOpen {
+		ka.logger.warn(req, outcomeUnchecked, fmt.Sprintf("Forwarding unchecked request, error validating key: %s", err.Error()))
+		return decision{outcome: outcomeUnchecked}
+	}
+
+	ka.logger.error(req, outcomeUnavailable, fmt.Sprintf("Error validating key: %s", err.Error()))
+	return decision{outcome: outcomeUnavailable, credentials: credentials}
+}
+
+// forward passes an authorized request on to the next handler, without the
+// accepted credential and with the consumer identity.
+func (ka *SwissKnife) forward(rw http.ResponseWriter, req *http.Request, d decision) {
+	if ka.removeHeadersOnSuccess || ka.removeAllCredentials {
+		d.matched.strip(req)
+	}
+	if ka.removeAllCredentials {
+		// Other sources may hold credentials too, valid or not, that the
+		// upstream must not see.
+		for _, extractor := range ka.extractors {
+			extractor.Strip(req)
+		}
+	}
+	if name := d.consumerIdentity(); ka.consumerHeader != "" && name != "" {
+		req.Header.Set(ka.consumerHeader, name)
+	}
+	if ka.prefixHeader != "" {
+		if environment := ka.keyEnvironment(d.matched.value); environment != "" {
+			req.Header.Set(ka.prefixHeader, environment)
+		}
+	}
+	if ka.kongHeaders {
+		setKongHeaders(req.Header, d)
+	}
+	if d.entry != nil {
+		for name, value := range d.entry.headers {
+			req.Header.Set(name, value)
+		}
+	}
+	if token := ka.upstreamTokenValue(d); token != "" {
+		req.Header.Set(ka.upstreamTokenHeader, token)
+	}
+	if ka.optional {
+		req.Header.Set(authStatusHeader, authStatusAuthenticated)
+	}
+	if ka.contextInfo {
+		req = ka.withAuthInfo(req, d)
+	}
+
+	if ka.auditLog || ka.usage != nil {
+		wrapped, ok := rw.(*responseWriterWrapper)
+		if !ok {
+			wrapped = &responseWriterWrapper{ResponseWriter: rw}
+		}
+		ka.serveNext(wrapped, req)
+		if ka.auditLog {
+			ka.logger.audit(req, d.consumerName(), wrapped.statusCode())
+		}
+		if ka.usage != nil {
+			ka.reportUsage(req, d, wrapped.statusCode())
+		}
+		return
+	}
+	ka.serveNext(rw, req)
+}
+
+func (d decision) allowed() bool {
+	return d.outcome == outcomeAuthorized || d.outcome == outcomeBypassed || d.outcome == outcomeAnonymous || d.outcome == outcomeUnchecked
+}
+
+// upstreamTokenValue returns the value of the upstream token header for an
+// authorized request, preferring the token of the matched key entry.
+func (ka *SwissKnife) upstreamTokenValue(d decision) string {
+	token := ka.upstreamToken
+	if d.entry != nil && d.entry.upstreamToken != "" {
+		token = d.entry.upstreamToken
+	}
+	if token == "" || ka.upstreamTokenScheme == "" {
+		return token
+	}
+	return ka.upstreamTokenScheme + " " + token
+}
+
+func (d decision) consumerName() string {
+	if d.entry == nil {
+		return ""
+	}
+	return d.entry.name
+}
+
+// consumerIdentity is the identity sent in the consumer header: the consumer
+// name, or the user name of a Basic credential with an unnamed key.
+func (d decision) consumerIdentity() string {
+	if name := d.consumerName(); name != "" || d.matched == nil {
+		return name
 	}
+	return d.matched.user
+}
 
-	isAuthorized := false
+// respond writes the error response of a request that was not authorized.
+func (ka *SwissKnife) respond(rw http.ResponseWriter, req *http.Request, d decision) {
+	switch d.outcome {
+	case outcomeBanned:
+		ka.responseBanned(rw, req, d.bannedUntil)
+	case outcomeUnavailable:
+		ka.writeResponse(rw, req, Response{
+			Message:    messageUnavailable,
+			StatusCode: ka.validationUnavailableStatusCode,
+		})
+	case outcomeForbidden:
+		ka.responseForbidden(rw, req)
+	case outcomeInvalidToken:
+		ka.responseInvalidToken(rw, req)
+	case outcomeConflict:
+		ka.responseConflict(rw, req)
+	case outcomeMaintenance:
+		ka.responseMaintenance(rw, req)
+	case outcomeQuotaExceeded:
+		ka.responseQuotaExceeded(rw, req)
+	case outcomeInsecure:
+		ka.writeResponse(rw, req, Response{
+			Message:    messageTLSRequired,
+			StatusCode: http.StatusForbidden,
+		})
+	case outcomeReplayed:
+		ka.delayFailure(req.Context())
+		ka.writeResponse(rw, req, Response{
+			Message:    messageReplayed,
+			StatusCode: ka.unauthorizedStatusCode,
+		})
+	default:
+		ka.responseError(rw, req, len(d.credentials) > 0, d.missing)
+	}
+}
+
+// keyFingerprints returns a log field with the fingerprints of the presented
+// credentials, if fingerprint logging is enabled.
+func (ka *SwissKnife) keyFingerprints(credentials ...credential) []logField {
+	if !ka.logKeyFingerprint {
+		return nil
+	}
 
-	if ka.authenticationHeader && contains(req.Header.Get(ka.authenticationHeaderName), ka.keys) {
-		isAuthorized = true
-		if ka.removeHeadersOnSuccess {
-			req.Header.Del(ka.authenticationHeaderName)
+	var fingerprints []string
+	for _, c := range credentials {
+		if c.value != "" {
+			fingerprints = append(fingerprints, fingerprint(c.value))
 		}
-	} else if ka.bearerHeader && bearer(req.Header.Get(ka.bearerHeaderName), ka.keys) {
-		isAuthorized = true
-		if ka.removeHeadersOnSuccess {
-			req.Header.Del(ka.bearerHeaderName)
+	}
+	if len(fingerprints) == 0 {
+		return nil
+	}
+	return []logField{{name: "key", value: strings.Join(fingerprints, ",")}}
+}
+
+// bypassReason returns why req may skip authentication, or an empty string
+// if it must present a valid key.
+func (ka *SwissKnife) bypassReason(req *http.Request) string {
+	if len(ka.protectedPaths) > 0 && matchAnyPath(ka.protectedPaths, req.URL.Path) {
+		return ""
+	}
+	if matchAnyPath(ka.excludedPaths, req.URL.Path) {
+		return "excluded path"
+	}
+	if ka.allowPreflight && isPreflight(req) {
+		return "preflight"
+	}
+	if _, ok := ka.bypassMethods[req.Method]; ok {
+		return "bypassed method"
+	}
+	if _, ok := ka.protectedMethods[req.Method]; len(ka.protectedMethods) > 0 && !ok {
+		return "unprotected method"
+	}
+	return ""
+}
+
+// isPreflight reports whether req is a CORS preflight request, as opposed to
+// an ordinary OPTIONS request.
+func isPreflight(req *http.Request) bool {
+	return req.Method == http.MethodOptions && req.Header.Get("Access-Control-Request-Method") != ""
+}
+
+var knownMethods = map[string]struct{}{
+	http.MethodGet:     {},
+	http.MethodHead:    {},
+	http.MethodPost:    {},
+	http.MethodPut:     {},
+	http.MethodPatch:   {},
+	http.MethodDelete:  {},
+	http.MethodConnect: {},
+	http.MethodOptions: {},
+	http.MethodTrace:   {},
+}
+
+// methodSet normalizes methods to upper case, rejecting names that are not
+// standard HTTP methods.
+func methodSet(methods []string) (map[string]struct{}, error) {
+	set := make(map[string]struct{}, len(methods))
+	for _, method := range methods {
+		normalized := strings.ToUpper(strings.TrimSpace(method))
+		if _, ok := knownMethods[normalized]; !ok {
+			return nil, fmt.Errorf("unknown HTTP method %q", method)
 		}
+		set[normalized] = struct{}{}
 	}
+	return set, nil
+}
 
-	if isAuthorized {
-		if ka.enableLog {
-			_, _ = os.Stdout.WriteString(fmt.Sprintf("Authorized request: %s %s\n", req.Method, req.URL.String()))
+// authorize returns the first credential accepted by a key store, along with
+// the key entry it matched. The stores are tried in order, each with every
+// credential. Keys from stores other than the configured keys have no
+// restrictions, and only a name if the store gave one.
+func (ka *SwissKnife) authorize(ctx context.Context, credentials []credential) (*credential, *keyEntry, error) {
+	for _, store := range ka.stores {
+		for i := range credentials {
+			info, valid, err := store.Validate(ctx, credentials[i].value)
+			if err != nil {
+				return nil, nil, err
+			}
+			if !valid {
+				continue
+			}
+
+			entry := info.entry
+			if entry == nil && info.Name != "" {
+				entry = &keyEntry{name: info.Name}
+			}
+			return &credentials[i], entry, nil
 		}
-		ka.next.ServeHTTP(rw, req)
+	}
+	return nil, nil, nil
+}
+
+// responseError rejects a request without a valid key. presented tells
+// whether the request carried any credential at all.
+func (ka *SwissKnife) responseError(rw http.ResponseWriter, req *http.Request, presented, missing bool) {
+	ka.delayFailure(req.Context())
+
+	if ka.shouldRedirect(req) {
+		ka.responseRedirect(rw, req)
 		return
 	}
 
-	ka.responseError(rw)
+	if ka.stealthMode {
+		ka.responseStealth(rw)
+		return
+	}
+
+	statusCode, message := ka.unauthorizedStatusCode, ka.unauthorizedMessage
+	if missing && ka.distinguishMissing {
+		statusCode, message = ka.missingStatusCode, ka.missingMessage
+	} else if ka.errorMessages != nil {
+		message = ka.errorMessages.message(req.Header.Get("Accept-Language"))
+		rw.Header().Add("Vary", "Accept-Language")
+	}
+	if ka.rfc6750Compliant {
+		// RFC 6750 section 3.1: no error code when the request lacks any
+		// authentication information.
+		statusCode = http.StatusUnauthorized
+		challenge := fmt.Sprintf("Bearer realm=%q", ka.realm)
+		if presented {
+			challenge += `, error="invalid_token"`
+		}
+		rw.Header().Set("WWW-Authenticate", challenge)
+	} else if statusCode == http.StatusUnauthorized && ka.bearerHeader {
+		rw.Header().Set("WWW-Authenticate", fmt.Sprintf("%s realm=%q", ka.bearerSchemes[0], ka.realm))
+	}
+
+	ka.writeResponse(rw, req, Response{
+		Message:    message,
+		StatusCode: statusCode,
+	})
+}
+
+// delayFailure waits for the failure delay, or until ctx is done, to slow
+// down clients guessing keys.
+func (ka *SwissKnife) delayFailure(ctx context.Context) {
+	if ka.failureDelay <= 0 {
+		return
+	}
+
+	timer := time.NewTimer(ka.failureDelay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
 }
 
-func (ka *SwissKnife) responseError(rw http.ResponseWriter) {
-	response := Response{
-		Message:    "Invalid API Key",
+// responseForbidden rejects a valid key that is not allowed to be used for the
+// request. It is always a 403, whatever the unauthorized status code is.
+func (ka *SwissKnife) responseForbidden(rw http.ResponseWriter, req *http.Request) {
+	if ka.stealthMode {
+		ka.responseStealth(rw)
+		return
+	}
+
+	ka.writeResponse(rw, req, Response{
+		Message:    messageForbidden,
 		StatusCode: http.StatusForbidden,
+	})
+}
+
+// responseBanned rejects a client banned until until without looking at its
+// credentials.
+func (ka *SwissKnife) responseBanned(rw http.ResponseWriter, req *http.Request, until time.Time) {
+	if ka.stealthMode {
+		ka.responseStealth(rw)
+		return
 	}
 
-	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
-	rw.WriteHeader(response.StatusCode)
-	if err := json.NewEncoder(rw).Encode(response); err != nil {
-		if ka.enableLog {
-			_, _ = os.Stderr.WriteString(fmt.Sprintf("Error sending response: %s\n", err.Error()))
+	retryAfter := int(math.Ceil(until.Sub(ka.now()).Seconds()))
+	rw.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	ka.writeResponse(rw, req, Response{
+		Message:    messageBanned,
+		StatusCode: http.StatusTooManyRequests,
+	})
+}
+
+func (ka *SwissKnife) responseStealth(rw http.ResponseWriter) {
+	ka.setFailureHeaders(rw)
+	rw.WriteHeader(http.StatusNotFound)
+	if ka.logger.enabledFor(levelInfo) {
+		ka.logger.response(fmt.Sprintf("Response: %d (stealth mode)", http.StatusNotFound))
+	}
+}
+
+// errorTemplateData is what the error body template is executed with.
+type errorTemplateData struct {
+	StatusCode int
+	Message    string
+	Path       string
+	Method     string
+	RequestID  string
+	TraceID    string
+}
+
+func (ka *SwissKnife) writeResponse(rw http.ResponseWriter, req *http.Request, response Response) {
+	ka.setFailureHeaders(rw)
+	ka.setFailureCORS(rw, req)
+	// Responses with a trace ID are not precomputed.
+	response.TraceID = ka.traceID(req)
+
+	if ka.errorBodyTemplate != nil {
+		var body bytes.Buffer
+		err := ka.errorBodyTemplate.Execute(&body, errorTemplateData{
+			StatusCode: response.StatusCode,
+			Message:    response.Message,
+			Path:       req.URL.Path,
+			Method:     req.Method,
+			RequestID:  req.Header.Get("X-Request-Id"),
+			TraceID:    response.TraceID,
+		})
+		if err == nil {
+			rw.Header().Set("Content-Type", ka.errorContentType)
+			rw.WriteHeader(response.StatusCode)
+			_, _ = rw.Write(body.Bytes())
+			if ka.logger.enabledFor(levelInfo) {
+				ka.logger.response(fmt.Sprintf("Response: %d %s", response.StatusCode, response.Message))
+			}
+			return
 		}
-	} else {
-		if ka.enableLog {
-			_, _ = os.Stdout.WriteString(fmt.Sprintf("Response: %d %s\n", response.StatusCode, response.Message))
+		ka.logger.error(req, "", fmt.Sprintf("Error executing error body template, sending default body: %s", err.Error()))
+	}
+
+	if ka.writeErrorBody(rw, req, response) {
+		return
+	}
+
+	var body interface{} = response
+	contentType := "application/json; charset=utf-8"
+	if ka.problemFormat {
+		body = ProblemResponse{
+			Type:     ka.problemType,
+			Title:    http.StatusText(response.StatusCode),
+			Status:   response.StatusCode,
+			Detail:   response.Message,
+			Instance: req.URL.Path,
+			TraceID:  response.TraceID,
 		}
+		contentType = "application/problem+json"
+	}
+
+	rw.Header().Set("Content-Type", contentType)
+	rw.WriteHeader(response.StatusCode)
+	if err := json.NewEncoder(rw).Encode(body); err != nil {
+		ka.logger.error(req, "", fmt.Sprintf("Error sending response: %s", err.Error()))
+	} else if ka.logger.enabledFor(levelInfo) {
+		ka.logger.response(fmt.Sprintf("Response: %d %s", response.StatusCode, response.Message))
 	}
 }
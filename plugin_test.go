@@ -0,0 +1,423 @@
+package swissknife
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var noopHandler = http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+
+// newTestHandler creates the plugin with config in front of next, failing t
+// if the configuration is invalid. The plugin is closed when the test ends.
+func newTestHandler(t testing.TB, config *Config, next http.Handler) *SwissKnife {
+	t.Helper()
+
+	if next == nil {
+		next = noopHandler
+	}
+	handler, err := New(context.Background(), next, config, "test")
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	ka := handler.(*SwissKnife)
+	t.Cleanup(func() { _ = ka.Close() })
+	return ka
+}
+
+// newKeyRequest returns a GET request sending key in the X-API-KEY header.
+func newKeyRequest(key string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-API-KEY", key)
+	return req
+}
+
+// serveRecorded serves req with h and returns the recorded response.
+func serveRecorded(h http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// statusFor returns the status code of a GET request sending key in the
+// X-API-KEY header.
+func statusFor(h http.Handler, key string) int {
+	return serveRecorded(h, newKeyRequest(key)).Code
+}
+
+// logRecorder is a Logger keeping the lines logged.
+type logRecorder struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *logRecorder) Log(level, line string) {
+	l.mu.Lock()
+	l.lines = append(l.lines, line)
+	l.mu.Unlock()
+}
+
+func (l *logRecorder) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return strings.Join(l.lines, "\n")
+}
+
+// discardWriter is a ResponseWriter that keeps nothing, so benchmarks only
+// measure the plugin.
+type discardWriter struct {
+	header http.Header
+}
+
+func (w *discardWriter) Header() http.Header         { return w.header }
+func (w *discardWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *discardWriter) WriteHeader(int)             {}
+
+// newBenchmarkHandler returns the plugin accepting secret-key-1 in front of
+// a noop handler, and a request sending key. Headers are not removed on
+// success, so that the request can be served again.
+func newBenchmarkHandler(tb testing.TB, key string) (*SwissKnife, *http.Request, *discardWriter) {
+	tb.Helper()
+
+	config := CreateConfig()
+	config.Keys = []string{"secret-key-1"}
+	config.RemoveHeadersOnSuccess = false
+	ka := newTestHandler(tb, config, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/orders", nil)
+	req.Header.Set("X-API-KEY", key)
+	return ka, req, &discardWriter{header: make(http.Header)}
+}
+
+func TestServeHTTPAuthorizedAllocations(t *testing.T) {
+	ka, req, rw := newBenchmarkHandler(t, "secret-key-1")
+
+	allocs := testing.AllocsPerRun(100, func() {
+		ka.ServeHTTP(rw, req)
+	})
+	if allocs != 0 {
+		t.Errorf("allocations per authorized request = %v, want 0", allocs)
+	}
+}
+
+func BenchmarkServeHTTP_Authorized(b *testing.B) {
+	ka, req, rw := newBenchmarkHandler(b, "secret-key-1")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ka.ServeHTTP(rw, req)
+	}
+}
+
+func BenchmarkServeHTTP_Rejected(b *testing.B) {
+	ka, req, rw := newBenchmarkHandler(b, "wrong-key")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ka.ServeHTTP(rw, req)
+	}
+}
+
+func TestFailureDelay(t *testing.T) {
+	tests := []struct {
+		name       string
+		delay      string
+		cancel     bool
+		key        string
+		want       int
+		minElapsed time.Duration
+		maxElapsed time.Duration
+	}{
+		{name: "delayed failure", delay: "50ms", key: "wrong-key", want: http.StatusForbidden, minElapsed: 50 * time.Millisecond, maxElapsed: time.Second},
+		{name: "cancelled request", delay: "1h", cancel: true, key: "wrong-key", want: http.StatusForbidden, maxElapsed: time.Second},
+		{name: "success not delayed", delay: "1h", key: "secret-key-1", want: http.StatusOK, maxElapsed: time.Second},
+		{name: "zero delay", delay: "0s", key: "wrong-key", want: http.StatusForbidden, maxElapsed: time.Second},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := CreateConfig()
+			config.Keys = []string{"secret-key-1"}
+			config.FailureDelay = test.delay
+			ka := newTestHandler(t, config, nil)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if test.cancel {
+				time.AfterFunc(20*time.Millisecond, cancel)
+			}
+			req := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+			req.Header.Set("X-API-KEY", test.key)
+			rec := httptest.NewRecorder()
+			start := time.Now()
+			ka.ServeHTTP(rec, req)
+			elapsed := time.Since(start)
+
+			if rec.Code != test.want {
+				t.Errorf("status code = %d, want %d", rec.Code, test.want)
+			}
+			if elapsed < test.minElapsed || elapsed > test.maxElapsed {
+				t.Errorf("ServeHTTP() took %s, want between %s and %s", elapsed, test.minElapsed, test.maxElapsed)
+			}
+		})
+	}
+}
+
+// TestZeroFailureDelay checks that a zero delay answers exactly as without
+// the option.
+func TestZeroFailureDelay(t *testing.T) {
+	responses := make([]*httptest.ResponseRecorder, 0, 2)
+	for _, delay := range []string{"", "0s"} {
+		config := CreateConfig()
+		config.Keys = []string{"secret-key-1"}
+		config.FailureDelay = delay
+		ka := newTestHandler(t, config, nil)
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-API-KEY", "wrong-key")
+		rec := httptest.NewRecorder()
+		ka.ServeHTTP(rec, req)
+		responses = append(responses, rec)
+	}
+
+	if responses[0].Code != responses[1].Code || !reflect.DeepEqual(responses[0].Header(), responses[1].Header()) || responses[0].Body.String() != responses[1].Body.String() {
+		t.Errorf("response with a zero delay = %d %v %q, want %d %v %q",
+			responses[1].Code, responses[1].Header(), responses[1].Body,
+			responses[0].Code, responses[0].Header(), responses[0].Body)
+	}
+}
+
+func TestNegativeFailureDelay(t *testing.T) {
+	config := CreateConfig()
+	config.Keys = []string{"secret-key-1"}
+	config.FailureDelay = "-1s"
+	_, err := New(context.Background(), noopHandler, config, "test")
+	if err == nil || !strings.Contains(err.Error(), "failure delay") {
+		t.Errorf("New() error = %v, want failure delay error", err)
+	}
+}
+
+func TestResponseJSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		response interface{}
+		want     string
+	}{
+		{
+			name:     "simple",
+			response: Response{Message: "Invalid API Key", StatusCode: http.StatusForbidden},
+			want:     `{"message":"Invalid API Key","statusCode":403}`,
+		},
+		{
+			name:     "simple with trace ID",
+			response: Response{Message: "Invalid API Key", StatusCode: http.StatusForbidden, TraceID: "abc"},
+			want:     `{"message":"Invalid API Key","statusCode":403,"traceId":"abc"}`,
+		},
+		{
+			name:     "problem",
+			response: ProblemResponse{Type: "https://example.com/problems/invalid-key", Title: "Forbidden", Status: http.StatusForbidden, Detail: "Invalid API Key", Instance: "/orders"},
+			want:     `{"type":"https://example.com/problems/invalid-key","title":"Forbidden","status":403,"detail":"Invalid API Key","instance":"/orders"}`,
+		},
+		{
+			name:     "problem without optional members",
+			response: ProblemResponse{Type: "about:blank", Title: "Forbidden", Status: http.StatusForbidden},
+			want:     `{"type":"about:blank","title":"Forbidden","status":403}`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := json.Marshal(test.response)
+			if err != nil {
+				t.Fatalf("json.Marshal() error = %v", err)
+			}
+			if string(got) != test.want {
+				t.Errorf("json.Marshal() = %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestErrorFormat(t *testing.T) {
+	tests := []struct {
+		name            string
+		errorFormat     string
+		problemType     string
+		wantContentType string
+		wantBody        string
+	}{
+		{
+			name:            "simple",
+			errorFormat:     "simple",
+			wantContentType: "application/json; charset=utf-8",
+			wantBody:        `{"message":"Invalid API Key","statusCode":403}` + "\n",
+		},
+		{
+			name:            "problem",
+			errorFormat:     "problem",
+			wantContentType: "application/problem+json",
+			wantBody:        `{"type":"about:blank","title":"Forbidden","status":403,"detail":"Invalid API Key","instance":"/orders"}` + "\n",
+		},
+		{
+			name:            "problem with a type",
+			errorFormat:     "problem",
+			problemType:     "https://example.com/problems/invalid-key",
+			wantContentType: "application/problem+json",
+			wantBody:        `{"type":"https://example.com/problems/invalid-key","title":"Forbidden","status":403,"detail":"Invalid API Key","instance":"/orders"}` + "\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := CreateConfig()
+			config.Keys = []string{"secret-key-1"}
+			config.ErrorFormat = test.errorFormat
+			if test.problemType != "" {
+				config.ProblemType = test.problemType
+			}
+			ka := newTestHandler(t, config, nil)
+
+			req := httptest.NewRequest(http.MethodGet, "/orders?page=2", nil)
+			req.Header.Set("X-API-KEY", "wrong-key")
+			rec := httptest.NewRecorder()
+			ka.ServeHTTP(rec, req)
+			if got := rec.Header().Get("Content-Type"); got != test.wantContentType {
+				t.Errorf("Content-Type = %q, want %q", got, test.wantContentType)
+			}
+			if got := rec.Body.String(); got != test.wantBody {
+				t.Errorf("body = %q, want %q", got, test.wantBody)
+			}
+		})
+	}
+}
+
+// credentialSources are the sources a key can be presented in, each setting
+// key on the request.
+var credentialSources = []struct {
+	name string
+	set  func(req *http.Request, key string)
+}{
+	{name: "header", set: func(req *http.Request, key string) { req.Header.Set("X-API-KEY", key) }},
+	{name: "bearer", set: func(req *http.Request, key string) { req.Header.Add("Authorization", "Bearer "+key) }},
+	{name: "query", set: func(req *http.Request, key string) { req.URL.RawQuery += "&api_key=" + key }},
+	{name: "cookie", set: func(req *http.Request, key string) { req.AddCookie(&http.Cookie{Name: "api_key", Value: key}) }},
+}
+
+func TestRemoveAllCredentialHeaders(t *testing.T) {
+	type testCase struct {
+		name      string
+		presented []bool
+		valid     []bool
+	}
+
+	// Every combination of sources, with every presented key valid, then
+	// with only the first or the last one valid.
+	var tests []testCase
+	for mask := 1; mask < 1<<len(credentialSources); mask++ {
+		var names []string
+		var indexes []int
+		presented := make([]bool, len(credentialSources))
+		for i, source := range credentialSources {
+			if mask&(1<<i) != 0 {
+				names = append(names, source.name)
+				indexes = append(indexes, i)
+				presented[i] = true
+			}
+		}
+		name := strings.Join(names, "+")
+		first := make([]bool, len(credentialSources))
+		last := make([]bool, len(credentialSources))
+		first[indexes[0]] = true
+		last[indexes[len(indexes)-1]] = true
+		tests = append(tests, testCase{name: name + " all valid", presented: presented, valid: presented})
+		if len(indexes) > 1 {
+			tests = append(tests,
+				testCase{name: name + " first valid", presented: presented, valid: first},
+				testCase{name: name + " last valid", presented: presented, valid: last},
+			)
+		}
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := CreateConfig()
+			config.Keys = []string{"secret-key-1"}
+			config.BearerHeader = true
+			config.QueryParam = true
+			config.Cookie = true
+			config.CookieName = "api_key"
+			config.TryAllExtractors = true
+			config.RemoveHeadersOnSuccess = false
+			config.RemoveAllCredentialHeaders = true
+
+			var forwarded *http.Request
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				forwarded = req
+			})
+			ka := newTestHandler(t, config, next)
+
+			req := httptest.NewRequest(http.MethodGet, "/orders?page=2", nil)
+			req.Header.Set("Authorization", "Basic dXNlcjpwYXNz")
+			req.AddCookie(&http.Cookie{Name: "session", Value: "abc"})
+			for i, source := range credentialSources {
+				switch {
+				case test.valid[i]:
+					source.set(req, "secret-key-1")
+				case test.presented[i]:
+					source.set(req, "wrong-key")
+				}
+			}
+			if code := serveRecorded(ka, req).Code; code != http.StatusOK {
+				t.Fatalf("status code = %d, want %d", code, http.StatusOK)
+			}
+
+			if got := forwarded.Header.Values("X-Api-Key"); len(got) != 0 {
+				t.Errorf("forwarded X-API-KEY = %q, want none", got)
+			}
+			if got, want := forwarded.Header.Values("Authorization"), []string{"Basic dXNlcjpwYXNz"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("forwarded Authorization = %q, want %q", got, want)
+			}
+			if got := forwarded.URL.Query(); got.Has("api_key") || got.Get("page") != "2" {
+				t.Errorf("forwarded query = %q, want page only", forwarded.URL.RawQuery)
+			}
+			if _, err := forwarded.Cookie("api_key"); err == nil {
+				t.Error("forwarded api_key cookie, want none")
+			}
+			if cookie, err := forwarded.Cookie("session"); err != nil || cookie.Value != "abc" {
+				t.Errorf("forwarded session cookie = %v, %v, want abc", cookie, err)
+			}
+		})
+	}
+}
+
+// TestRemoveHeadersOnSuccessMatchedOnly checks that without
+// RemoveAllCredentialHeaders only the credential that was accepted is
+// removed.
+func TestRemoveHeadersOnSuccessMatchedOnly(t *testing.T) {
+	config := CreateConfig()
+	config.Keys = []string{"secret-key-1"}
+	config.BearerHeader = true
+
+	var forwarded *http.Request
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		forwarded = req
+	})
+	ka := newTestHandler(t, config, next)
+
+	req := newKeyRequest("secret-key-1")
+	req.Header.Set("Authorization", "Bearer secret-key-1")
+	if code := serveRecorded(ka, req).Code; code != http.StatusOK {
+		t.Fatalf("status code = %d, want %d", code, http.StatusOK)
+	}
+	if got := forwarded.Header.Get("X-API-KEY"); got != "" {
+		t.Errorf("forwarded X-API-KEY = %q, want none", got)
+	}
+	if got := forwarded.Header.Get("Authorization"); got != "Bearer secret-key-1" {
+		t.Errorf("forwarded Authorization = %q, want %q", got, "Bearer secret-key-1")
+	}
+}
@@ -0,0 +1,97 @@
+package swissknife
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	reasonPathNotAllowed      = "path not allowed"
+	reasonMethodNotAllowed    = "method not allowed"
+	reasonClientIPNotAllowed  = "client IP not allowed"
+	reasonClientIPUnknown     = "client IP unknown"
+	reasonHostNotAllowed      = "host not allowed"
+	reasonTLSRequired         = "TLS required"
+	reasonOriginNotAllowed    = "origin not allowed"
+	reasonOriginMissing       = "origin missing"
+	reasonOutsideAccessWindow = "outside access window"
+)
+
+// keyExpired reports whether the key matching entry has expired.
+func (ka *SwissKnife) keyExpired(entry *keyEntry) bool {
+	return !entry.expiresAt.IsZero() && !ka.now().Before(entry.expiresAt)
+}
+
+// keyDeprecation reports whether the key matching entry is deprecated, either
+// explicitly or because its deprecation time has passed, and the time it
+// stops working, which is zero if it has no deprecation time.
+func (ka *SwissKnife) keyDeprecation(entry *keyEntry) (bool, time.Time) {
+	if entry.deprecatedAfter.IsZero() {
+		return entry.deprecated, time.Time{}
+	}
+	deprecated := entry.deprecated || !ka.now().Before(entry.deprecatedAfter)
+	return deprecated, entry.deprecatedAfter.Add(ka.deprecationGracePeriod)
+}
+
+// keyRetired reports whether the grace period of the deprecated key matching
+// entry has passed.
+func (ka *SwissKnife) keyRetired(entry *keyEntry) bool {
+	return !entry.deprecatedAfter.IsZero() && !ka.now().Before(entry.deprecatedAfter.Add(ka.deprecationGracePeriod))
+}
+
+// deprecationWarning returns the Warning header sent with responses to
+// requests made with a deprecated key.
+func deprecationWarning(rotateBy time.Time) string {
+	if rotateBy.IsZero() {
+		return `299 - "API key deprecated"`
+	}
+	return fmt.Sprintf(`299 - "API key deprecated, rotate by %s"`, rotateBy.UTC().Format(time.RFC3339))
+}
+
+// keyPolicyViolation returns why the key matching entry may not be used for
+// req, or an empty string if it may. entry is nil for keys validated
+// remotely, which are only subject to the global restrictions.
+func (ka *SwissKnife) keyPolicyViolation(req *http.Request, entry *keyEntry) string {
+	if len(ka.allowedCIDRs) > 0 || (entry != nil && len(entry.cidrs) > 0) {
+		addr, ok := ka.requestIP(req)
+		if !ok {
+			return reasonClientIPUnknown
+		}
+		if len(ka.allowedCIDRs) > 0 && !prefixesContain(ka.allowedCIDRs, addr) {
+			return reasonClientIPNotAllowed
+		}
+		if entry != nil && len(entry.cidrs) > 0 && !prefixesContain(entry.cidrs, addr) {
+			return reasonClientIPNotAllowed
+		}
+	}
+
+	if entry == nil {
+		return ""
+	}
+	if len(entry.paths) > 0 && !matchAnyPath(entry.paths, req.URL.Path) {
+		return reasonPathNotAllowed
+	}
+	if _, ok := entry.methods[req.Method]; len(entry.methods) > 0 && !ok {
+		return reasonMethodNotAllowed
+	}
+	if len(entry.hosts) > 0 && !matchAnyHost(entry.hosts, requestHost(req.Host)) {
+		return reasonHostNotAllowed
+	}
+	if len(entry.origins) > 0 {
+		u, found := requestOrigin(req)
+		if !found && !entry.allowMissingOrigin {
+			return reasonOriginMissing
+		}
+		if found && !matchAnyOrigin(entry.origins, u) {
+			return reasonOriginNotAllowed
+		}
+	}
+	if len(entry.windows) > 0 && !inAnyAccessWindow(entry.windows, ka.now()) {
+		return reasonOutsideAccessWindow
+	}
+	if entry.requireTLS && !ka.isSecure(req) {
+		return reasonTLSRequired
+	}
+	return ""
+}
@@ -0,0 +1,85 @@
+package swissknife
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeyDeprecationBoundaries(t *testing.T) {
+	after := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		deprecated  bool
+		grace       string
+		now         time.Time
+		want        int
+		wantWarning string
+	}{
+		{name: "before deprecation", grace: "1h", now: after.Add(-time.Nanosecond), want: http.StatusOK},
+		{name: "at deprecation", grace: "1h", now: after, want: http.StatusOK, wantWarning: `299 - "API key deprecated, rotate by 2024-06-01T01:00:00Z"`},
+		{name: "end of the grace period", grace: "1h", now: after.Add(time.Hour - time.Nanosecond), want: http.StatusOK, wantWarning: `299 - "API key deprecated, rotate by 2024-06-01T01:00:00Z"`},
+		{name: "after the grace period", grace: "1h", now: after.Add(time.Hour), want: http.StatusForbidden},
+		{name: "no grace period", grace: "0s", now: after, want: http.StatusForbidden},
+		{name: "no grace period, before deprecation", grace: "0s", now: after.Add(-time.Nanosecond), want: http.StatusOK},
+		{name: "flagged before the date", deprecated: true, grace: "1h", now: after.Add(-time.Hour), want: http.StatusOK, wantWarning: `299 - "API key deprecated, rotate by 2024-06-01T01:00:00Z"`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := CreateConfig()
+			config.KeyEntries = []KeyEntry{{Name: "old", Key: "secret-key-1", Deprecated: test.deprecated, DeprecatedAfter: after.Format(time.RFC3339)}}
+			config.DeprecationGracePeriod = test.grace
+			ka := newTestHandler(t, config, nil)
+			ka.SetLogger(&logRecorder{})
+			ka.now = func() time.Time { return test.now }
+
+			req := newKeyRequest("secret-key-1")
+			rec := serveRecorded(ka, req)
+			if rec.Code != test.want {
+				t.Errorf("status code = %d, want %d", rec.Code, test.want)
+			}
+			if got := rec.Header().Get("Warning"); got != test.wantWarning {
+				t.Errorf("Warning = %q, want %q", got, test.wantWarning)
+			}
+			wantCount := int64(0)
+			if test.wantWarning != "" {
+				wantCount = 1
+			}
+			if got := ka.Stats().Deprecated; got != wantCount {
+				t.Errorf("deprecated count = %d, want %d", got, wantCount)
+			}
+		})
+	}
+}
+
+func TestDeprecatedWithoutDate(t *testing.T) {
+	config := CreateConfig()
+	config.KeyEntries = []KeyEntry{{Key: "secret-key-1", Deprecated: true}}
+	ka := newTestHandler(t, config, nil)
+	logs := &logRecorder{}
+	ka.SetLogger(logs)
+
+	rec := serveRecorded(ka, newKeyRequest("secret-key-1"))
+	if rec.Code != http.StatusOK {
+		t.Errorf("status code = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if got, want := rec.Header().Get("Warning"), `299 - "API key deprecated"`; got != want {
+		t.Errorf("Warning = %q, want %q", got, want)
+	}
+	if !strings.Contains(logs.String(), "Deprecated key used") {
+		t.Errorf("log = %q, want a deprecation warning", logs.String())
+	}
+}
+
+func TestNegativeDeprecationGracePeriod(t *testing.T) {
+	config := CreateConfig()
+	config.Keys = []string{"secret-key-1"}
+	config.DeprecationGracePeriod = "-1h"
+	_, err := New(context.Background(), noopHandler, config, "test")
+	if err == nil || !strings.Contains(err.Error(), "deprecation grace period must not be negative") {
+		t.Errorf("New() error = %v, want negative grace period error", err)
+	}
+}
@@ -0,0 +1,158 @@
+package swissknife
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	quotaWindowDay   = "day"
+	quotaWindowMonth = "month"
+
+	quotaRemainingHeader = "X-Quota-Remaining"
+	quotaResetHeader     = "X-Quota-Reset"
+)
+
+//nolint:all
+type Quota struct {
+	Limit  int    `json:"limit,omitempty"`
+	Window string `json:"window,omitempty"`
+}
+
+// QuotaCounter counts the requests made with a key during a quota window.
+// The counters are kept in memory unless another implementation, such as
+// one persisting them, is given in Options.
+type QuotaCounter interface {
+	// Increment adds a request made with key in the window starting at
+	// windowStart, and returns the number of requests made in that window.
+	Increment(key string, windowStart time.Time) (int64, error)
+}
+
+type quotaPolicy struct {
+	limit  int64
+	window string
+}
+
+// quotaStatus is the state of the quota of the key used for a request, sent
+// back to the client. limit is zero for keys without a quota.
+type quotaStatus struct {
+	limit     int64
+	remaining int64
+	reset     time.Time
+	exceeded  bool
+}
+
+func compileQuota(quota Quota) (*quotaPolicy, error) {
+	if quota.Limit == 0 && quota.Window == "" {
+		return nil, nil
+	}
+	if quota.Limit <= 0 {
+		return nil, errors.New("quota limit must be positive")
+	}
+	window := quota.Window
+	if window == "" {
+		window = quotaWindowDay
+	}
+	if window != quotaWindowDay && window != quotaWindowMonth {
+		return nil, fmt.Errorf("quota window must be %q or %q", quotaWindowDay, quotaWindowMonth)
+	}
+	return &quotaPolicy{limit: int64(quota.Limit), window: window}, nil
+}
+
+// bounds returns the window holding now, which starts and ends at midnight
+// UTC.
+func (p *quotaPolicy) bounds(now time.Time) (start, end time.Time) {
+	now = now.UTC()
+	if p.window == quotaWindowMonth {
+		start = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(0, 1, 0)
+	}
+	start = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	return start, start.AddDate(0, 0, 1)
+}
+
+// quotaKey identifies the key of entry for its quota. Entries are counted by
+// name, so that the count carries over when the keys are reloaded, and
+// otherwise by key.
+func quotaKey(entry *keyEntry) string {
+	if entry.name != "" {
+		return "name:" + entry.name
+	}
+	if entry.bcryptHash != nil {
+		return bcryptKeyPrefix + string(entry.bcryptHash)
+	}
+	return "sha256:" + hex.EncodeToString(entry.digest[:])
+}
+
+// memoryQuotaCounter is the default QuotaCounter. It only keeps the count of
+// the current window of each key.
+type memoryQuotaCounter struct {
+	mu     sync.Mutex
+	counts map[string]*quotaCount
+}
+
+type quotaCount struct {
+	windowStart time.Time
+	count       int64
+}
+
+func newMemoryQuotaCounter() *memoryQuotaCounter {
+	return &memoryQuotaCounter{counts: make(map[string]*quotaCount)}
+}
+
+func (c *memoryQuotaCounter) Increment(key string, windowStart time.Time) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	count, ok := c.counts[key]
+	if !ok {
+		count = &quotaCount{}
+		c.counts[key] = count
+	}
+	if !count.windowStart.Equal(windowStart) {
+		count.windowStart = windowStart
+		count.count = 0
+	}
+	count.count++
+	return count.count, nil
+}
+
+// useQuota counts a request made with the key of entry against its quota.
+func (ka *SwissKnife) useQuota(entry *keyEntry) (quotaStatus, error) {
+	start, end := entry.quota.bounds(ka.now())
+	count, err := ka.quotas.Increment(quotaKey(entry), start)
+	if err != nil {
+		return quotaStatus{}, fmt.Errorf("counting quota: %w", err)
+	}
+
+	remaining := entry.quota.limit - count
+	if remaining < 0 {
+		remaining = 0
+	}
+	return quotaStatus{limit: entry.quota.limit, remaining: remaining, reset: end, exceeded: count > entry.quota.limit}, nil
+}
+
+// setQuotaHeaders tells the client how many requests are left in the quota
+// window, and in how many seconds the window ends.
+func (ka *SwissKnife) setQuotaHeaders(rw http.ResponseWriter, status quotaStatus) {
+	reset := int64(status.reset.Sub(ka.now()).Seconds())
+	if reset < 0 {
+		reset = 0
+	}
+	rw.Header().Set(quotaRemainingHeader, strconv.FormatInt(status.remaining, 10))
+	rw.Header().Set(quotaResetHeader, strconv.FormatInt(reset, 10))
+}
+
+// responseQuotaExceeded rejects a valid key that used up its quota.
+func (ka *SwissKnife) responseQuotaExceeded(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Retry-After", rw.Header().Get(quotaResetHeader))
+	ka.writeResponse(rw, req, Response{
+		Message:    messageQuotaExceeded,
+		StatusCode: http.StatusTooManyRequests,
+	})
+}
@@ -0,0 +1,42 @@
+package swissknife
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+)
+
+// serveNext calls the next handler. With recoverPanics, a panic of the next
+// handler is logged with its stack and answered with a 500, unless the
+// response was already started. http.ErrAbortHandler is not recovered, as it
+// is how handlers abort a response on purpose.
+func (ka *SwissKnife) serveNext(rw http.ResponseWriter, req *http.Request) {
+	if !ka.recoverPanics {
+		ka.next.ServeHTTP(rw, req)
+		return
+	}
+
+	wrapped, ok := rw.(*responseWriterWrapper)
+	if !ok {
+		wrapped = &responseWriterWrapper{ResponseWriter: rw}
+	}
+	defer func() {
+		p := recover()
+		if p == nil {
+			return
+		}
+		if err, ok := p.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+			panic(p)
+		}
+
+		ka.logger.error(req, "", fmt.Sprintf("Panic in next handler: %v\n%s", p, debug.Stack()))
+		if wrapped.status == 0 {
+			ka.writeResponse(wrapped, req, Response{
+				Message:    messageInternalError,
+				StatusCode: http.StatusInternalServerError,
+			})
+		}
+	}()
+	ka.next.ServeHTTP(wrapped, req)
+}
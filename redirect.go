@@ -0,0 +1,92 @@
+package swissknife
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// parseRedirectURL parses target, which must be an absolute path or a URL on
+// one of allowedHosts, so the redirect cannot be used as an open redirect.
+func parseRedirectURL(target string, allowedHosts []string) (*url.URL, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Host == "" && u.Scheme == "" {
+		if !strings.HasPrefix(u.Path, "/") || strings.HasPrefix(target, "//") {
+			return nil, errors.New("must be an absolute path or a URL")
+		}
+		return u, nil
+	}
+
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	for _, host := range allowedHosts {
+		if strings.EqualFold(u.Hostname(), host) {
+			return u, nil
+		}
+	}
+	return nil, fmt.Errorf("host %q is not in the allowed redirect hosts", u.Hostname())
+}
+
+// shouldRedirect reports whether a rejected req is redirected to the login
+// page rather than answered with an error body.
+func (ka *SwissKnife) shouldRedirect(req *http.Request) bool {
+	if ka.redirectURL == nil {
+		return false
+	}
+	return !ka.redirectOnlyForBrowsers || prefersHTML(req.Header.Values("Accept"))
+}
+
+func (ka *SwissKnife) responseRedirect(rw http.ResponseWriter, req *http.Request) {
+	target := *ka.redirectURL
+	query := target.Query()
+	query.Set("next", req.URL.Path)
+	target.RawQuery = query.Encode()
+
+	ka.setFailureHeaders(rw)
+	ka.setFailureCORS(rw, req)
+	http.Redirect(rw, req, target.String(), http.StatusFound)
+	if ka.logger.enabledFor(levelInfo) {
+		ka.logger.response(fmt.Sprintf("Response: %d redirect", http.StatusFound))
+	}
+}
+
+// prefersHTML reports whether an Accept header explicitly asks for text/html
+// with a quality at least as high as application/json.
+func prefersHTML(accept []string) bool {
+	htmlQuality, jsonQuality := -1.0, 0.0
+	for _, value := range accept {
+		for _, mediaRange := range strings.Split(value, ",") {
+			mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+			if err != nil {
+				continue
+			}
+			quality := 1.0
+			if q, ok := params["q"]; ok {
+				if quality, err = strconv.ParseFloat(q, 64); err != nil {
+					continue
+				}
+			}
+
+			switch mediaType {
+			case "text/html", "application/xhtml+xml":
+				if quality > htmlQuality {
+					htmlQuality = quality
+				}
+			case "application/json":
+				if quality > jsonQuality {
+					jsonQuality = quality
+				}
+			}
+		}
+	}
+	return htmlQuality > 0 && htmlQuality >= jsonQuality
+}
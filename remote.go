@@ -0,0 +1,185 @@
+package swissknife
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// remoteValidator checks keys against an HTTP endpoint, which authorizes a key
+// by answering with a 2xx status.
+type remoteValidator struct {
+	url          string
+	method       string
+	header       string
+	retries      int
+	retryBackoff time.Duration
+	maxDuration  time.Duration
+	client       *http.Client
+}
+
+func newRemoteValidator(config *Config) (*remoteValidator, error) {
+	if config.ValidationURL == "" {
+		return nil, nil
+	}
+
+	if _, err := url.ParseRequestURI(config.ValidationURL); err != nil {
+		return nil, fmt.Errorf("invalid validation URL: %w", err)
+	}
+
+	if config.ValidationMethod != http.MethodPost && config.ValidationMethod != http.MethodGet {
+		return nil, fmt.Errorf("validation method must be POST or GET, got %q", config.ValidationMethod)
+	}
+	if config.ValidationMethod == http.MethodGet && config.ValidationHeader == "" {
+		return nil, errors.New("validation header must be set when validation method is GET")
+	}
+
+	timeout, err := time.ParseDuration(config.ValidationTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("invalid validation timeout: %w", err)
+	}
+	if timeout < 0 {
+		return nil, errors.New("validation timeout must not be negative")
+	}
+
+	if config.ValidationRetries < 0 {
+		return nil, errors.New("validation retries must not be negative")
+	}
+	retryBackoff, err := time.ParseDuration(config.ValidationRetryBackoff)
+	if err != nil {
+		return nil, fmt.Errorf("invalid validation retry backoff: %w", err)
+	}
+	if retryBackoff < 0 {
+		return nil, errors.New("validation retry backoff must not be negative")
+	}
+	var maxDuration time.Duration
+	if config.ValidationMaxDuration != "" {
+		maxDuration, err = time.ParseDuration(config.ValidationMaxDuration)
+		if err != nil {
+			return nil, fmt.Errorf("invalid validation max duration: %w", err)
+		}
+		if maxDuration < 0 {
+			return nil, errors.New("validation max duration must not be negative")
+		}
+	}
+
+	transport, err := newValidationTransport(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &remoteValidator{
+		url:          config.ValidationURL,
+		method:       config.ValidationMethod,
+		header:       config.ValidationHeader,
+		retries:      config.ValidationRetries,
+		retryBackoff: retryBackoff,
+		maxDuration:  maxDuration,
+		client:       &http.Client{Timeout: timeout, Transport: transport},
+	}, nil
+}
+
+// validate reports whether the endpoint accepted key. An error means the
+// endpoint could not give an answer, either because it was unreachable or
+// because it failed with a 5xx status, even after retrying. Retries wait for
+// an exponential, jittered backoff, and all attempts together take at most
+// maxDuration and never outlive ctx.
+func (rv *remoteValidator) validate(ctx context.Context, key string) (bool, error) {
+	if rv.maxDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, rv.maxDuration)
+		defer cancel()
+	}
+
+	backoff := rv.retryBackoff
+	for attempt := 0; ; attempt++ {
+		valid, err := rv.validateOnce(ctx, key)
+		if err == nil || attempt >= rv.retries {
+			return valid, err
+		}
+
+		// Equal jitter: half of the backoff, plus up to the other half.
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return false, err
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
+
+func (rv *remoteValidator) validateOnce(ctx context.Context, key string) (bool, error) {
+	req, err := rv.newRequest(ctx, key)
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := rv.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return false, fmt.Errorf("validation endpoint returned status %d", resp.StatusCode)
+	}
+	return resp.StatusCode >= 200 && resp.StatusCode < 300, nil
+}
+
+func (rv *remoteValidator) newRequest(ctx context.Context, key string) (*http.Request, error) {
+	if rv.method == http.MethodGet {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, rv.url, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set(rv.header, key)
+		return req, nil
+	}
+
+	body, err := json.Marshal(map[string]string{"key": key})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, rv.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
+
+func newCacheFromConfig(config *Config) (*validationCache, error) {
+	if config.ValidationURL == "" || config.CacheTTL == "" {
+		return nil, nil
+	}
+
+	ttl, err := time.ParseDuration(config.CacheTTL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cache TTL: %w", err)
+	}
+
+	var negativeTTL time.Duration
+	if config.NegativeCacheTTL != "" {
+		negativeTTL, err = time.ParseDuration(config.NegativeCacheTTL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid negative cache TTL: %w", err)
+		}
+	}
+
+	if config.CacheMaxEntries <= 0 {
+		return nil, errors.New("cache max entries must be positive")
+	}
+
+	return newValidationCache(ttl, negativeTTL, config.CacheMaxEntries), nil
+}
@@ -0,0 +1,151 @@
+package swissknife
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// failingServer answers the first failures requests with failure, then
+// with status. A failure of 0 drops the connection instead.
+func failingServer(t *testing.T, failures int64, failure, status int) (*httptest.Server, *int64) {
+	t.Helper()
+
+	attempts := new(int64)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt64(attempts, 1) > failures {
+			rw.WriteHeader(status)
+			return
+		}
+		if failure == 0 {
+			conn, _, err := rw.(http.Hijacker).Hijack()
+			if err == nil {
+				_ = conn.Close()
+			}
+			return
+		}
+		rw.WriteHeader(failure)
+	}))
+	t.Cleanup(server.Close)
+	return server, attempts
+}
+
+func TestRemoteValidatorRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		failures     int64
+		failure      int
+		status       int
+		retries      int
+		wantValid    bool
+		wantErr      bool
+		wantAttempts int64
+	}{
+		{name: "no failure", failures: 0, failure: http.StatusServiceUnavailable, status: http.StatusOK, retries: 2, wantValid: true, wantAttempts: 1},
+		{name: "5xx then success", failures: 2, failure: http.StatusBadGateway, status: http.StatusOK, retries: 2, wantValid: true, wantAttempts: 3},
+		{name: "connection error then success", failures: 1, failure: 0, status: http.StatusOK, retries: 1, wantValid: true, wantAttempts: 2},
+		{name: "more failures than retries", failures: 3, failure: http.StatusServiceUnavailable, status: http.StatusOK, retries: 2, wantErr: true, wantAttempts: 3},
+		{name: "no retries", failures: 1, failure: http.StatusInternalServerError, status: http.StatusOK, retries: 0, wantErr: true, wantAttempts: 1},
+		{name: "403 is not retried", failures: 0, status: http.StatusForbidden, retries: 3, wantAttempts: 1},
+		{name: "401 is not retried", failures: 0, status: http.StatusUnauthorized, retries: 3, wantAttempts: 1},
+		{name: "5xx then 403", failures: 1, failure: http.StatusServiceUnavailable, status: http.StatusForbidden, retries: 3, wantAttempts: 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, attempts := failingServer(t, test.failures, test.failure, test.status)
+
+			config := CreateConfig()
+			config.ValidationURL = server.URL
+			config.ValidationRetries = test.retries
+			config.ValidationRetryBackoff = "1ms"
+			rv, err := newRemoteValidator(config)
+			if err != nil {
+				t.Fatalf("newRemoteValidator() error = %v", err)
+			}
+
+			valid, err := rv.validate(context.Background(), "secret-key-1")
+			if valid != test.wantValid {
+				t.Errorf("valid = %v, want %v", valid, test.wantValid)
+			}
+			if (err != nil) != test.wantErr {
+				t.Errorf("error = %v, want error %v", err, test.wantErr)
+			}
+			if got := atomic.LoadInt64(attempts); got != test.wantAttempts {
+				t.Errorf("attempts = %d, want %d", got, test.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestRemoteValidatorMaxDuration(t *testing.T) {
+	server, _ := failingServer(t, 1000, http.StatusServiceUnavailable, http.StatusOK)
+
+	config := CreateConfig()
+	config.ValidationURL = server.URL
+	config.ValidationRetries = 100
+	config.ValidationRetryBackoff = "20ms"
+	config.ValidationMaxDuration = "100ms"
+	rv, err := newRemoteValidator(config)
+	if err != nil {
+		t.Fatalf("newRemoteValidator() error = %v", err)
+	}
+
+	start := time.Now()
+	if _, err := rv.validate(context.Background(), "secret-key-1"); err == nil {
+		t.Error("validate() error = nil, want error")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("validate() took %s, want at most about 100ms", elapsed)
+	}
+}
+
+func TestRemoteValidatorContextDeadline(t *testing.T) {
+	server, _ := failingServer(t, 1000, http.StatusServiceUnavailable, http.StatusOK)
+
+	config := CreateConfig()
+	config.ValidationURL = server.URL
+	config.ValidationRetries = 100
+	config.ValidationRetryBackoff = "20ms"
+	rv, err := newRemoteValidator(config)
+	if err != nil {
+		t.Fatalf("newRemoteValidator() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := rv.validate(ctx, "secret-key-1"); err == nil {
+		t.Error("validate() error = nil, want error")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("validate() took %s, want at most about 100ms", elapsed)
+	}
+}
+
+func TestRemoteValidatorNegativeDurations(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*Config)
+		want   string
+	}{
+		{name: "timeout", modify: func(c *Config) { c.ValidationTimeout = "-1s" }, want: "validation timeout must not be negative"},
+		{name: "retry backoff", modify: func(c *Config) { c.ValidationRetryBackoff = "-1s" }, want: "validation retry backoff must not be negative"},
+		{name: "max duration", modify: func(c *Config) { c.ValidationMaxDuration = "-1s" }, want: "validation max duration must not be negative"},
+		{name: "retries", modify: func(c *Config) { c.ValidationRetries = -1 }, want: "validation retries must not be negative"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := CreateConfig()
+			config.ValidationURL = "http://127.0.0.1/validate"
+			test.modify(config)
+			_, err := newRemoteValidator(config)
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("newRemoteValidator() error = %v, want %q", err, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,29 @@
+package swissknife
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+)
+
+// reportOnlyHeader flags requests that report-only mode let through although
+// they would have been denied.
+const reportOnlyHeader = "X-SwissKnife-Auth"
+
+// reportWouldDeny counts and logs a request that would have been denied. It is
+// logged at error level so it is reported even when logging is disabled.
+func (ka *SwissKnife) reportWouldDeny(req *http.Request, d decision) {
+	count := atomic.AddInt64(&ka.wouldDeny, 1)
+
+	fields := []logField{{name: "wouldDenyCount", value: strconv.FormatInt(count, 10)}}
+	if !ka.logger.json {
+		// JSON records already carry the client IP.
+		clientIP := "unknown"
+		if addr, ok := ka.requestIP(req); ok {
+			clientIP = addr.String()
+		}
+		fields = append(fields, logField{name: "clientIP", value: clientIP})
+	}
+	ka.logger.error(req, d.outcome, fmt.Sprintf("Would deny request (%s)", d.outcome), fields...)
+}
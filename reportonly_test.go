@@ -0,0 +1,68 @@
+package swissknife
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReportOnly(t *testing.T) {
+	tests := []struct {
+		name          string
+		header        http.Header
+		wantWouldDeny bool
+	}{
+		{name: "valid key", header: http.Header{"X-Api-Key": {"secret-key-1"}}},
+		{name: "wrong key", header: http.Header{"X-Api-Key": {"wrong-key"}}, wantWouldDeny: true},
+		{name: "no key", header: http.Header{}, wantWouldDeny: true},
+		{name: "empty key", header: http.Header{"X-Api-Key": {""}}, wantWouldDeny: true},
+		{name: "forged report header", header: http.Header{"X-Api-Key": {"secret-key-1"}, "X-Swissknife-Auth": {"would-deny"}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := CreateConfig()
+			config.Keys = []string{"secret-key-1"}
+			config.ReportOnly = true
+
+			var forwarded *http.Request
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				forwarded = req
+			})
+			ka := newTestHandler(t, config, next)
+			logs := &logRecorder{}
+			ka.SetLogger(logs)
+
+			req := httptest.NewRequest(http.MethodPost, "/orders", nil)
+			req.Header = test.header
+			rec := httptest.NewRecorder()
+			ka.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Errorf("status code = %d, want %d", rec.Code, http.StatusOK)
+			}
+			if forwarded == nil {
+				t.Fatal("request not forwarded")
+			}
+			if got, wantDeny := forwarded.Header.Get(reportOnlyHeader), test.wantWouldDeny; (got == "would-deny") != wantDeny || (!wantDeny && got != "") {
+				t.Errorf("%s = %q, want would-deny %v", reportOnlyHeader, got, wantDeny)
+			}
+			if got := forwarded.Header.Get("X-API-KEY"); !test.wantWouldDeny && got != "" {
+				t.Errorf("forwarded X-API-KEY = %q, want it removed", got)
+			}
+
+			line := logs.String()
+			if !test.wantWouldDeny {
+				if strings.Contains(line, "Would deny") {
+					t.Errorf("log = %q, want no would-deny report", line)
+				}
+				return
+			}
+			for _, want := range []string{"Would deny", "POST", "/orders", "192.0.2.1"} {
+				if !strings.Contains(line, want) {
+					t.Errorf("log = %q, want %q", line, want)
+				}
+			}
+		})
+	}
+}
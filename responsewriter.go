@@ -0,0 +1,95 @@
+package swissknife
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+)
+
+// responseWriterWrapper records the status code and the number of body bytes
+// written by the next handler.
+// It implements http.Flusher and http.Hijacker, and passes ReadFrom through,
+// so that streaming responses and websockets keep working through it.
+type responseWriterWrapper struct {
+	http.ResponseWriter
+	status  int
+	written int64
+}
+
+var (
+	_ http.Flusher  = (*responseWriterWrapper)(nil)
+	_ http.Hijacker = (*responseWriterWrapper)(nil)
+	_ io.ReaderFrom = (*responseWriterWrapper)(nil)
+)
+
+func (w *responseWriterWrapper) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseWriterWrapper) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.written += int64(n)
+	return n, err
+}
+
+func (w *responseWriterWrapper) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		flusher.Flush()
+	}
+}
+
+func (w *responseWriterWrapper) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	if w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return hijacker.Hijack()
+}
+
+func (w *responseWriterWrapper) ReadFrom(src io.Reader) (int64, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	var n int64
+	var err error
+	if readerFrom, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		n, err = readerFrom.ReadFrom(src)
+	} else {
+		// Hide ReadFrom from io.Copy, which would otherwise call it again.
+		n, err = io.Copy(writerOnly{w.ResponseWriter}, src)
+	}
+	w.written += n
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *responseWriterWrapper) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// statusCode returns the recorded status, which is 200 if the next handler
+// wrote nothing.
+func (w *responseWriterWrapper) statusCode() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+type writerOnly struct {
+	io.Writer
+}
@@ -0,0 +1,175 @@
+package swissknife
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// hijackableRecorder is a ResponseRecorder that can be hijacked and reads
+// bodies itself.
+type hijackableRecorder struct {
+	*httptest.ResponseRecorder
+	hijacked bool
+	readFrom bool
+}
+
+func (r *hijackableRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	r.hijacked = true
+	return nil, nil, nil
+}
+
+func (r *hijackableRecorder) ReadFrom(src io.Reader) (int64, error) {
+	r.readFrom = true
+	return io.Copy(r.ResponseRecorder, src)
+}
+
+func TestResponseWriterWrapperStatus(t *testing.T) {
+	tests := []struct {
+		name        string
+		write       func(w *responseWriterWrapper)
+		wantStatus  int
+		wantWritten int64
+	}{
+		{name: "nothing written", write: func(*responseWriterWrapper) {}, wantStatus: http.StatusOK},
+		{name: "header", write: func(w *responseWriterWrapper) { w.WriteHeader(http.StatusNotFound) }, wantStatus: http.StatusNotFound},
+		{name: "second header ignored", write: func(w *responseWriterWrapper) {
+			w.WriteHeader(http.StatusCreated)
+			w.WriteHeader(http.StatusInternalServerError)
+		}, wantStatus: http.StatusCreated},
+		{name: "body", write: func(w *responseWriterWrapper) { _, _ = w.Write([]byte("hello")) }, wantStatus: http.StatusOK, wantWritten: 5},
+		{name: "flush", write: func(w *responseWriterWrapper) { w.Flush() }, wantStatus: http.StatusOK},
+		{name: "read from", write: func(w *responseWriterWrapper) { _, _ = w.ReadFrom(strings.NewReader("hello")) }, wantStatus: http.StatusOK, wantWritten: 5},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := &responseWriterWrapper{ResponseWriter: httptest.NewRecorder()}
+			test.write(w)
+			if got := w.statusCode(); got != test.wantStatus {
+				t.Errorf("statusCode() = %d, want %d", got, test.wantStatus)
+			}
+			if w.written != test.wantWritten {
+				t.Errorf("written = %d, want %d", w.written, test.wantWritten)
+			}
+		})
+	}
+}
+
+func TestResponseWriterWrapperInterfaces(t *testing.T) {
+	var rw http.ResponseWriter = &responseWriterWrapper{ResponseWriter: httptest.NewRecorder()}
+	if _, ok := rw.(http.Flusher); !ok {
+		t.Error("wrapper is not an http.Flusher")
+	}
+	if _, ok := rw.(http.Hijacker); !ok {
+		t.Error("wrapper is not an http.Hijacker")
+	}
+	if _, ok := rw.(io.ReaderFrom); !ok {
+		t.Error("wrapper is not an io.ReaderFrom")
+	}
+
+	t.Run("flush", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		w := &responseWriterWrapper{ResponseWriter: rec}
+		w.Flush()
+		if !rec.Flushed {
+			t.Error("underlying writer not flushed")
+		}
+	})
+
+	t.Run("response controller", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		w := &responseWriterWrapper{ResponseWriter: rec}
+		if err := http.NewResponseController(w).Flush(); err != nil {
+			t.Fatalf("Flush() error = %v", err)
+		}
+		if !rec.Flushed {
+			t.Error("underlying writer not flushed")
+		}
+	})
+
+	t.Run("hijack", func(t *testing.T) {
+		rec := &hijackableRecorder{ResponseRecorder: httptest.NewRecorder()}
+		w := &responseWriterWrapper{ResponseWriter: rec}
+		if _, _, err := w.Hijack(); err != nil {
+			t.Fatalf("Hijack() error = %v", err)
+		}
+		if !rec.hijacked {
+			t.Error("underlying writer not hijacked")
+		}
+		if got := w.statusCode(); got != http.StatusSwitchingProtocols {
+			t.Errorf("statusCode() = %d, want %d", got, http.StatusSwitchingProtocols)
+		}
+	})
+
+	t.Run("hijack unsupported", func(t *testing.T) {
+		w := &responseWriterWrapper{ResponseWriter: httptest.NewRecorder()}
+		if _, _, err := w.Hijack(); err == nil {
+			t.Error("Hijack() error = nil, want error")
+		}
+	})
+
+	t.Run("read from passed through", func(t *testing.T) {
+		rec := &hijackableRecorder{ResponseRecorder: httptest.NewRecorder()}
+		w := &responseWriterWrapper{ResponseWriter: rec}
+		if _, err := w.ReadFrom(strings.NewReader("hello")); err != nil {
+			t.Fatalf("ReadFrom() error = %v", err)
+		}
+		if !rec.readFrom {
+			t.Error("underlying ReadFrom not called")
+		}
+		if got := rec.Body.String(); got != "hello" {
+			t.Errorf("body = %q, want %q", got, "hello")
+		}
+	})
+
+	t.Run("read from without support", func(t *testing.T) {
+		w := &responseWriterWrapper{ResponseWriter: &discardWriter{header: make(http.Header)}}
+		if n, err := w.ReadFrom(strings.NewReader("hello")); err != nil || n != 5 {
+			t.Errorf("ReadFrom() = %d, %v, want 5, nil", n, err)
+		}
+	})
+}
+
+func TestNextReceivesWriter(t *testing.T) {
+	tests := []struct {
+		name        string
+		auditLog    bool
+		wantWrapped bool
+	}{
+		{name: "default", wantWrapped: false},
+		{name: "audit log", auditLog: true, wantWrapped: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := CreateConfig()
+			config.Keys = []string{"secret-key-1"}
+			config.AuditLog = test.auditLog
+
+			var received http.ResponseWriter
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				received = rw
+			})
+			ka := newTestHandler(t, config, next)
+			ka.SetLogger(&logRecorder{})
+
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("X-API-KEY", "secret-key-1")
+			ka.ServeHTTP(rec, req)
+
+			if test.wantWrapped {
+				if wrapped, ok := received.(*responseWriterWrapper); !ok || wrapped.ResponseWriter != rec {
+					t.Errorf("next received %T, want a wrapper of the original writer", received)
+				}
+				return
+			}
+			if received != rec {
+				t.Errorf("next received %T, want the original writer", received)
+			}
+		})
+	}
+}
@@ -0,0 +1,35 @@
+package swissknife
+
+import (
+	"net/http"
+	"strings"
+)
+
+// isSecure reports whether req was received over TLS, either by Traefik or,
+// as told by X-Forwarded-Proto, by a trusted proxy in front of it. The header
+// is ignored when the peer is not one of the trusted proxies, as clients
+// could set it themselves.
+func (ka *SwissKnife) isSecure(req *http.Request) bool {
+	if req.TLS != nil {
+		return true
+	}
+	if len(ka.trustedProxies) == 0 {
+		return false
+	}
+	peer, err := parseAddr(req.RemoteAddr)
+	if err != nil || !prefixesContain(ka.trustedProxies, peer) {
+		return false
+	}
+
+	// The last value is the one added by the closest proxy, the trusted
+	// peer itself.
+	proto := req.Header.Values("X-Forwarded-Proto")
+	if len(proto) == 0 {
+		return false
+	}
+	last := proto[len(proto)-1]
+	if i := strings.LastIndexByte(last, ','); i >= 0 {
+		last = last[i+1:]
+	}
+	return strings.EqualFold(strings.TrimSpace(last), "https")
+}
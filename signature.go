@@ -0,0 +1,232 @@
+package swissknife
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	sourceSignature = "signature"
+
+	signaturePrefix = "sha256="
+)
+
+//nolint:all
+type SignatureAuth struct {
+	SignatureHeader string            `json:"signatureHeader,omitempty"`
+	TimestampHeader string            `json:"timestampHeader,omitempty"`
+	ClockSkew       string            `json:"clockSkew,omitempty"`
+	MaxBodySize     int64             `json:"maxBodySize,omitempty"`
+	NonceHeader     string            `json:"nonceHeader,omitempty"`
+	RequireNonce    bool              `json:"requireNonce,omitempty"`
+	MaxNonces       int               `json:"maxNonces,omitempty"`
+	Secrets         []SignatureSecret `json:"secrets,omitempty"`
+}
+
+//nolint:all
+type SignatureSecret struct {
+	Name   string `json:"name,omitempty"`
+	Secret string `json:"secret,omitempty"`
+}
+
+// errReplayed is returned for a signed request whose nonce was already used.
+var errReplayed = errors.New("nonce already used")
+
+// signatureVerifier checks requests signed with HMAC-SHA256 over the method,
+// the request URI, the timestamp, the nonce if any and the body, each
+// followed by a newline except the body.
+type signatureVerifier struct {
+	header          string
+	timestampHeader string
+	clockSkew       time.Duration
+	maxBodySize     int64
+	nonceHeader     string
+	requireNonce    bool
+	nonces          *nonceStore
+	secrets         []SignatureSecret
+}
+
+func newSignatureVerifier(config SignatureAuth) (*signatureVerifier, error) {
+	if len(config.Secrets) == 0 {
+		return nil, nil
+	}
+
+	for i, secret := range config.Secrets {
+		if secret.Secret == "" {
+			return nil, fmt.Errorf("invalid signature secret at index %d: secret must not be empty", i)
+		}
+	}
+	if !validHeaderName(config.SignatureHeader) || !validHeaderName(config.TimestampHeader) {
+		return nil, errors.New("signature and timestamp headers must be valid header names")
+	}
+
+	clockSkew, err := time.ParseDuration(config.ClockSkew)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature clock skew: %w", err)
+	}
+	if clockSkew <= 0 {
+		return nil, errors.New("signature clock skew must be positive")
+	}
+	if config.MaxBodySize < 0 {
+		return nil, errors.New("signature max body size must not be negative")
+	}
+	if config.RequireNonce && config.NonceHeader == "" {
+		return nil, errors.New("nonce header must be set when nonces are required")
+	}
+
+	sv := &signatureVerifier{
+		header:          http.CanonicalHeaderKey(config.SignatureHeader),
+		timestampHeader: http.CanonicalHeaderKey(config.TimestampHeader),
+		clockSkew:       clockSkew,
+		maxBodySize:     config.MaxBodySize,
+		nonceHeader:     http.CanonicalHeaderKey(config.NonceHeader),
+		requireNonce:    config.RequireNonce,
+		secrets:         config.Secrets,
+	}
+	if config.NonceHeader != "" {
+		if !validHeaderName(config.NonceHeader) {
+			return nil, fmt.Errorf("invalid nonce header %q", config.NonceHeader)
+		}
+		if config.MaxNonces <= 0 {
+			return nil, errors.New("max nonces must be positive")
+		}
+		// A nonce only needs to be remembered while its timestamp is
+		// accepted, on either side of the server time.
+		sv.nonces = newNonceStore(2*clockSkew, config.MaxNonces)
+	}
+	return sv, nil
+}
+
+// verify checks the signature of req and returns the index of the secret it
+// was made with. The body is read to be signed and put back for the upstream.
+func (sv *signatureVerifier) verify(req *http.Request, now time.Time) (int, error) {
+	encoded, ok := strings.CutPrefix(req.Header.Get(sv.header), signaturePrefix)
+	if !ok {
+		return -1, errors.New("unsupported signature algorithm")
+	}
+	signature, err := hex.DecodeString(encoded)
+	if err != nil {
+		return -1, errors.New("signature must be hex encoded")
+	}
+
+	timestamp := req.Header.Get(sv.timestampHeader)
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return -1, errors.New("invalid timestamp")
+	}
+	skew := now.Sub(time.Unix(seconds, 0))
+	if skew > sv.clockSkew || skew < -sv.clockSkew {
+		return -1, errors.New("timestamp outside of the allowed clock skew")
+	}
+
+	var nonce string
+	if sv.nonceHeader != "" {
+		nonce = req.Header.Get(sv.nonceHeader)
+	}
+	if nonce == "" && sv.requireNonce {
+		return -1, errors.New("missing nonce")
+	}
+	if len(nonce) > maxNonceLength {
+		return -1, errors.New("nonce too long")
+	}
+
+	body, err := sv.readBody(req)
+	if err != nil {
+		return -1, err
+	}
+
+	signed := req.Method + "\n" + req.URL.RequestURI() + "\n" + timestamp + "\n"
+	if nonce != "" {
+		signed += nonce + "\n"
+	}
+
+	// Every secret is tried, so the time taken does not tell which matched.
+	matched := -1
+	for i := range sv.secrets {
+		mac := hmac.New(sha256.New, []byte(sv.secrets[i].Secret))
+		_, _ = io.WriteString(mac, signed)
+		_, _ = mac.Write(body)
+		if hmac.Equal(mac.Sum(nil), signature) && matched < 0 {
+			matched = i
+		}
+	}
+	if matched < 0 {
+		return -1, errors.New("invalid signature")
+	}
+
+	// Nonces are only remembered once the signature is valid, so that
+	// unauthenticated clients cannot fill the store.
+	if nonce != "" && !sv.nonces.add(strconv.Itoa(matched)+":"+nonce, now) {
+		return -1, errReplayed
+	}
+	return matched, nil
+}
+
+// readBody reads the body of req, of at most maxBodySize bytes, and replaces
+// it with a reader over what was read.
+func (sv *signatureVerifier) readBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(req.Body, sv.maxBodySize+1))
+	_ = req.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("reading body: %w", err)
+	}
+	if int64(len(body)) > sv.maxBodySize {
+		return nil, fmt.Errorf("body exceeds %d bytes", sv.maxBodySize)
+	}
+
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	return body, nil
+}
+
+// decideSignature authenticates a request carrying a signature.
+func (ka *SwissKnife) decideSignature(req *http.Request) decision {
+	i, err := ka.signatures.verify(req, ka.now())
+	if errors.Is(err, errReplayed) {
+		ka.logger.info(req, outcomeReplayed, "Replayed request")
+		ka.recordFailure(req)
+		return decision{outcome: outcomeReplayed}
+	}
+	if err != nil {
+		if ka.logger.enabledFor(levelInfo) {
+			ka.logger.info(req, outcomeRejected, fmt.Sprintf("Unauthorized request (%s)", err.Error()))
+		}
+		ka.recordFailure(req)
+		return decision{outcome: outcomeRejected, credentials: []credential{{source: sourceSignature}}}
+	}
+	secret := ka.signatures.secrets[i]
+
+	matched := &credential{
+		extractor: &headerExtractor{name: ka.signatures.header},
+		source:    sourceSignature,
+		raw:       req.Header.Get(ka.signatures.header),
+	}
+	d := decision{matched: matched, entry: &keyEntry{name: secret.Name}}
+	if reason := ka.keyPolicyViolation(req, nil); reason != "" {
+		if ka.logger.enabledFor(levelInfo) {
+			ka.logger.info(req, outcomeForbidden, fmt.Sprintf("Forbidden request (%s)", reason))
+		}
+		d.outcome = outcomeForbidden
+		return d
+	}
+
+	ka.logger.info(req, outcomeAuthorized, "Authorized request (signature)")
+	ka.recordSuccess(req)
+	d.outcome = outcomeAuthorized
+	return d
+}
@@ -0,0 +1,185 @@
+package swissknife
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// signedRequest returns a POST request to target with body, signed with
+// secret at now and carrying nonce if it is not empty.
+func signedRequest(secret, target, body, nonce string, now time.Time) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	signed := req.Method + "\n" + req.URL.RequestURI() + "\n" + timestamp + "\n"
+	if nonce != "" {
+		signed += nonce + "\n"
+		req.Header.Set("X-Nonce", nonce)
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write([]byte(signed + body))
+	req.Header.Set("X-Timestamp", timestamp)
+	req.Header.Set("X-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	return req
+}
+
+func TestSignatureReplay(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name         string
+		requireNonce bool
+		first        *http.Request
+		second       *http.Request
+		advance      time.Duration
+		wantFirst    int
+		wantSecond   int
+	}{
+		{
+			name:       "replayed",
+			first:      signedRequest("signing-secret", "/orders", `{"id":1}`, "nonce-1", now),
+			second:     signedRequest("signing-secret", "/orders", `{"id":1}`, "nonce-1", now),
+			wantFirst:  http.StatusOK,
+			wantSecond: http.StatusForbidden,
+		},
+		{
+			name:       "new nonce",
+			first:      signedRequest("signing-secret", "/orders", `{"id":1}`, "nonce-1", now),
+			second:     signedRequest("signing-secret", "/orders", `{"id":1}`, "nonce-2", now),
+			wantFirst:  http.StatusOK,
+			wantSecond: http.StatusOK,
+		},
+		{
+			name:       "same nonce with another secret",
+			first:      signedRequest("signing-secret", "/orders", "", "nonce-1", now),
+			second:     signedRequest("other-secret", "/orders", "", "nonce-1", now),
+			wantFirst:  http.StatusOK,
+			wantSecond: http.StatusOK,
+		},
+		{
+			name:       "replayed once the timestamp is too old",
+			first:      signedRequest("signing-secret", "/orders", "", "nonce-1", now),
+			second:     signedRequest("signing-secret", "/orders", "", "nonce-1", now),
+			advance:    6 * time.Minute,
+			wantFirst:  http.StatusOK,
+			wantSecond: http.StatusForbidden,
+		},
+		{
+			name:       "without nonce",
+			first:      signedRequest("signing-secret", "/orders", "", "", now),
+			second:     signedRequest("signing-secret", "/orders", "", "", now),
+			wantFirst:  http.StatusOK,
+			wantSecond: http.StatusOK,
+		},
+		{
+			name:         "required nonce missing",
+			requireNonce: true,
+			first:        signedRequest("signing-secret", "/orders", "", "", now),
+			second:       signedRequest("signing-secret", "/orders", "", "nonce-1", now),
+			wantFirst:    http.StatusForbidden,
+			wantSecond:   http.StatusOK,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := CreateConfig()
+			config.AuthenticationHeader = false
+			config.BearerHeader = false
+			config.SignatureAuth.RequireNonce = test.requireNonce
+			config.SignatureAuth.Secrets = []SignatureSecret{
+				{Name: "billing", Secret: "signing-secret"},
+				{Name: "reporting", Secret: "other-secret"},
+			}
+			ka := newTestHandler(t, config, nil)
+			clock := now
+			ka.now = func() time.Time { return clock }
+
+			rec := httptest.NewRecorder()
+			ka.ServeHTTP(rec, test.first)
+			if rec.Code != test.wantFirst {
+				t.Errorf("first status code = %d, want %d", rec.Code, test.wantFirst)
+			}
+
+			clock = clock.Add(test.advance)
+			rec = httptest.NewRecorder()
+			ka.ServeHTTP(rec, test.second)
+			if rec.Code != test.wantSecond {
+				t.Errorf("second status code = %d, want %d", rec.Code, test.wantSecond)
+			}
+		})
+	}
+}
+
+func TestReplayedRequestMessage(t *testing.T) {
+	now := time.Now()
+	config := CreateConfig()
+	config.AuthenticationHeader = false
+	config.BearerHeader = false
+	config.SignatureAuth.Secrets = []SignatureSecret{{Name: "billing", Secret: "signing-secret"}}
+	ka := newTestHandler(t, config, nil)
+
+	ka.ServeHTTP(httptest.NewRecorder(), signedRequest("signing-secret", "/orders", "", "nonce-1", now))
+	rec := httptest.NewRecorder()
+	ka.ServeHTTP(rec, signedRequest("signing-secret", "/orders", "", "nonce-1", now))
+	if want := `{"message":"Request already received","statusCode":403}` + "\n"; rec.Body.String() != want {
+		t.Errorf("body = %q, want %q", rec.Body.String(), want)
+	}
+}
+
+func TestNonceStore(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		adds []string
+		at   []time.Duration
+		want []bool
+	}{
+		{name: "duplicate", adds: []string{"a", "a"}, at: []time.Duration{0, time.Second}, want: []bool{true, false}},
+		{name: "expired", adds: []string{"a", "a"}, at: []time.Duration{0, time.Minute}, want: []bool{true, true}},
+		{name: "oldest forgotten when full", adds: []string{"a", "b", "c", "a"}, at: []time.Duration{0, 0, 0, 0}, want: []bool{true, true, true, true}},
+		{name: "kept while not full", adds: []string{"a", "b", "a"}, at: []time.Duration{0, 0, 0}, want: []bool{true, true, false}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ns := newNonceStore(time.Minute, 2)
+			for i, key := range test.adds {
+				if got := ns.add(key, now.Add(test.at[i])); got != test.want[i] {
+					t.Errorf("add(%q) #%d = %v, want %v", key, i, got, test.want[i])
+				}
+			}
+			if len(ns.expires) > 2 {
+				t.Errorf("store holds %d nonces, want at most 2", len(ns.expires))
+			}
+		})
+	}
+}
+
+func TestNonceStoreConcurrent(t *testing.T) {
+	ns := newNonceStore(time.Minute, 100)
+	now := time.Now()
+
+	var accepted int64
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ns.add("0:nonce-1", now) {
+				atomic.AddInt64(&accepted, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if accepted != 1 {
+		t.Errorf("nonce accepted %d times, want once", accepted)
+	}
+}
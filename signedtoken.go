@@ -0,0 +1,149 @@
+package swissknife
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+//nolint:all
+type SignedTokens struct {
+	Secret string `json:"secret,omitempty"`
+	MaxAge string `json:"maxAge,omitempty"`
+}
+
+// signedTokenPayload is the payload of a signed token: the name of the key
+// entry it stands for, and its expiry as a unix timestamp.
+type signedTokenPayload struct {
+	KeyID string `json:"kid"`
+	Exp   int64  `json:"exp"`
+}
+
+// MintToken returns a token for the key entry named keyID, valid for ttl. The
+// token is the base64url-encoded JSON payload and its HMAC-SHA256 with
+// secret, separated by a dot.
+//
+//nolint:all
+func MintToken(secret, keyID string, ttl time.Duration) string {
+	return mintToken(secret, keyID, time.Now().Add(ttl))
+}
+
+func mintToken(secret, keyID string, expiresAt time.Time) string {
+	payload, _ := json.Marshal(signedTokenPayload{KeyID: keyID, Exp: expiresAt.Unix()})
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(signedTokenMAC([]byte(secret), encoded))
+}
+
+func signedTokenMAC(secret []byte, encodedPayload string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	_, _ = mac.Write([]byte(encodedPayload))
+	return mac.Sum(nil)
+}
+
+// signedTokenVerifier verifies tokens made with MintToken.
+type signedTokenVerifier struct {
+	secret []byte
+	maxAge time.Duration
+}
+
+func newSignedTokenVerifier(config *Config) (*signedTokenVerifier, error) {
+	if config.SignedTokens.Secret == "" {
+		return nil, nil
+	}
+	if !config.BearerHeader {
+		return nil, errors.New("bearer header must be true when a signed token secret is set")
+	}
+
+	maxAge, err := time.ParseDuration(config.SignedTokens.MaxAge)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signed token max age: %w", err)
+	}
+	if maxAge <= 0 {
+		return nil, errors.New("signed token max age must be positive")
+	}
+	return &signedTokenVerifier{secret: []byte(config.SignedTokens.Secret), maxAge: maxAge}, nil
+}
+
+// looksLikeSignedToken reports whether token has the shape of a signed token:
+// two base64url-encoded segments, the first of which is a JSON object. Other
+// tokens are treated as opaque keys.
+func looksLikeSignedToken(token string) bool {
+	encoded, _, ok := strings.Cut(token, ".")
+	if !ok || strings.Count(token, ".") != 1 {
+		return false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	return err == nil && json.Valid(payload) && strings.HasPrefix(string(payload), "{")
+}
+
+// verify checks the signature and expiry of token, and returns the key ID it
+// was minted for. Tokens expiring more than maxAge from now are rejected, so
+// that a leaked secret cannot be used to mint long-lived tokens unnoticed.
+func (sv *signedTokenVerifier) verify(token string, now time.Time) (string, error) {
+	encoded, encodedMAC, _ := strings.Cut(token, ".")
+	mac, err := base64.RawURLEncoding.DecodeString(encodedMAC)
+	if err != nil {
+		return "", errors.New("malformed signature")
+	}
+	if !hmac.Equal(signedTokenMAC(sv.secret, encoded), mac) {
+		return "", errors.New("invalid signature")
+	}
+
+	var payload signedTokenPayload
+	if err := decodeSegment(encoded, &payload); err != nil {
+		return "", fmt.Errorf("malformed payload: %w", err)
+	}
+	if payload.KeyID == "" {
+		return "", errors.New("missing key ID")
+	}
+	expiresAt := time.Unix(payload.Exp, 0)
+	if !now.Before(expiresAt) {
+		return "", errors.New("token expired")
+	}
+	if expiresAt.Sub(now) > sv.maxAge {
+		return "", errors.New("token valid for longer than the max age")
+	}
+	return payload.KeyID, nil
+}
+
+// authorizeSignedToken verifies the bearer credentials that look like signed
+// tokens, and returns the first valid one with the key entry named by its key
+// ID. The other credentials are returned in rest to be looked up as keys. err
+// is the reason the first signed token was invalid, if none was valid.
+func (ka *SwissKnife) authorizeSignedToken(credentials []credential) (matched *credential, entry *keyEntry, rest []credential, err error) {
+	keys := ka.static.current()
+	for i := range credentials {
+		c := &credentials[i]
+		if c.source != sourceBearer || !looksLikeSignedToken(c.value) {
+			rest = append(rest, *c)
+			continue
+		}
+		if matched != nil {
+			continue
+		}
+
+		keyID, verr := ka.signedTokens.verify(c.value, ka.now())
+		if verr == nil {
+			var ok bool
+			if entry, ok = keys.named(keyID); !ok {
+				verr = fmt.Errorf("unknown key ID %q", keyID)
+			}
+		}
+		if verr != nil {
+			if err == nil {
+				err = verr
+			}
+			continue
+		}
+		matched = c
+	}
+	if matched != nil {
+		err = nil
+	}
+	return matched, entry, rest, err
+}
@@ -0,0 +1,73 @@
+package swissknife
+
+import "sync/atomic"
+
+// Stats is a snapshot of the request counters of a middleware instance.
+//
+//nolint:all
+type Stats struct {
+	Requests   int64 `json:"requests"`
+	Authorized int64 `json:"authorized"`
+	Deprecated int64 `json:"deprecated"`
+	Bypassed   int64 `json:"bypassed"`
+	// Rejected counts the rejected requests by reason, such as "rejected"
+	// for an invalid key or "banned".
+	Rejected map[string]int64 `json:"rejected"`
+	// Keys counts the authorized requests by key name, for at most
+	// metricsMaxKeys names. OtherKeys counts those of the names beyond.
+	Keys      map[string]int64 `json:"keys"`
+	OtherKeys int64            `json:"otherKeys"`
+}
+
+// Stats returns the request counters. It is safe to call while requests are
+// served, and only locks while copying the counters of the key names.
+//
+//nolint:all
+func (ka *SwissKnife) Stats() Stats {
+	return ka.metrics.stats()
+}
+
+// ResetStats sets every request counter back to zero. Requests being counted
+// while it runs may be missed.
+//
+//nolint:all
+func (ka *SwissKnife) ResetStats() {
+	ka.metrics.reset()
+}
+
+func (m *metrics) stats() Stats {
+	stats := Stats{
+		Requests:   atomic.LoadInt64(&m.requests),
+		Authorized: atomic.LoadInt64(&m.authorized),
+		Deprecated: atomic.LoadInt64(&m.deprecated),
+		Bypassed:   atomic.LoadInt64(&m.bypassed),
+		Rejected:   make(map[string]int64, len(m.rejected)),
+		OtherKeys:  atomic.LoadInt64(&m.otherKeys),
+	}
+	for outcome, counter := range m.rejected {
+		stats.Rejected[outcome] = atomic.LoadInt64(counter)
+	}
+
+	m.keysMu.Lock()
+	stats.Keys = make(map[string]int64, len(m.keys))
+	for name, counter := range m.keys {
+		stats.Keys[name] = atomic.LoadInt64(counter)
+	}
+	m.keysMu.Unlock()
+	return stats
+}
+
+func (m *metrics) reset() {
+	atomic.StoreInt64(&m.requests, 0)
+	atomic.StoreInt64(&m.authorized, 0)
+	atomic.StoreInt64(&m.deprecated, 0)
+	atomic.StoreInt64(&m.bypassed, 0)
+	for _, counter := range m.rejected {
+		atomic.StoreInt64(counter, 0)
+	}
+
+	m.keysMu.Lock()
+	m.keys = make(map[string]*int64)
+	m.keysMu.Unlock()
+	atomic.StoreInt64(&m.otherKeys, 0)
+}
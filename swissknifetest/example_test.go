@@ -0,0 +1,15 @@
+package swissknifetest_test
+
+import (
+	"fmt"
+
+	"github.com/quangnguyen/swiss-knife/swissknifetest"
+)
+
+func ExampleNewAuthorizedRequest() {
+	req := swissknifetest.NewAuthorizedRequest("secret-key-1", swissknifetest.AsBearer(), swissknifetest.WithTarget("/orders"))
+	fmt.Println(req.Method, req.URL, req.Header.Get("Authorization"))
+
+	// Output:
+	// GET /orders Bearer secret-key-1
+}
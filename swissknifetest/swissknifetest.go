@@ -0,0 +1,313 @@
+// Package swissknifetest provides helpers for testing handler chains that
+// include the swiss-knife middleware: requests carrying a key, a next
+// handler recording what the middleware forwarded, and assertions on the
+// error bodies it sends.
+package swissknifetest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	swissknife "github.com/quangnguyen/swiss-knife"
+)
+
+// DefaultHeader is the header NewAuthorizedRequest puts the key in, the
+// default authenticationHeaderName of the middleware.
+//
+//nolint:all
+const DefaultHeader = "X-API-KEY"
+
+type requestConfig struct {
+	method  string
+	target  string
+	body    string
+	place   func(req *http.Request, key string)
+	headers http.Header
+}
+
+// RequestOption customizes a request built by NewAuthorizedRequest.
+//
+//nolint:all
+type RequestOption func(*requestConfig)
+
+// WithMethod sets the method of the request, GET by default.
+//
+//nolint:all
+func WithMethod(method string) RequestOption {
+	return func(c *requestConfig) { c.method = method }
+}
+
+// WithTarget sets the URL of the request, "http://example.com/" by default.
+// As with httptest.NewRequest, a target that is only a path gets the host
+// example.com.
+//
+//nolint:all
+func WithTarget(target string) RequestOption {
+	return func(c *requestConfig) { c.target = target }
+}
+
+// WithBody sets the body of the request.
+//
+//nolint:all
+func WithBody(body string) RequestOption {
+	return func(c *requestConfig) { c.body = body }
+}
+
+// WithHeader adds a header to the request.
+//
+//nolint:all
+func WithHeader(name, value string) RequestOption {
+	return func(c *requestConfig) { c.headers.Add(name, value) }
+}
+
+// InHeader sends the key in the header name instead of DefaultHeader.
+//
+//nolint:all
+func InHeader(name string) RequestOption {
+	return func(c *requestConfig) {
+		c.place = func(req *http.Request, key string) { req.Header.Set(name, key) }
+	}
+}
+
+// AsBearer sends the key as "Authorization: Bearer <key>".
+//
+//nolint:all
+func AsBearer() RequestOption {
+	return func(c *requestConfig) {
+		c.place = func(req *http.Request, key string) { req.Header.Set("Authorization", "Bearer "+key) }
+	}
+}
+
+// InQuery sends the key in the query parameter name.
+//
+//nolint:all
+func InQuery(name string) RequestOption {
+	return func(c *requestConfig) {
+		c.place = func(req *http.Request, key string) {
+			query := req.URL.Query()
+			query.Set(name, key)
+			req.URL.RawQuery = query.Encode()
+		}
+	}
+}
+
+// InCookie sends the key in the cookie name.
+//
+//nolint:all
+func InCookie(name string) RequestOption {
+	return func(c *requestConfig) {
+		c.place = func(req *http.Request, key string) { req.AddCookie(&http.Cookie{Name: name, Value: key}) }
+	}
+}
+
+// NewAuthorizedRequest returns a request carrying key, in DefaultHeader
+// unless an option places it elsewhere. It panics on an invalid target, like
+// httptest.NewRequest.
+//
+//nolint:all
+func NewAuthorizedRequest(key string, opts ...RequestOption) *http.Request {
+	config := &requestConfig{
+		method:  http.MethodGet,
+		target:  "http://example.com/",
+		headers: make(http.Header),
+		place:   func(req *http.Request, key string) { req.Header.Set(DefaultHeader, key) },
+	}
+	for _, opt := range opts {
+		opt(config)
+	}
+
+	var body io.Reader
+	if config.body != "" {
+		body = strings.NewReader(config.body)
+	}
+	req := httptest.NewRequest(config.method, config.target, body)
+	for name, values := range config.headers {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+	config.place(req, key)
+	return req
+}
+
+// Received is a request as seen by the next handler.
+//
+//nolint:all
+type Received struct {
+	Method   string
+	URL      string
+	Header   http.Header
+	Cookies  []*http.Cookie
+	Body     []byte
+	Context  context.Context
+	AuthInfo swissknife.AuthInfo
+	// Authorized tells whether AuthInfo is set, which is only the case for
+	// requests authorized with a valid key.
+	Authorized bool
+	ClientIP   netip.Addr
+}
+
+// Recorder is a next handler that records the requests it receives and
+// answers them with 200 OK. It is safe for concurrent use.
+//
+//nolint:all
+type Recorder struct {
+	mu       sync.Mutex
+	received []Received
+}
+
+// NewRecorder returns an empty Recorder.
+//
+//nolint:all
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+//nolint:all
+func (r *Recorder) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	var body []byte
+	if req.Body != nil {
+		body, _ = io.ReadAll(req.Body)
+	}
+	info, authorized := swissknife.FromContext(req.Context())
+	clientIP, _ := swissknife.ClientIP(req.Context())
+
+	r.mu.Lock()
+	r.received = append(r.received, Received{
+		Method:     req.Method,
+		URL:        req.URL.String(),
+		Header:     req.Header.Clone(),
+		Cookies:    req.Cookies(),
+		Body:       body,
+		Context:    req.Context(),
+		AuthInfo:   info,
+		Authorized: authorized,
+		ClientIP:   clientIP,
+	})
+	r.mu.Unlock()
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+// Requests returns the requests received so far, in order.
+//
+//nolint:all
+func (r *Recorder) Requests() []Received {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Received(nil), r.received...)
+}
+
+// Last returns the last request received. ok is false if there was none.
+//
+//nolint:all
+func (r *Recorder) Last() (received Received, ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.received) == 0 {
+		return Received{}, false
+	}
+	return r.received[len(r.received)-1], true
+}
+
+// Reset forgets the requests received so far.
+//
+//nolint:all
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	r.received = nil
+	r.mu.Unlock()
+}
+
+// NewHandler creates the middleware with config in front of a new Recorder,
+// failing t if the configuration is invalid. The middleware is closed when
+// the test ends.
+//
+//nolint:all
+func NewHandler(t testing.TB, config *swissknife.Config) (http.Handler, *Recorder) {
+	t.Helper()
+
+	recorder := NewRecorder()
+	handler, err := swissknife.NewWithOptions(context.Background(), recorder, config, t.Name(), swissknife.Options{ContextInfo: true})
+	if err != nil {
+		t.Fatalf("creating middleware: %v", err)
+	}
+	if closer, ok := handler.(io.Closer); ok {
+		t.Cleanup(func() { _ = closer.Close() })
+	}
+	return handler, recorder
+}
+
+// AssertErrorResponse checks that rec holds an error response with the status
+// code and message, in the default JSON format of the middleware.
+//
+//nolint:all
+func AssertErrorResponse(t testing.TB, rec *httptest.ResponseRecorder, statusCode int, message string) {
+	t.Helper()
+
+	want, err := json.Marshal(swissknife.Response{Message: message, StatusCode: statusCode})
+	if err != nil {
+		t.Fatalf("encoding expected body: %v", err)
+	}
+	if rec.Code != statusCode {
+		t.Errorf("status code = %d, want %d", rec.Code, statusCode)
+	}
+	AssertJSON(t, rec.Body.Bytes(), string(want))
+}
+
+// AssertJSON checks that got and want encode the same JSON value, ignoring
+// formatting and the order of object fields.
+//
+//nolint:all
+func AssertJSON(t testing.TB, got []byte, want string) {
+	t.Helper()
+
+	var gotValue, wantValue interface{}
+	if err := json.Unmarshal([]byte(want), &wantValue); err != nil {
+		t.Fatalf("invalid expected JSON: %v", err)
+	}
+	if err := json.Unmarshal(got, &gotValue); err != nil {
+		t.Errorf("body is not JSON: %v\n%s", err, got)
+		return
+	}
+	if !reflect.DeepEqual(gotValue, wantValue) {
+		t.Errorf("body = %s\nwant %s", bytes.TrimSpace(got), want)
+	}
+}
+
+// AssertGoldenJSON checks got against the JSON in the golden file path, as
+// AssertJSON does. When the SWISSKNIFETEST_UPDATE environment variable is
+// set, the file is written with got instead.
+//
+//nolint:all
+func AssertGoldenJSON(t testing.TB, got []byte, path string) {
+	t.Helper()
+
+	if os.Getenv("SWISSKNIFETEST_UPDATE") != "" {
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, got, "", "  "); err != nil {
+			t.Fatalf("body is not JSON: %v\n%s", err, got)
+		}
+		indented.WriteByte('\n')
+		if err := os.WriteFile(path, indented.Bytes(), 0o644); err != nil {
+			t.Fatalf("updating golden file: %v", err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading golden file: %v", err)
+	}
+	AssertJSON(t, got, string(want))
+}
@@ -0,0 +1,297 @@
+package swissknifetest
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	swissknife "github.com/quangnguyen/swiss-knife"
+)
+
+func TestNewAuthorizedRequest(t *testing.T) {
+	cookieKey := func(req *http.Request) string {
+		cookie, err := req.Cookie("api_key")
+		if err != nil {
+			return ""
+		}
+		return cookie.Value
+	}
+
+	tests := []struct {
+		name   string
+		opts   []RequestOption
+		key    func(*http.Request) string
+		method string
+		url    string
+	}{
+		{
+			name:   "default header",
+			key:    func(req *http.Request) string { return req.Header.Get(DefaultHeader) },
+			method: http.MethodGet,
+			url:    "http://example.com/",
+		},
+		{
+			name:   "custom header",
+			opts:   []RequestOption{InHeader("X-Token")},
+			key:    func(req *http.Request) string { return req.Header.Get("X-Token") },
+			method: http.MethodGet,
+			url:    "http://example.com/",
+		},
+		{
+			name:   "bearer",
+			opts:   []RequestOption{AsBearer()},
+			key:    func(req *http.Request) string { return strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ") },
+			method: http.MethodGet,
+			url:    "http://example.com/",
+		},
+		{
+			name:   "query param",
+			opts:   []RequestOption{WithTarget("/orders?page=2"), InQuery("api_key")},
+			key:    func(req *http.Request) string { return req.URL.Query().Get("api_key") },
+			method: http.MethodGet,
+			url:    "/orders?api_key=secret-key-1&page=2",
+		},
+		{
+			name:   "cookie",
+			opts:   []RequestOption{InCookie("api_key")},
+			key:    cookieKey,
+			method: http.MethodGet,
+			url:    "http://example.com/",
+		},
+		{
+			name:   "method and target",
+			opts:   []RequestOption{WithMethod(http.MethodPut), WithTarget("https://api.example.com/orders/1")},
+			key:    func(req *http.Request) string { return req.Header.Get(DefaultHeader) },
+			method: http.MethodPut,
+			url:    "https://api.example.com/orders/1",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := NewAuthorizedRequest("secret-key-1", test.opts...)
+			if got := test.key(req); got != "secret-key-1" {
+				t.Errorf("key = %q, want %q", got, "secret-key-1")
+			}
+			if req.Method != test.method {
+				t.Errorf("method = %q, want %q", req.Method, test.method)
+			}
+			if got := req.URL.String(); got != test.url {
+				t.Errorf("URL = %q, want %q", got, test.url)
+			}
+		})
+	}
+}
+
+func TestNewAuthorizedRequestBodyAndHeaders(t *testing.T) {
+	req := NewAuthorizedRequest("secret-key-1",
+		WithMethod(http.MethodPost),
+		WithBody(`{"item":1}`),
+		WithHeader("Content-Type", "application/json"),
+		WithHeader("X-Tag", "a"),
+		WithHeader("X-Tag", "b"))
+
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != `{"item":1}` {
+		t.Errorf("body = %q, want %q", body, `{"item":1}`)
+	}
+	if got := req.Header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q, want %q", got, "application/json")
+	}
+	if got := req.Header.Values("X-Tag"); len(got) != 2 {
+		t.Errorf("X-Tag = %q, want two values", got)
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	config := swissknife.CreateConfig()
+	config.KeyEntries = []swissknife.KeyEntry{{Name: "billing", Key: "secret-key-1"}}
+	handler, upstream := NewHandler(t, config)
+
+	if _, ok := upstream.Last(); ok {
+		t.Fatal("Last() ok = true before any request")
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, NewAuthorizedRequest("secret-key-1",
+		WithMethod(http.MethodPost),
+		WithTarget("/orders"),
+		WithBody(`{"item":1}`),
+		WithHeader("X-Tag", "a")))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status code = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	received, ok := upstream.Last()
+	if !ok {
+		t.Fatal("Last() ok = false, want the forwarded request")
+	}
+	if received.Method != http.MethodPost || received.URL != "/orders" {
+		t.Errorf("request = %s %s, want POST /orders", received.Method, received.URL)
+	}
+	if string(received.Body) != `{"item":1}` {
+		t.Errorf("body = %q, want %q", received.Body, `{"item":1}`)
+	}
+	if received.Header.Get("X-Tag") != "a" {
+		t.Errorf("X-Tag = %q, want %q", received.Header.Get("X-Tag"), "a")
+	}
+	if received.Header.Get(DefaultHeader) != "" {
+		t.Error("key header was forwarded")
+	}
+	if received.Header.Get("X-Consumer-Name") != "billing" {
+		t.Errorf("consumer header = %q, want %q", received.Header.Get("X-Consumer-Name"), "billing")
+	}
+	if !received.Authorized || received.AuthInfo.KeyName != "billing" || received.AuthInfo.Source != "header" {
+		t.Errorf("auth info = %+v, authorized %v", received.AuthInfo, received.Authorized)
+	}
+	if info, ok := swissknife.FromContext(received.Context); !ok || info.KeyName != "billing" {
+		t.Errorf("context auth info = %+v, %v", info, ok)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, NewAuthorizedRequest("wrong-key"))
+	if got := len(upstream.Requests()); got != 1 {
+		t.Errorf("requests = %d after a rejected request, want 1", got)
+	}
+
+	upstream.Reset()
+	if got := len(upstream.Requests()); got != 0 {
+		t.Errorf("requests = %d after Reset, want 0", got)
+	}
+}
+
+func TestRecorderConcurrent(t *testing.T) {
+	config := swissknife.CreateConfig()
+	config.Keys = []string{"secret-key-1"}
+	handler, upstream := NewHandler(t, config)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				handler.ServeHTTP(httptest.NewRecorder(), NewAuthorizedRequest("secret-key-1"))
+				_, _ = upstream.Last()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := len(upstream.Requests()); got != 80 {
+		t.Errorf("requests = %d, want 80", got)
+	}
+}
+
+// fakeTB records the failures of an assertion instead of failing the test.
+type fakeTB struct {
+	testing.TB
+	errors []string
+}
+
+func (f *fakeTB) Helper() {}
+
+func (f *fakeTB) Errorf(format string, args ...interface{}) {
+	f.errors = append(f.errors, format)
+}
+
+func (f *fakeTB) Fatalf(format string, args ...interface{}) {
+	f.Errorf(format, args...)
+	runtime.Goexit()
+}
+
+// check runs assertion with a fakeTB and returns the failures it reported.
+func check(assertion func(t testing.TB)) []string {
+	f := &fakeTB{}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		assertion(f)
+	}()
+	<-done
+	return f.errors
+}
+
+func TestAssertJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		got  string
+		want string
+		fail bool
+	}{
+		{name: "equal", got: `{"a":1,"b":[1,2]}`, want: `{"a":1,"b":[1,2]}`},
+		{name: "field order and spacing", got: "{\"b\":[1,2],\"a\":1}\n", want: `{ "a": 1, "b": [1, 2] }`},
+		{name: "different value", got: `{"a":1}`, want: `{"a":2}`, fail: true},
+		{name: "extra field", got: `{"a":1,"b":2}`, want: `{"a":1}`, fail: true},
+		{name: "not JSON", got: `Invalid API Key`, want: `{"a":1}`, fail: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			failures := check(func(tb testing.TB) { AssertJSON(tb, []byte(test.got), test.want) })
+			if failed := len(failures) > 0; failed != test.fail {
+				t.Errorf("failed = %v (%q), want %v", failed, failures, test.fail)
+			}
+		})
+	}
+}
+
+func TestAssertErrorResponse(t *testing.T) {
+	config := swissknife.CreateConfig()
+	config.Keys = []string{"secret-key-1"}
+	handler, _ := NewHandler(t, config)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, NewAuthorizedRequest("wrong-key"))
+
+	AssertErrorResponse(t, rec, http.StatusForbidden, "Invalid API Key")
+	if failures := check(func(tb testing.TB) { AssertErrorResponse(tb, rec, http.StatusUnauthorized, "Invalid API Key") }); len(failures) == 0 {
+		t.Error("AssertErrorResponse() with the wrong status code did not fail")
+	}
+	if failures := check(func(tb testing.TB) { AssertErrorResponse(tb, rec, http.StatusForbidden, "Missing API key") }); len(failures) == 0 {
+		t.Error("AssertErrorResponse() with the wrong message did not fail")
+	}
+}
+
+func TestAssertGoldenJSON(t *testing.T) {
+	config := swissknife.CreateConfig()
+	config.Keys = []string{"secret-key-1"}
+	handler, _ := NewHandler(t, config)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, NewAuthorizedRequest("wrong-key"))
+	AssertGoldenJSON(t, rec.Body.Bytes(), filepath.Join("testdata", "invalid_key.json"))
+
+	if failures := check(func(tb testing.TB) {
+		AssertGoldenJSON(tb, []byte(`{"message":"Missing API key","statusCode":401}`), filepath.Join("testdata", "invalid_key.json"))
+	}); len(failures) == 0 {
+		t.Error("AssertGoldenJSON() with a different body did not fail")
+	}
+	if failures := check(func(tb testing.TB) {
+		AssertGoldenJSON(tb, rec.Body.Bytes(), filepath.Join("testdata", "missing.json"))
+	}); len(failures) == 0 {
+		t.Error("AssertGoldenJSON() without golden file did not fail")
+	}
+}
+
+func TestAssertGoldenJSONUpdate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "golden.json")
+	t.Setenv("SWISSKNIFETEST_UPDATE", "1")
+	AssertGoldenJSON(t, []byte(`{"statusCode":403,"message":"Invalid API Key"}`), path)
+
+	written, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "{\n  \"statusCode\": 403,\n  \"message\": \"Invalid API Key\"\n}\n"
+	if string(written) != want {
+		t.Errorf("golden file = %q, want %q", written, want)
+	}
+}
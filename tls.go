@@ -0,0 +1,148 @@
+package swissknife
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+//nolint:all
+type ValidationTLS struct {
+	CAFile             string `json:"caFile,omitempty"`
+	CertFile           string `json:"certFile,omitempty"`
+	KeyFile            string `json:"keyFile,omitempty"`
+	InsecureSkipVerify bool   `json:"insecureSkipVerify,omitempty"`
+	ReloadInterval     string `json:"reloadInterval,omitempty"`
+}
+
+// newValidationTransport returns the transport of the validation client, with
+// its own connection pool and TLS settings.
+func newValidationTransport(config *Config) (*http.Transport, error) {
+	if config.ValidationMaxIdleConns < 0 {
+		return nil, errors.New("validation max idle conns must not be negative")
+	}
+	idleConnTimeout, err := time.ParseDuration(config.ValidationIdleConnTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("invalid validation idle conn timeout: %w", err)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = config.ValidationMaxIdleConns
+	transport.MaxIdleConnsPerHost = config.ValidationMaxIdleConns
+	transport.IdleConnTimeout = idleConnTimeout
+
+	tlsConfig, err := newValidationTLSConfig(config.ValidationTLS)
+	if err != nil {
+		return nil, err
+	}
+	transport.TLSClientConfig = tlsConfig
+	return transport, nil
+}
+
+func newValidationTLSConfig(config ValidationTLS) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		//nolint:gosec // Opted into explicitly, for test environments.
+		InsecureSkipVerify: config.InsecureSkipVerify,
+	}
+
+	if config.CAFile != "" {
+		pem, err := os.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading validation CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("validation CA file contains no certificates")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if config.CertFile == "" && config.KeyFile == "" {
+		return tlsConfig, nil
+	}
+	if config.CertFile == "" || config.KeyFile == "" {
+		return nil, errors.New("validation cert file and key file must be set together")
+	}
+
+	interval, err := time.ParseDuration(config.ReloadInterval)
+	if err != nil {
+		return nil, fmt.Errorf("invalid validation TLS reload interval: %w", err)
+	}
+	reloader := &certReloader{certFile: config.CertFile, keyFile: config.KeyFile, interval: interval}
+	if err := reloader.load(time.Now()); err != nil {
+		return nil, err
+	}
+	tlsConfig.GetClientCertificate = reloader.clientCertificate
+	return tlsConfig, nil
+}
+
+// certReloader serves the client certificate, loading it again when its files
+// changed. The files are checked at most once per interval.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	interval time.Duration
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	checkedAt time.Time
+}
+
+func (cr *certReloader) clientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	now := time.Now()
+	if cr.interval > 0 && now.Sub(cr.checkedAt) >= cr.interval {
+		// A failed reload keeps the current certificate.
+		_ = cr.reloadIfChanged(now)
+	}
+	return cr.cert, nil
+}
+
+func (cr *certReloader) load(now time.Time) error {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	return cr.reloadIfChanged(now)
+}
+
+func (cr *certReloader) reloadIfChanged(now time.Time) error {
+	cr.checkedAt = now
+
+	modTime, err := latestModTime(cr.certFile, cr.keyFile)
+	if err != nil {
+		return fmt.Errorf("reading validation client certificate: %w", err)
+	}
+	if cr.cert != nil && !modTime.After(cr.modTime) {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return fmt.Errorf("loading validation client certificate: %w", err)
+	}
+	cr.cert = &cert
+	cr.modTime = modTime
+	return nil
+}
+
+func latestModTime(paths ...string) (time.Time, error) {
+	var latest time.Time
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
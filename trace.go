@@ -0,0 +1,73 @@
+package swissknife
+
+import (
+	"net/http"
+	"strings"
+)
+
+// authDecisionHeader tells a tracing proxy in front of the plugin what it
+// decided, so spans can be tagged without parsing the logs.
+const authDecisionHeader = "X-Auth-Decision"
+
+// traceID returns the trace ID of the W3C traceparent header of req, or ""
+// when trace headers are disabled or the header is missing or invalid.
+func (ka *SwissKnife) traceID(req *http.Request) string {
+	if !ka.traceHeaders {
+		return ""
+	}
+	return requestTraceID(req)
+}
+
+func requestTraceID(req *http.Request) string {
+	id, _ := parseTraceparent(req.Header.Get("Traceparent"))
+	return id
+}
+
+// parseTraceparent returns the trace ID of a traceparent header, written
+// version-traceid-parentid-flags in lowercase hex. Versions after 00 may add
+// fields, which are ignored.
+func parseTraceparent(value string) (string, bool) {
+	value = strings.TrimSpace(value)
+	if len(value) < 55 {
+		return "", false
+	}
+
+	version, traceID, parentID, flags := value[0:2], value[3:35], value[36:52], value[53:55]
+	if value[2] != '-' || value[35] != '-' || value[52] != '-' {
+		return "", false
+	}
+	if !isLowerHex(version) || version == "ff" || (version == "00" && len(value) != 55) || (len(value) > 55 && value[55] != '-') {
+		return "", false
+	}
+	if !isLowerHex(traceID) || traceID == strings.Repeat("0", 32) {
+		return "", false
+	}
+	if !isLowerHex(parentID) || parentID == strings.Repeat("0", 16) || !isLowerHex(flags) {
+		return "", false
+	}
+	return traceID, true
+}
+
+func isLowerHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// authDecision returns the value of the decision header: allow for requests
+// passed on with a key or as anonymous, bypass for requests that did not need
+// one, and deny otherwise.
+func authDecision(d decision) string {
+	switch {
+	case d.outcome == outcomeBypassed:
+		return "bypass"
+	case d.allowed():
+		return "allow"
+	default:
+		return "deny"
+	}
+}
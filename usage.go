@@ -0,0 +1,190 @@
+package swissknife
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const usageSendTimeout = 5 * time.Second
+
+// usageEvent describes a request forwarded with a valid key.
+type usageEvent struct {
+	Time   string `json:"time"`
+	Key    string `json:"key,omitempty"`
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	Status int    `json:"status"`
+}
+
+type usageReport struct {
+	Plugin string       `json:"plugin"`
+	Events []usageEvent `json:"events"`
+}
+
+// usageReporter buffers the usage events and posts them to a URL every
+// flushInterval, or as soon as flushEvents are buffered. Reports are sent
+// once, and lost if the post fails. Events arriving while the buffer is full
+// are dropped and counted.
+type usageReporter struct {
+	url           string
+	flushInterval time.Duration
+	flushEvents   int
+	bufferSize    int
+	client        *http.Client
+	plugin        string
+	logger        *logger
+
+	mu      sync.Mutex
+	events  []usageEvent
+	flush   chan struct{}
+	dropped int64
+}
+
+func newUsageReporterFromConfig(config *Config, name string, logger *logger) (*usageReporter, error) {
+	if config.UsageReportURL == "" {
+		return nil, nil
+	}
+	u, err := url.ParseRequestURI(config.UsageReportURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, errors.New("usage report URL must be an http or https URL")
+	}
+
+	flushInterval, err := time.ParseDuration(config.UsageFlushInterval)
+	if err != nil {
+		return nil, fmt.Errorf("invalid usage flush interval: %w", err)
+	}
+	if flushInterval <= 0 {
+		return nil, errors.New("usage flush interval must be positive")
+	}
+	if config.UsageFlushEvents <= 0 || config.UsageBufferSize < config.UsageFlushEvents {
+		return nil, errors.New("usage flush events must be positive, with a buffer size of at least the flush events")
+	}
+
+	return &usageReporter{
+		url:           config.UsageReportURL,
+		flushInterval: flushInterval,
+		flushEvents:   config.UsageFlushEvents,
+		bufferSize:    config.UsageBufferSize,
+		client:        newHTTPClient(usageSendTimeout),
+		plugin:        name,
+		logger:        logger,
+		flush:         make(chan struct{}, 1),
+	}, nil
+}
+
+// add buffers event without blocking.
+func (ur *usageReporter) add(event usageEvent) {
+	ur.mu.Lock()
+	if len(ur.events) >= ur.bufferSize {
+		ur.mu.Unlock()
+		atomic.AddInt64(&ur.dropped, 1)
+		return
+	}
+	ur.events = append(ur.events, event)
+	full := len(ur.events) >= ur.flushEvents
+	ur.mu.Unlock()
+
+	if full {
+		select {
+		case ur.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// run sends the buffered events until ctx is done, then sends the events
+// left in the buffer.
+func (ur *usageReporter) run(ctx context.Context) {
+	defer ur.flushPending()
+
+	ticker := time.NewTicker(ur.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-ur.flush:
+		}
+
+		ur.mu.Lock()
+		events := ur.events
+		ur.events = nil
+		ur.mu.Unlock()
+		if len(events) == 0 {
+			continue
+		}
+		// Sent in full even if ctx is done meanwhile, within the client timeout.
+		if err := ur.send(context.Background(), events); err != nil {
+			ur.logger.error(nil, "", fmt.Sprintf("Error sending usage report of %d events: %s", len(events), err.Error()))
+		}
+	}
+}
+
+// flushPending sends the buffered events within flushTimeout.
+func (ur *usageReporter) flushPending() {
+	ur.mu.Lock()
+	events := ur.events
+	ur.events = nil
+	ur.mu.Unlock()
+	if len(events) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+	defer cancel()
+	if err := ur.send(ctx, events); err != nil {
+		ur.logger.error(nil, "", fmt.Sprintf("Error sending usage report of %d events: %s", len(events), err.Error()))
+	}
+}
+
+func (ur *usageReporter) send(ctx context.Context, events []usageEvent) error {
+	body, err := json.Marshal(usageReport{Plugin: ur.plugin, Events: events})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ur.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := ur.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("usage endpoint returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (ur *usageReporter) writeMetrics(b *strings.Builder, prefix string) {
+	fmt.Fprintf(b, "# HELP %s_usage_dropped_total Usage events dropped because the buffer was full.\n# TYPE %s_usage_dropped_total counter\n", prefix, prefix)
+	fmt.Fprintf(b, "%s_usage_dropped_total %d\n", prefix, atomic.LoadInt64(&ur.dropped))
+}
+
+// reportUsage buffers the usage of a request forwarded with a valid key.
+func (ka *SwissKnife) reportUsage(req *http.Request, d decision, status int) {
+	ka.usage.add(usageEvent{
+		Time:   ka.now().UTC().Format(time.RFC3339Nano),
+		Key:    d.consumerName(),
+		Method: req.Method,
+		Path:   req.URL.Path,
+		Status: status,
+	})
+}
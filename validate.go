@@ -0,0 +1,274 @@
+package swissknife
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// unusableCredentialHeaders are the headers that cannot carry a key: they are
+// consumed by the server, removed by proxies, or have a syntax of their own.
+var unusableCredentialHeaders = map[string]struct{}{
+	"Host":              {},
+	"Content-Length":    {},
+	"Content-Type":      {},
+	"Transfer-Encoding": {},
+	"Connection":        {},
+	"Upgrade":           {},
+	"Te":                {},
+	"Trailer":           {},
+	"Cookie":            {},
+}
+
+// Validate checks the configuration and returns all the problems found,
+// joined with errors.Join. Header names are trimmed and normalized to their
+// canonical form. Options that are parsed when the plugin is created, such as
+// durations and patterns, are checked by New.
+//
+//nolint:all
+func (c *Config) Validate() error {
+	return c.validate(Options{})
+}
+
+func (c *Config) validate(options Options) error {
+	c.trimHeaderNames()
+
+	var errs []error
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if len(c.Keys) == 0 && c.KeysCSV == "" && len(c.KeyEntries) == 0 && len(c.KeyGroups) == 0 && len(c.KeyPatterns) == 0 && c.KeysFile == "" && c.KeysDir == "" && c.ValidationURL == "" && len(options.KeyStores) == 0 && len(c.SignatureAuth.Secrets) == 0 && c.JWT.HS256Secret == "" && c.JWT.JwksURL == "" && !hasClientCertAuth(c) {
+		fail("must specify at least one valid key")
+	}
+	if !c.AuthenticationHeader && !c.BearerHeader && !c.QueryParam && !c.Cookie && !c.WebSocketProtocolAuth && !c.BasicAuth && !c.BodyAuth.Enabled && !c.Rfc6750Sources && len(options.Extractors) == 0 && len(c.SignatureAuth.Secrets) == 0 && !hasClientCertAuth(c) {
+		fail("at least one header type, query param, cookie, WebSocket protocol, basic, body auth or RFC 6750 sources must be true")
+	}
+
+	if c.AuthenticationHeader {
+		if len(c.AuthenticationHeaderNames) == 0 {
+			if c.AuthenticationHeaderName == "" {
+				fail("header name must be set when authentication header is true")
+			} else if err := checkCredentialHeader(c.AuthenticationHeaderName); err != nil {
+				fail("invalid header name: %w", err)
+			}
+		}
+		seen := make(map[string]struct{})
+		for i, name := range c.AuthenticationHeaderNames {
+			if name == "" {
+				fail("authentication header names must not contain empty entries")
+				continue
+			}
+			if err := checkCredentialHeader(name); err != nil {
+				fail("invalid authentication header name at index %d: %w", i, err)
+				continue
+			}
+			if _, exists := seen[http.CanonicalHeaderKey(name)]; exists {
+				fail("duplicate authentication header name: %s", name)
+			}
+			seen[http.CanonicalHeaderKey(name)] = struct{}{}
+		}
+	}
+
+	if c.BearerHeader || c.BasicAuth {
+		if err := checkCredentialHeader(c.BearerHeaderName); err != nil {
+			fail("invalid bearer header name: %w", err)
+		}
+	}
+	if c.BearerHeader {
+		if len(c.BearerSchemes) == 0 {
+			fail("at least one bearer scheme must be set when bearer header is true")
+		}
+		for _, scheme := range c.BearerSchemes {
+			if scheme == "" || strings.ContainsAny(scheme, " \t") {
+				fail("invalid bearer scheme %q", scheme)
+			}
+		}
+	}
+	if c.Rfc6750Compliant && !c.BearerHeader {
+		fail("bearer header must be true when rfc6750 compliant is true")
+	}
+	if c.Cookie && c.CookieName == "" {
+		fail("cookie name must be set when cookie is true")
+	}
+
+	errs = append(errs, validateKeyGroups(c.KeyGroups, c.TrimKeys)...)
+
+	if c.ConsumerHeader != "" {
+		if err := checkHeaderName(c.ConsumerHeader); err != nil {
+			fail("invalid consumer header: %w", err)
+		}
+	}
+	if err := validateKeyPrefixes(c.KeyPrefixes); err != nil {
+		errs = append(errs, err)
+	}
+	if len(c.KeyPrefixes) > 0 && c.ForwardPrefixHeader != "" {
+		if err := checkHeaderName(c.ForwardPrefixHeader); err != nil {
+			fail("invalid forward prefix header: %w", err)
+		}
+	}
+	if usesUpstreamToken(c) {
+		if err := checkHeaderName(c.UpstreamTokenHeader); err != nil {
+			fail("invalid upstream token header: %w", err)
+		}
+		if err := checkUpstreamToken(c.UpstreamToken); err != nil {
+			errs = append(errs, err)
+		}
+		if c.UpstreamTokenScheme != "" && !validHeaderName(c.UpstreamTokenScheme) {
+			fail("invalid upstream token scheme %q", c.UpstreamTokenScheme)
+		}
+	}
+	if c.ValidationURL != "" && c.ValidationHeader != "" {
+		if err := checkHeaderName(c.ValidationHeader); err != nil {
+			fail("invalid validation header: %w", err)
+		}
+	}
+
+	if c.ErrorFormat != "simple" && c.ErrorFormat != "problem" {
+		fail("error format must be simple or problem, got %q", c.ErrorFormat)
+	}
+	if c.FailurePolicy != "closed" && c.FailurePolicy != "open" {
+		fail("failure policy must be closed or open, got %q", c.FailurePolicy)
+	}
+	if c.ValidationUnavailableStatusCode < 300 || c.ValidationUnavailableStatusCode > 599 {
+		fail("validation unavailable status code must be between 300 and 599, got %d", c.ValidationUnavailableStatusCode)
+	}
+	if c.UnauthorizedStatusCode < 300 || c.UnauthorizedStatusCode > 599 {
+		fail("unauthorized status code must be between 300 and 599, got %d", c.UnauthorizedStatusCode)
+	}
+	if c.DistinguishMissingCredential && (c.MissingCredentialStatusCode < 300 || c.MissingCredentialStatusCode > 599) {
+		fail("missing credential status code must be between 300 and 599, got %d", c.MissingCredentialStatusCode)
+	}
+
+	if c.MinKeyLength < 0 {
+		fail("min key length must not be negative")
+	}
+	if c.MaxCredentialLength < 0 {
+		fail("max credential length must not be negative")
+	}
+	if c.ForwardedDepth < 0 {
+		fail("forwarded depth must not be negative")
+	}
+	if len(c.TrustedProxies) > 0 {
+		if c.ForwardedDepth > 0 {
+			fail("forwarded depth and trusted proxies cannot be used together")
+		}
+		if c.ClientIPHeader == "" {
+			fail("client IP header must be set when trusted proxies are set")
+		}
+	}
+	if c.ClientCertAuth.Header != "" && len(c.TrustedProxies) == 0 {
+		fail("client certificate header requires trusted proxies")
+	}
+	if c.ClientCertAuth.HeaderVerified && c.ClientCertAuth.Header == "" {
+		fail("verified client certificate header requires a header")
+	}
+	if c.ClientIPHeader != "" {
+		if err := checkHeaderName(c.ClientIPHeader); err != nil {
+			fail("invalid client IP header: %w", err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	c.normalizeHeaderNames()
+	return nil
+}
+
+// checkCredentialHeader checks that keys can be read from the header name.
+func checkCredentialHeader(name string) error {
+	if err := checkHeaderName(name); err != nil {
+		return err
+	}
+	if _, ok := unusableCredentialHeaders[http.CanonicalHeaderKey(name)]; ok {
+		return fmt.Errorf("%s cannot carry a key", http.CanonicalHeaderKey(name))
+	}
+	return nil
+}
+
+// trimHeaderNames removes the whitespace around the header names, which is
+// easy to leave in YAML and would otherwise make them invalid.
+func (c *Config) trimHeaderNames() {
+	c.AuthenticationHeaderName = strings.TrimSpace(c.AuthenticationHeaderName)
+	names := make([]string, 0, len(c.AuthenticationHeaderNames))
+	for _, name := range c.AuthenticationHeaderNames {
+		names = append(names, strings.TrimSpace(name))
+	}
+	c.AuthenticationHeaderNames = names
+	c.BearerHeaderName = strings.TrimSpace(c.BearerHeaderName)
+	c.ConsumerHeader = strings.TrimSpace(c.ConsumerHeader)
+	c.ForwardPrefixHeader = strings.TrimSpace(c.ForwardPrefixHeader)
+	c.UpstreamTokenHeader = strings.TrimSpace(c.UpstreamTokenHeader)
+	c.ValidationHeader = strings.TrimSpace(c.ValidationHeader)
+	c.ClientIPHeader = strings.TrimSpace(c.ClientIPHeader)
+}
+
+// normalizeHeaderNames replaces the header names of a valid configuration
+// with their canonical form.
+func (c *Config) normalizeHeaderNames() {
+	c.AuthenticationHeaderName = http.CanonicalHeaderKey(c.AuthenticationHeaderName)
+	names := make([]string, 0, len(c.AuthenticationHeaderNames))
+	for _, name := range c.AuthenticationHeaderNames {
+		names = append(names, http.CanonicalHeaderKey(name))
+	}
+	c.AuthenticationHeaderNames = names
+	c.BearerHeaderName = http.CanonicalHeaderKey(c.BearerHeaderName)
+	c.ConsumerHeader = http.CanonicalHeaderKey(c.ConsumerHeader)
+	c.ForwardPrefixHeader = http.CanonicalHeaderKey(c.ForwardPrefixHeader)
+	c.UpstreamTokenHeader = http.CanonicalHeaderKey(c.UpstreamTokenHeader)
+	c.ValidationHeader = http.CanonicalHeaderKey(c.ValidationHeader)
+	c.ClientIPHeader = http.CanonicalHeaderKey(c.ClientIPHeader)
+}
+
+// shadowedHeaders describes the headers read by both the authentication
+// header and the bearer header sources, where the source tried first hides
+// the credentials meant for the other.
+func (c *Config) shadowedHeaders() []string {
+	if !c.AuthenticationHeader || !c.BearerHeader {
+		return nil
+	}
+	var warnings []string
+	for _, name := range authenticationHeaderNames(c) {
+		if http.CanonicalHeaderKey(name) == http.CanonicalHeaderKey(c.BearerHeaderName) {
+			warnings = append(warnings, fmt.Sprintf("Header %s is both an authentication header and the bearer header, the source tried first shadows the other", http.CanonicalHeaderKey(name)))
+		}
+	}
+	return warnings
+}
+
+// duplicateKeys describes the keys configured more than once in keys,
+// keyEntries and keyGroups, which is allowed but usually a mistake. Keys
+// repeated in one group are harmless and not reported.
+func (c *Config) duplicateKeys() []string {
+	type position struct {
+		option string
+		index  int
+	}
+
+	var duplicates []string
+	seen := make(map[string]position)
+	check := func(key string, at position) {
+		if key == "" {
+			return
+		}
+		if first, ok := seen[key]; ok {
+			duplicates = append(duplicates, fmt.Sprintf("Duplicate key at index %d of %s, also at index %d of %s", at.index, at.option, first.index, first.option))
+			return
+		}
+		seen[key] = at
+	}
+	for i, key := range c.Keys {
+		check(key, position{option: "keys", index: i})
+	}
+	for i, entry := range c.KeyEntries {
+		check(entry.Key, position{option: "keyEntries", index: i})
+	}
+	for _, group := range c.KeyGroups {
+		for i, key := range group.Keys {
+			check(key, position{option: fmt.Sprintf("key group %q", group.Name), index: i})
+		}
+	}
+	return duplicates
+}
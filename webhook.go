@@ -0,0 +1,235 @@
+package swissknife
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	webhookQueueSize   = 1000
+	webhookSendTimeout = 5 * time.Second
+)
+
+//nolint:all
+type FailureWebhook struct {
+	URL         string            `json:"url,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	MinInterval string            `json:"minInterval,omitempty"`
+	BatchSize   int               `json:"batchSize,omitempty"`
+}
+
+// failureEvent describes a rejected request. The key is only identified by
+// its fingerprint.
+type failureEvent struct {
+	Time     string `json:"time"`
+	Outcome  string `json:"outcome"`
+	Method   string `json:"method"`
+	Path     string `json:"path"`
+	ClientIP string `json:"clientIP,omitempty"`
+	Key      string `json:"key,omitempty"`
+}
+
+type failureBatch struct {
+	Plugin  string         `json:"plugin"`
+	Dropped int64          `json:"dropped"`
+	Events  []failureEvent `json:"events"`
+}
+
+// failureWebhook posts the rejected requests to a URL in batches, at most
+// one batch every minInterval. Events are queued so that requests never wait
+// for the webhook; when the queue is full, the oldest events are dropped and
+// counted in the next batch.
+type failureWebhook struct {
+	url         string
+	headers     http.Header
+	minInterval time.Duration
+	batchSize   int
+	client      *http.Client
+	plugin      string
+	logger      *logger
+
+	queue   chan failureEvent
+	dropped int64
+}
+
+func newFailureWebhook(config FailureWebhook, name string, logger *logger) (*failureWebhook, error) {
+	if config.URL == "" {
+		return nil, nil
+	}
+	u, err := url.ParseRequestURI(config.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, errors.New("failure webhook URL must be an http or https URL")
+	}
+
+	headers := make(http.Header, len(config.Headers))
+	for name, value := range config.Headers {
+		if !validHeaderName(name) {
+			return nil, fmt.Errorf("invalid failure webhook header %q", name)
+		}
+		headers.Set(name, value)
+	}
+
+	minInterval, err := time.ParseDuration(config.MinInterval)
+	if err != nil {
+		return nil, fmt.Errorf("invalid failure webhook min interval: %w", err)
+	}
+	if minInterval <= 0 {
+		return nil, errors.New("failure webhook min interval must be positive")
+	}
+	if config.BatchSize <= 0 {
+		return nil, errors.New("failure webhook batch size must be positive")
+	}
+
+	return &failureWebhook{
+		url:         config.URL,
+		headers:     headers,
+		minInterval: minInterval,
+		batchSize:   config.BatchSize,
+		client:      newHTTPClient(webhookSendTimeout),
+		plugin:      name,
+		logger:      logger,
+		queue:       make(chan failureEvent, webhookQueueSize),
+	}, nil
+}
+
+// enqueue queues event without blocking, dropping the oldest queued event
+// if the queue is full.
+func (fw *failureWebhook) enqueue(event failureEvent) {
+	for {
+		select {
+		case fw.queue <- event:
+			return
+		default:
+		}
+		select {
+		case <-fw.queue:
+			atomic.AddInt64(&fw.dropped, 1)
+		default:
+		}
+	}
+}
+
+// run sends the queued events until ctx is done, then sends the events left
+// in the queue.
+func (fw *failureWebhook) run(ctx context.Context) {
+	defer fw.flush()
+
+	timer := time.NewTimer(fw.minInterval)
+	defer timer.Stop()
+
+	for {
+		var batch []failureEvent
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-fw.queue:
+			batch = append(batch, event)
+		}
+	drain:
+		for len(batch) < fw.batchSize {
+			select {
+			case event := <-fw.queue:
+				batch = append(batch, event)
+			default:
+				break drain
+			}
+		}
+
+		// Not cut short when ctx is done: the client timeout bounds it.
+		if err := fw.send(context.Background(), batch); err != nil {
+			fw.logger.error(nil, "", fmt.Sprintf("Error sending failure webhook: %s", err.Error()))
+		}
+
+		timer.Reset(fw.minInterval)
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// flush sends the queued events within flushTimeout.
+func (fw *failureWebhook) flush() {
+	ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+	defer cancel()
+
+	for {
+		var batch []failureEvent
+	drain:
+		for len(batch) < fw.batchSize {
+			select {
+			case event := <-fw.queue:
+				batch = append(batch, event)
+			default:
+				break drain
+			}
+		}
+		if len(batch) == 0 {
+			return
+		}
+		if err := fw.send(ctx, batch); err != nil {
+			fw.logger.error(nil, "", fmt.Sprintf("Error sending failure webhook: %s", err.Error()))
+			return
+		}
+	}
+}
+
+func (fw *failureWebhook) send(ctx context.Context, events []failureEvent) error {
+	body, err := json.Marshal(failureBatch{
+		Plugin:  fw.plugin,
+		Dropped: atomic.SwapInt64(&fw.dropped, 0),
+		Events:  events,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fw.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, values := range fw.headers {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := fw.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// notifyFailure queues a rejected request for the failure webhook.
+func (ka *SwissKnife) notifyFailure(req *http.Request, d decision) {
+	event := failureEvent{
+		Time:    ka.now().UTC().Format(time.RFC3339Nano),
+		Outcome: d.outcome,
+		Method:  req.Method,
+		Path:    req.URL.Path,
+	}
+	if addr, ok := ka.requestIP(req); ok {
+		event.ClientIP = addr.String()
+	}
+	for _, c := range d.credentials {
+		if c.value != "" {
+			event.Key = fingerprint(c.value)
+			break
+		}
+	}
+	ka.webhook.enqueue(event)
+}
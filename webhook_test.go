@@ -0,0 +1,203 @@
+package swissknife
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookReceiver records the batches posted to it.
+type webhookReceiver struct {
+	mu      sync.Mutex
+	batches []failureBatch
+	headers []http.Header
+	posted  chan struct{}
+}
+
+func newWebhookReceiver(t *testing.T) (*webhookReceiver, *httptest.Server) {
+	t.Helper()
+
+	r := &webhookReceiver{posted: make(chan struct{}, 100)}
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		var batch failureBatch
+		if err := json.NewDecoder(req.Body).Decode(&batch); err != nil {
+			t.Errorf("decoding webhook batch: %v", err)
+		}
+		r.mu.Lock()
+		r.batches = append(r.batches, batch)
+		r.headers = append(r.headers, req.Header.Clone())
+		r.mu.Unlock()
+		r.posted <- struct{}{}
+	}))
+	t.Cleanup(server.Close)
+	return r, server
+}
+
+// wait waits for n batches and returns the batches received.
+func (r *webhookReceiver) wait(t *testing.T, n int) []failureBatch {
+	t.Helper()
+
+	for i := 0; i < n; i++ {
+		select {
+		case <-r.posted:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("received %d batches, want %d", i, n)
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]failureBatch(nil), r.batches...)
+}
+
+func newTestWebhook(t *testing.T, url string, batchSize int) *failureWebhook {
+	t.Helper()
+
+	logger, err := newLogger(CreateConfig(), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw, err := newFailureWebhook(FailureWebhook{URL: url, MinInterval: "1ms", BatchSize: batchSize, Headers: map[string]string{"X-Token": "webhook-token"}}, "test", logger)
+	if err != nil {
+		t.Fatalf("newFailureWebhook() error = %v", err)
+	}
+	return fw
+}
+
+func TestFailureWebhookBatches(t *testing.T) {
+	tests := []struct {
+		name      string
+		events    int
+		batchSize int
+		want      []int
+	}{
+		{name: "single event", events: 1, batchSize: 3, want: []int{1}},
+		{name: "exact batches", events: 6, batchSize: 3, want: []int{3, 3}},
+		{name: "partial last batch", events: 7, batchSize: 3, want: []int{3, 3, 1}},
+		{name: "one batch", events: 5, batchSize: 100, want: []int{5}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			receiver, server := newWebhookReceiver(t)
+			fw := newTestWebhook(t, server.URL, test.batchSize)
+			for i := 0; i < test.events; i++ {
+				fw.enqueue(failureEvent{Path: "/" + strconv.Itoa(i)})
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				fw.run(ctx)
+				close(done)
+			}()
+			batches := receiver.wait(t, len(test.want))
+			cancel()
+			<-done
+
+			next := 0
+			for i, batch := range batches {
+				if len(batch.Events) != test.want[i] {
+					t.Errorf("batch %d has %d events, want %d", i, len(batch.Events), test.want[i])
+				}
+				for _, event := range batch.Events {
+					if want := "/" + strconv.Itoa(next); event.Path != want {
+						t.Errorf("event path = %q, want %q", event.Path, want)
+					}
+					next++
+				}
+			}
+			if got := receiver.headers[0].Get("X-Token"); got != "webhook-token" {
+				t.Errorf("X-Token = %q, want %q", got, "webhook-token")
+			}
+		})
+	}
+}
+
+func TestFailureWebhookBackpressure(t *testing.T) {
+	receiver, server := newWebhookReceiver(t)
+	fw := newTestWebhook(t, server.URL, webhookQueueSize)
+
+	const extra = 5
+	for i := 0; i < webhookQueueSize+extra; i++ {
+		fw.enqueue(failureEvent{Path: "/" + strconv.Itoa(i)})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	fw.run(ctx)
+
+	batches := receiver.wait(t, 1)
+	if batches[0].Dropped != extra {
+		t.Errorf("dropped = %d, want %d", batches[0].Dropped, extra)
+	}
+	if len(batches[0].Events) != webhookQueueSize {
+		t.Fatalf("batch has %d events, want %d", len(batches[0].Events), webhookQueueSize)
+	}
+	if got, want := batches[0].Events[0].Path, "/"+strconv.Itoa(extra); got != want {
+		t.Errorf("oldest event kept = %q, want %q", got, want)
+	}
+}
+
+func TestFailureWebhookDoesNotDelayRequests(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		<-release
+		rw.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	defer close(release)
+
+	config := CreateConfig()
+	config.Keys = []string{"secret-key-1"}
+	config.FailureWebhook.URL = server.URL
+	config.FailureWebhook.MinInterval = "1ms"
+	ka := newTestHandler(t, config, nil)
+	ka.SetLogger(&logRecorder{})
+
+	start := time.Now()
+	for i := 0; i < 2*webhookQueueSize; i++ {
+		if code := statusFor(ka, "wrong-key"); code != http.StatusForbidden {
+			t.Fatalf("status code = %d, want %d", code, http.StatusForbidden)
+		}
+	}
+	if code := statusFor(ka, "secret-key-1"); code != http.StatusOK {
+		t.Errorf("status code = %d, want %d", code, http.StatusOK)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("requests took %s with a stuck webhook", elapsed)
+	}
+}
+
+func TestFailureWebhookEvent(t *testing.T) {
+	receiver, server := newWebhookReceiver(t)
+
+	config := CreateConfig()
+	config.Keys = []string{"secret-key-1"}
+	config.FailureWebhook.URL = server.URL
+	config.FailureWebhook.MinInterval = "1ms"
+	ka := newTestHandler(t, config, nil)
+
+	req := httptest.NewRequest(http.MethodDelete, "/orders/1", nil)
+	req.Header.Set("X-API-KEY", "wrong-key")
+	ka.ServeHTTP(httptest.NewRecorder(), req)
+
+	batches := receiver.wait(t, 1)
+	if len(batches[0].Events) != 1 {
+		t.Fatalf("batch has %d events, want 1", len(batches[0].Events))
+	}
+	event := batches[0].Events[0]
+	want := failureEvent{Time: event.Time, Outcome: outcomeRejected, Method: http.MethodDelete, Path: "/orders/1", ClientIP: "192.0.2.1", Key: fingerprint("wrong-key")}
+	if event != want {
+		t.Errorf("event = %+v, want %+v", event, want)
+	}
+	if _, err := time.Parse(time.RFC3339Nano, event.Time); err != nil {
+		t.Errorf("event time %q: %v", event.Time, err)
+	}
+	if batches[0].Plugin != "test" {
+		t.Errorf("plugin = %q, want %q", batches[0].Plugin, "test")
+	}
+}
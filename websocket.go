@@ -0,0 +1,83 @@
+package swissknife
+
+import (
+	"net/http"
+	"strings"
+)
+
+const (
+	sourceWebSocket = "websocket"
+
+	webSocketProtocolHeader = "Sec-Websocket-Protocol"
+	webSocketKeyPrefix      = "api-key."
+)
+
+// webSocketExtractor finds keys sent as a subprotocol of a WebSocket
+// handshake, "api-key.<key>", as browsers cannot set other headers on
+// WebSocket connections. Requests that are not upgrades are ignored.
+type webSocketExtractor struct{}
+
+func (e *webSocketExtractor) Extract(req *http.Request) (string, bool) {
+	credentials, found := e.appendAll(nil, req)
+	if len(credentials) == 0 {
+		return "", found
+	}
+	return credentials[0].value, true
+}
+
+func (e *webSocketExtractor) Strip(req *http.Request) {
+	credentials, _ := e.appendAll(nil, req)
+	for _, c := range credentials {
+		e.stripValue(req, c.raw)
+	}
+}
+
+func (e *webSocketExtractor) appendAll(credentials []credential, req *http.Request) ([]credential, bool) {
+	if !isWebSocketUpgrade(req) {
+		return credentials, false
+	}
+
+	found := false
+	for _, value := range req.Header.Values(webSocketProtocolHeader) {
+		for _, protocol := range strings.Split(value, ",") {
+			protocol = strings.TrimSpace(protocol)
+			if key, ok := strings.CutPrefix(protocol, webSocketKeyPrefix); ok {
+				credentials = append(credentials, credential{extractor: e, source: sourceWebSocket, value: key, raw: protocol})
+				found = true
+			}
+		}
+	}
+	return credentials, found
+}
+
+// stripValue removes the subprotocol raw, keeping the others so that the
+// upstream can still pick one.
+func (e *webSocketExtractor) stripValue(req *http.Request, raw string) {
+	var kept []string
+	for _, value := range req.Header.Values(webSocketProtocolHeader) {
+		for _, protocol := range strings.Split(value, ",") {
+			protocol = strings.TrimSpace(protocol)
+			if protocol != "" && protocol != raw {
+				kept = append(kept, protocol)
+			}
+		}
+	}
+
+	req.Header.Del(webSocketProtocolHeader)
+	if len(kept) > 0 {
+		req.Header.Set(webSocketProtocolHeader, strings.Join(kept, ", "))
+	}
+}
+
+// isWebSocketUpgrade reports whether req is the handshake of a WebSocket
+// connection.
+func isWebSocketUpgrade(req *http.Request) bool {
+	for _, value := range req.Header.Values("Upgrade") {
+		for _, protocol := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(protocol), "websocket") {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,127 @@
+package swissknife
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+//nolint:all
+type AccessWindow struct {
+	Days     []string `json:"days,omitempty"`
+	Start    string   `json:"start,omitempty"`
+	End      string   `json:"end,omitempty"`
+	Timezone string   `json:"timezone,omitempty"`
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// accessWindow is a time range, in minutes since midnight in loc, on the
+// given days. A window whose end is before its start wraps midnight, and
+// belongs to the day it starts on.
+type accessWindow struct {
+	days  [7]bool
+	start int
+	end   int
+	loc   *time.Location
+}
+
+func compileAccessWindows(windows []AccessWindow) ([]accessWindow, error) {
+	compiled := make([]accessWindow, 0, len(windows))
+	for i, window := range windows {
+		w, err := compileAccessWindow(window)
+		if err != nil {
+			return nil, fmt.Errorf("invalid access window at index %d: %w", i, err)
+		}
+		compiled = append(compiled, w)
+	}
+	return compiled, nil
+}
+
+func compileAccessWindow(window AccessWindow) (accessWindow, error) {
+	var w accessWindow
+	if len(window.Days) == 0 {
+		for d := range w.days {
+			w.days[d] = true
+		}
+	}
+	for _, day := range window.Days {
+		d, ok := parseWeekday(day)
+		if !ok {
+			return w, fmt.Errorf("unknown day %q", day)
+		}
+		w.days[d] = true
+	}
+
+	var err error
+	if w.start, err = minuteOfDay(window.Start); err != nil {
+		return w, fmt.Errorf("invalid start: %w", err)
+	}
+	if w.end, err = minuteOfDay(window.End); err != nil {
+		return w, fmt.Errorf("invalid end: %w", err)
+	}
+	if w.start == w.end {
+		return w, errors.New("start and end must differ")
+	}
+
+	w.loc = time.UTC
+	if window.Timezone != "" {
+		if w.loc, err = time.LoadLocation(window.Timezone); err != nil {
+			return w, fmt.Errorf("invalid timezone: %w", err)
+		}
+	}
+	return w, nil
+}
+
+// parseWeekday parses a day of the week, either abbreviated to three letters
+// or in full, in any case.
+func parseWeekday(day string) (time.Weekday, bool) {
+	name := strings.ToLower(strings.TrimSpace(day))
+	if len(name) < 3 {
+		return 0, false
+	}
+	d, ok := weekdays[name[:3]]
+	if !ok || (len(name) > 3 && name != strings.ToLower(d.String())) {
+		return 0, false
+	}
+	return d, true
+}
+
+// minuteOfDay parses a time of day written HH:MM.
+func minuteOfDay(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a time of day as HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func (w accessWindow) contains(now time.Time) bool {
+	now = now.In(w.loc)
+	minute := now.Hour()*60 + now.Minute()
+	if w.start < w.end {
+		return w.days[now.Weekday()] && minute >= w.start && minute < w.end
+	}
+	// The window wraps midnight: it is open from its start until midnight
+	// on its days, and from midnight until its end on the following days.
+	yesterday := (now.Weekday() + 6) % 7
+	return (w.days[now.Weekday()] && minute >= w.start) || (w.days[yesterday] && minute < w.end)
+}
+
+func inAnyAccessWindow(windows []accessWindow, now time.Time) bool {
+	for _, w := range windows {
+		if w.contains(now) {
+			return true
+		}
+	}
+	return false
+}